@@ -0,0 +1,579 @@
+// Package client is a Go client for the wikiracer HTTP API, which is
+// described by the OpenAPI specification served at /openapi.json.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A Client sends requests to the wikiracer server at BaseURL.
+type Client struct {
+	// e.g. http://localhost:8000
+	BaseURL string
+	// sent as a bearer token if not empty
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// An Error is a response with an unsuccessful status code.
+type Error struct {
+	StatusCode int
+	// e.g. page_not_found; empty if the server didn't say
+	Kind    string
+	Message string
+	// close matches for the titles of a race which don't exist
+	Suggestions map[string][]string
+}
+
+func (e *Error) Error() string {
+	if e.Kind == "" {
+		return fmt.Sprintf("wikiracer: status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("wikiracer: status %d: %s: %s", e.StatusCode, e.Kind, e.Message)
+}
+
+// RaceOptions are the parameters of a race. Zero values leave the server's
+// defaults.
+type RaceOptions struct {
+	TimeLimit       time.Duration
+	ForwardWorkers  int
+	BackwardWorkers int
+	AllLinks        bool
+	// the namespaces to explore; articles if empty
+	Namespaces []int
+	// explore every namespace, overriding Namespaces
+	AllNamespaces bool
+	Seed          int64
+	Deterministic bool
+	// e.g. link or category
+	Edges []string
+	// ignore paths found before
+	NoCache bool
+	// keep the pages explored for /races/{id}/graph
+	Graph bool
+	// save the search state if no path is found, so that it can be resumed
+	Checkpoint bool
+	// have the race explored by the server's nodes
+	Distributed bool
+	// describe the pages and hops of the path
+	Enrich bool
+	// with Enrich, add a summary of every page
+	Extracts bool
+}
+
+// query returns the query parameters for o.
+func (o RaceOptions) query() url.Values {
+	q := url.Values{}
+	if o.TimeLimit > 0 {
+		q.Set("timelimit", o.TimeLimit.String())
+	}
+	if o.ForwardWorkers > 0 {
+		q.Set("forwardworkers", strconv.Itoa(o.ForwardWorkers))
+	}
+	if o.BackwardWorkers > 0 {
+		q.Set("backwardworkers", strconv.Itoa(o.BackwardWorkers))
+	}
+	if o.AllLinks {
+		q.Set("alllinks", "1")
+	}
+	if o.AllNamespaces {
+		q.Set("namespaces", "*")
+	} else if len(o.Namespaces) > 0 {
+		namespaces := make([]string, len(o.Namespaces))
+		for i, ns := range o.Namespaces {
+			namespaces[i] = strconv.Itoa(ns)
+		}
+		q.Set("namespaces", strings.Join(namespaces, "|"))
+	}
+	if o.Seed != 0 {
+		q.Set("seed", strconv.FormatInt(o.Seed, 10))
+	}
+	if o.Deterministic {
+		q.Set("deterministic", "1")
+	}
+	if len(o.Edges) > 0 {
+		q.Set("edges", strings.Join(o.Edges, "|"))
+	}
+	flags := []struct {
+		name string
+		set  bool
+	}{
+		{"nocache", o.NoCache},
+		{"graph", o.Graph},
+		{"checkpoint", o.Checkpoint},
+		{"distributed", o.Distributed},
+		{"enrich", o.Enrich},
+		{"extracts", o.Extracts},
+	}
+	for _, flag := range flags {
+		if flag.set {
+			q.Set(flag.name, "1")
+		}
+	}
+	return q
+}
+
+// Config is the effective configuration a race ran with.
+type Config struct {
+	TimeLimit       string   `json:"time_limit"`
+	ForwardWorkers  int      `json:"forward_workers"`
+	BackwardWorkers int      `json:"backward_workers"`
+	AllLinks        bool     `json:"all_links"`
+	Namespaces      []int    `json:"namespaces"`
+	Edges           []string `json:"edges"`
+	Seed            int64    `json:"seed"`
+	Deterministic   bool     `json:"deterministic"`
+}
+
+// An Approach is a page explored by a race which didn't find a path.
+type Approach struct {
+	Title string `json:"title"`
+	Links int    `json:"links"`
+	Hops  int    `json:"hops"`
+}
+
+// Partial describes how far a race got before it gave up.
+type Partial struct {
+	ForwardPages       int        `json:"forward_pages"`
+	BackwardPages      int        `json:"backward_pages"`
+	ForwardFrontier    int        `json:"forward_frontier"`
+	BackwardFrontier   int        `json:"backward_frontier"`
+	ForwardApproaches  []Approach `json:"forward_approaches"`
+	BackwardApproaches []Approach `json:"backward_approaches"`
+	BestGuess          []string   `json:"best_guess"`
+}
+
+// A Page is a page of an enriched path.
+type Page struct {
+	Title     string `json:"title"`
+	PageID    int64  `json:"pageid"`
+	Namespace int    `json:"ns"`
+	Extract   string `json:"extract"`
+}
+
+// A Hop is a link of an enriched path.
+type Hop struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	AnchorText string `json:"anchor_text"`
+	Context    string `json:"context"`
+}
+
+// A RaceResult is the response to a race.
+type RaceResult struct {
+	// empty if no path was found
+	Path  []string `json:"path"`
+	Edges []string `json:"edges"`
+	// why no path was found
+	Message   string `json:"message"`
+	TimeTaken string `json:"time_taken"`
+	Config    Config `json:"config"`
+	// empty if the path was found before
+	ID           string   `json:"id"`
+	Partial      *Partial `json:"partial"`
+	Checkpointed bool     `json:"checkpointed"`
+	ResumedFrom  string   `json:"resumed_from"`
+	Pages        []Page   `json:"pages"`
+	Hops         []Hop    `json:"hops"`
+	EnrichError  string   `json:"enrich_error"`
+}
+
+// A Pair is a race of a batch.
+type Pair struct {
+	StartTitle string `json:"starttitle"`
+	EndTitle   string `json:"endtitle"`
+}
+
+// A BatchResult is the outcome of one pair of a batch.
+type BatchResult struct {
+	// the position of the pair in the batch
+	Index      int      `json:"index"`
+	StartTitle string   `json:"starttitle"`
+	EndTitle   string   `json:"endtitle"`
+	Path       []string `json:"path"`
+	TimeTaken  string   `json:"time_taken"`
+	ID         string   `json:"id"`
+	Message    string   `json:"message"`
+	// why the pair couldn't be raced
+	Error   string   `json:"error"`
+	Partial *Partial `json:"partial"`
+}
+
+// A Verification says whether every page of a path links to the next one.
+type Verification struct {
+	Valid bool `json:"valid"`
+	// the index of the first page which doesn't link to the next one, or -1
+	BrokenHop int    `json:"broken_hop"`
+	Message   string `json:"message"`
+}
+
+// A Distance is an entry of a DistanceMatrix.
+type Distance struct {
+	// nil if the pages aren't connected within the time limit
+	Hops  *int `json:"hops"`
+	Exact bool `json:"exact"`
+}
+
+// A DistanceMatrix holds the number of links between every pair of pages.
+type DistanceMatrix struct {
+	Titles    []string     `json:"titles"`
+	Distances [][]Distance `json:"distances"`
+	TimeTaken string       `json:"time_taken"`
+	Config    Config       `json:"config"`
+}
+
+// A NeighborhoodLevel is the pages a number of links away from a page.
+type NeighborhoodLevel struct {
+	Distance int      `json:"distance"`
+	Pages    []string `json:"pages"`
+}
+
+// A NeighborhoodSummary ends a streamed neighborhood.
+type NeighborhoodSummary struct {
+	Total     int    `json:"total"`
+	Truncated bool   `json:"truncated"`
+	TimeTaken string `json:"time_taken"`
+	Error     string `json:"error"`
+}
+
+// NeighborhoodOptions are the parameters of a neighborhood. Zero values leave
+// the server's defaults.
+type NeighborhoodOptions struct {
+	Depth int
+	// follow links to the page rather than from it
+	In         bool
+	MaxNodes   int
+	Namespaces []int
+}
+
+// do sends a request to path and returns the response if its status is
+// successful. Otherwise the response is closed and returned as an *Error.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, responseError(resp)
+}
+
+// errorBody is an error as the server describes it.
+type errorBody struct {
+	Kind        string              `json:"kind"`
+	Message     string              `json:"message"`
+	Suggestions map[string][]string `json:"suggestions"`
+}
+
+// fill copies b to e.
+func (b errorBody) fill(e *Error) {
+	e.Kind = b.Kind
+	e.Message = b.Message
+	e.Suggestions = b.Suggestions
+}
+
+// responseError reads the error in resp, which is JSON for most endpoints and
+// plain text for the others.
+func responseError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var output struct {
+		Error errorBody `json:"error"`
+	}
+	if json.Unmarshal(body, &output) == nil && output.Error.Kind != "" {
+		output.Error.fill(e)
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+// getJSON decodes the response to a request into v.
+func (c *Client) getJSON(ctx context.Context, method string, path string, query url.Values, body io.Reader, v interface{}) error {
+	contentType := ""
+	if body != nil {
+		contentType = "application/json"
+	}
+	resp, err := c.do(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return errors.WithStack(json.NewDecoder(resp.Body).Decode(v))
+}
+
+// RunRace finds a path from startTitle to endTitle.
+func (c *Client) RunRace(ctx context.Context, startTitle string, endTitle string, options RaceOptions) (*RaceResult, error) {
+	query := options.query()
+	query.Set("starttitle", startTitle)
+	query.Set("endtitle", endTitle)
+	var result RaceResult
+	if err := c.getJSON(ctx, "GET", "/race", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RaceProgress is the number of pages a race has reached from each side.
+type RaceProgress struct {
+	ForwardPages  int    `json:"forward_pages"`
+	BackwardPages int    `json:"backward_pages"`
+	Elapsed       string `json:"elapsed"`
+}
+
+// StreamRace finds a path from startTitle to endTitle like RunRace, and calls
+// progress with the number of pages reached from each side while the race
+// runs. The race is cancelled if ctx is done or progress returns an error.
+func (c *Client) StreamRace(ctx context.Context, startTitle string, endTitle string, options RaceOptions,
+	progress func(RaceProgress) error) (*RaceResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	query := options.query()
+	query.Set("starttitle", startTitle)
+	query.Set("endtitle", endTitle)
+	query.Set("stream", "1")
+	resp, err := c.do(ctx, "GET", "/race", query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result *RaceResult
+	err = streamLines(resp.Body, func(line []byte) error {
+		var event struct {
+			Type string `json:"type"`
+			RaceProgress
+			Result *RaceResult `json:"result"`
+			Error  errorBody   `json:"error"`
+		}
+		if err := json.Unmarshal(line, &event); err != nil {
+			return errors.WithStack(err)
+		}
+		switch event.Type {
+		case "progress":
+			return progress(event.RaceProgress)
+		case "result":
+			result = event.Result
+		case "error":
+			// the status was sent before the race failed
+			e := &Error{StatusCode: resp.StatusCode}
+			event.Error.fill(e)
+			return e
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, errors.New("the race ended without a result")
+	}
+	return result, nil
+}
+
+// Suggest returns up to n titles close to query, which may be mistyped. n is
+// capped by the server.
+func (c *Client) Suggest(ctx context.Context, query string, n int) ([]string, error) {
+	q := url.Values{}
+	q.Set("q", query)
+	q.Set("limit", strconv.Itoa(n))
+	var output struct {
+		Suggestions []string `json:"suggestions"`
+	}
+	if err := c.getJSON(ctx, "GET", "/suggest", q, nil, &output); err != nil {
+		return nil, err
+	}
+	return output.Suggestions, nil
+}
+
+// Verify checks that every page of path links to the next one. Paths may go
+// through any namespace unless namespaces are given.
+func (c *Client) Verify(ctx context.Context, path []string, namespaces ...int) (*Verification, error) {
+	query := RaceOptions{Namespaces: namespaces}.query()
+	query.Set("path", strings.Join(path, "|"))
+	var verification Verification
+	if err := c.getJSON(ctx, "GET", "/verify", query, nil, &verification); err != nil {
+		return nil, err
+	}
+	return &verification, nil
+}
+
+// Batch races every pair as one job and calls handle with each result as soon
+// as it finishes, which isn't necessarily in the order of pairs. It returns
+// when every result has been handled or handle returns an error.
+func (c *Client) Batch(ctx context.Context, pairs []Pair, options RaceOptions, handle func(BatchResult) error) error {
+	body, err := json.Marshal(pairs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := c.do(ctx, "POST", "/races/batch", options.query(), bytes.NewReader(body), "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return streamLines(resp.Body, func(line []byte) error {
+		var result BatchResult
+		if err := json.Unmarshal(line, &result); err != nil {
+			return errors.WithStack(err)
+		}
+		return handle(result)
+	})
+}
+
+// Snapshot returns the search state of a race run with Checkpoint, which can
+// be passed to Resume on any server.
+func (c *Client) Snapshot(ctx context.Context, id string) (json.RawMessage, error) {
+	var snapshot json.RawMessage
+	if err := c.getJSON(ctx, "GET", "/races/"+url.PathEscape(id)+"/snapshot", nil, nil, &snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Resume continues the race id from its snapshot. If snapshot is nil, the
+// snapshot saved by the server is used. Only the time limit, workers, Graph,
+// Checkpoint, Enrich and Extracts options apply.
+func (c *Client) Resume(ctx context.Context, id string, snapshot json.RawMessage, options RaceOptions) (*RaceResult, error) {
+	var body io.Reader
+	if snapshot != nil {
+		body = bytes.NewReader(snapshot)
+	}
+	var result RaceResult
+	if err := c.getJSON(ctx, "POST", "/races/"+url.PathEscape(id)+"/resume", options.query(), body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Distance returns the number of links between every pair of titles.
+func (c *Client) Distance(ctx context.Context, titles []string, options RaceOptions) (*DistanceMatrix, error) {
+	query := options.query()
+	query.Set("titles", strings.Join(titles, "|"))
+	var matrix DistanceMatrix
+	if err := c.getJSON(ctx, "GET", "/distance", query, nil, &matrix); err != nil {
+		return nil, err
+	}
+	return &matrix, nil
+}
+
+// StreamNeighborhood explores the pages around title and calls handle with
+// each level as soon as it has been explored. It returns the summary the
+// server sends once it is done, or stops early if handle returns an error.
+func (c *Client) StreamNeighborhood(ctx context.Context, title string, options NeighborhoodOptions,
+	handle func(NeighborhoodLevel) error) (*NeighborhoodSummary, error) {
+	query := RaceOptions{Namespaces: options.Namespaces}.query()
+	query.Set("title", title)
+	query.Set("stream", "1")
+	if options.Depth > 0 {
+		query.Set("depth", strconv.Itoa(options.Depth))
+	}
+	if options.In {
+		query.Set("direction", "in")
+	}
+	if options.MaxNodes > 0 {
+		query.Set("maxnodes", strconv.Itoa(options.MaxNodes))
+	}
+	resp, err := c.do(ctx, "GET", "/neighborhood", query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var summary *NeighborhoodSummary
+	err = streamLines(resp.Body, func(line []byte) error {
+		// the summary is the only line without pages
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return errors.WithStack(err)
+		}
+		if _, ok := fields["pages"]; !ok {
+			summary = &NeighborhoodSummary{}
+			return errors.WithStack(json.Unmarshal(line, summary))
+		}
+		var level NeighborhoodLevel
+		if err := json.Unmarshal(line, &level); err != nil {
+			return errors.WithStack(err)
+		}
+		return handle(level)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if summary == nil {
+		return nil, errors.New("the neighborhood ended without a summary")
+	}
+	if summary.Error != "" {
+		return summary, errors.New(summary.Error)
+	}
+	return summary, nil
+}
+
+// Health returns nil if the server is healthy.
+func (c *Client) Health(ctx context.Context) error {
+	resp, err := c.do(ctx, "GET", "/health", nil, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// streamLines calls handle with every non-empty line of r.
+func streamLines(r io.Reader, handle func(line []byte) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if handleErr := handle(line); handleErr != nil {
+				return handleErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
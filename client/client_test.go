@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRaceOptionsQuery(t *testing.T) {
+	options := RaceOptions{
+		TimeLimit:     10 * time.Second,
+		Namespaces:    []int{0, 14},
+		Seed:          42,
+		Deterministic: true,
+		Edges:         []string{"link", "category"},
+		NoCache:       true,
+		Enrich:        true,
+	}
+	expected := "deterministic=1&edges=link%7Ccategory&enrich=1&namespaces=0%7C14&nocache=1&seed=42&timelimit=10s"
+	if got := options.query().Encode(); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	options.AllNamespaces = true
+	if got := options.query().Get("namespaces"); got != "*" {
+		t.Errorf("AllNamespaces should override Namespaces, got %q", got)
+	}
+	if got := (RaceOptions{}).query().Encode(); got != "" {
+		t.Errorf("zero options should leave the defaults, got %s", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error": {"kind": "unauthorized", "message": "a valid API key is required"}}`)
+			return
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		io.WriteString(w, "Must pass a titles argument.")
+	}))
+	defer server.Close()
+	c := New(server.URL + "/")
+
+	_, err := c.Distance(context.Background(), nil, RaceOptions{})
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusUnauthorized || e.Kind != "unauthorized" || e.Message != "a valid API key is required" {
+		t.Errorf("expected an unauthorized error, got %#v", err)
+	}
+
+	c.APIKey = "secret"
+	_, err = c.Distance(context.Background(), nil, RaceOptions{})
+	if !errors.As(err, &e) || e.StatusCode != http.StatusUnprocessableEntity || e.Kind != "" || e.Message != "Must pass a titles argument." {
+		t.Errorf("expected the plain text error, got %#v", err)
+	}
+}
+
+func TestStreamNeighborhood(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") != "1" || r.URL.Query().Get("direction") != "in" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		io.WriteString(w, `{"distance":0,"pages":["a"]}`+"\n")
+		io.WriteString(w, `{"distance":1,"pages":["b","c"]}`+"\n")
+		io.WriteString(w, `{"total":3,"truncated":true,"time_taken":"1ms"}`+"\n")
+	}))
+	defer server.Close()
+
+	var levels []NeighborhoodLevel
+	summary, err := New(server.URL).StreamNeighborhood(context.Background(), "a", NeighborhoodOptions{In: true},
+		func(level NeighborhoodLevel) error {
+			levels = append(levels, level)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NeighborhoodLevel{{Distance: 0, Pages: []string{"a"}}, {Distance: 1, Pages: []string{"b", "c"}}}
+	if !reflect.DeepEqual(levels, expected) {
+		t.Errorf("expected levels %v, got %v", expected, levels)
+	}
+	if summary.Total != 3 || !summary.Truncated {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
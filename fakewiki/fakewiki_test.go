@@ -0,0 +1,184 @@
+package fakewiki
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+)
+
+func loadTestGraph(t *testing.T) *Graph {
+	g, err := LoadGraph("testdata/small.graph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// get queries the server and returns the status code and body.
+func get(t *testing.T, s *Server, params string) (int, []byte) {
+	resp, err := http.Get(s.APIURL() + "?" + params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+// titles returns the titles listed under key in the first page of body.
+func titles(t *testing.T, body []byte, key string) []string {
+	var ret []string
+	jsonparser.ArrayEach(body, func(link []byte, dataType jsonparser.ValueType, offset int, err error) {
+		title, _ := jsonparser.GetString(link, "title")
+		ret = append(ret, title)
+	}, "query", "pages", "[0]", key)
+	return ret
+}
+
+func TestParseGraph(t *testing.T) {
+	g := loadTestGraph(t)
+
+	expected := []string{"Footloose", "Philadelphia", "Category:American male film actors"}
+	if links := g.Links("Kevin Bacon"); !reflect.DeepEqual(links, expected) {
+		t.Errorf("Kevin Bacon links to %v instead of %v", links, expected)
+	}
+	if links := g.Links("Lori Singer"); len(links) != 0 {
+		t.Errorf("Lori Singer should not link anywhere but links to %v", links)
+	}
+
+	if _, err := ParseGraph(strings.NewReader(" -> Kevin Bacon")); err == nil {
+		t.Error("a line without a source page should not parse")
+	}
+}
+
+func TestLinksAndLinksHere(t *testing.T) {
+	s := NewServer(loadTestGraph(t))
+	defer s.Close()
+
+	_, body := get(t, s, "action=query&prop=links&titles=Aristotle&pllimit=500&format=json&formatversion=2")
+	if links := titles(t, body, "links"); !reflect.DeepEqual(links, []string{"Philosophy", "Logic"}) {
+		t.Errorf("unexpected links %v", links)
+	}
+
+	_, body = get(t, s, "action=query&prop=linkshere&titles=Philosophy&lhlimit=500")
+	if links := titles(t, body, "linkshere"); !reflect.DeepEqual(links, []string{"Aristotle", "Plato"}) {
+		t.Errorf("unexpected linkshere %v", links)
+	}
+}
+
+func TestContinuation(t *testing.T) {
+	s := NewUnstartedServer(loadTestGraph(t))
+	s.MaxLimit = 2
+	s.Start()
+	defer s.Close()
+
+	_, body := get(t, s, "action=query&prop=links&titles=Kevin+Bacon&pllimit=500")
+	if links := titles(t, body, "links"); !reflect.DeepEqual(links, []string{"Footloose", "Philadelphia"}) {
+		t.Errorf("unexpected first batch of links %v", links)
+	}
+	plcontinue, err := jsonparser.GetString(body, "continue", "plcontinue")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, body = get(t, s, "action=query&prop=links&titles=Kevin+Bacon&pllimit=500&continue=%7C%7C&plcontinue="+url.QueryEscape(plcontinue))
+	if links := titles(t, body, "links"); !reflect.DeepEqual(links, []string{"Category:American male film actors"}) {
+		t.Errorf("unexpected second batch of links %v", links)
+	}
+	if _, _, _, err := jsonparser.Get(body, "continue"); err == nil {
+		t.Error("the last batch should not have a continuation")
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	s := NewServer(loadTestGraph(t))
+	defer s.Close()
+
+	_, body := get(t, s, "action=query&prop=links&titles=Kevin+Bacon&pllimit=500&plnamespace=0")
+	if links := titles(t, body, "links"); !reflect.DeepEqual(links, []string{"Footloose", "Philadelphia"}) {
+		t.Errorf("unexpected article links %v", links)
+	}
+	_, body = get(t, s, "action=query&prop=linkshere&titles=Kevin+Bacon&lhlimit=500&lhnamespace=14")
+	if links := titles(t, body, "linkshere"); !reflect.DeepEqual(links, []string{"Category:American male film actors"}) {
+		t.Errorf("unexpected category linkshere %v", links)
+	}
+}
+
+func TestMissingPagesAndRedirects(t *testing.T) {
+	s := NewServer(loadTestGraph(t))
+	defer s.Close()
+
+	_, body := get(t, s, "action=query&prop=links&titles=Nowhere")
+	if missing, _ := jsonparser.GetBoolean(body, "query", "pages", "[0]", "missing"); !missing {
+		t.Errorf("Nowhere should be missing: %s", body)
+	}
+
+	_, body = get(t, s, "action=query&prop=links&titles=Greek+philosophy")
+	if links := titles(t, body, "links"); !reflect.DeepEqual(links, []string{"Ancient Greece"}) {
+		t.Errorf("a redirect should link to its target but links to %v", links)
+	}
+
+	_, body = get(t, s, "action=query&prop=links&titles=Greek+philosophy&redirects=1")
+	if title, _ := jsonparser.GetString(body, "query", "pages", "[0]", "title"); title != "Ancient Greece" {
+		t.Errorf("the redirect should have been resolved to Ancient Greece, not %s", title)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := NewUnstartedServer(loadTestGraph(t))
+	s.RateLimitEvery = 2
+	s.Start()
+	defer s.Close()
+
+	if status, _ := get(t, s, "action=query&prop=links&titles=Plato"); status != http.StatusOK {
+		t.Errorf("first request should succeed but got %d", status)
+	}
+	if status, _ := get(t, s, "action=query&prop=links&titles=Plato"); status != http.StatusTooManyRequests {
+		t.Errorf("second request should be rate limited but got %d", status)
+	}
+	if s.Requests() != 2 {
+		t.Errorf("server should have counted 2 requests but counted %d", s.Requests())
+	}
+}
+
+// listed returns the titles listed under key in body.
+func listed(body []byte, key string) []string {
+	var ret []string
+	jsonparser.ArrayEach(body, func(page []byte, dataType jsonparser.ValueType, offset int, err error) {
+		title, _ := jsonparser.GetString(page, "title")
+		ret = append(ret, title)
+	}, "query", key)
+	return ret
+}
+
+func TestLists(t *testing.T) {
+	s := NewServer(loadTestGraph(t))
+	defer s.Close()
+
+	_, body := get(t, s, "action=query&list=allpages&apfrom=Ph&aplimit=2&apfilterredir=nonredirects")
+	if pages := listed(body, "allpages"); !reflect.DeepEqual(pages, []string{"Philadelphia", "Philosophy"}) {
+		t.Errorf("allpages listed %v", pages)
+	}
+
+	_, body = get(t, s, "action=query&list=allpages&apfrom=Gr&aplimit=1&apfilterredir=nonredirects")
+	if pages := listed(body, "allpages"); !reflect.DeepEqual(pages, []string{"Kevin Bacon"}) {
+		t.Errorf("allpages should skip redirects but listed %v", pages)
+	}
+
+	_, body = get(t, s, "action=query&list=random&rnlimit=3&rnnamespace=14")
+	if pages := listed(body, "random"); !reflect.DeepEqual(pages, []string{"Category:American male film actors"}) {
+		t.Errorf("random should only list pages in the namespace but listed %v", pages)
+	}
+	_, body = get(t, s, "action=query&list=random&rnlimit=3")
+	if pages := listed(body, "random"); len(pages) != 3 {
+		t.Errorf("random should list 3 pages but listed %v", pages)
+	}
+}
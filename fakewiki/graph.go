@@ -0,0 +1,160 @@
+package fakewiki
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// namespacePrefixes maps title prefixes to MediaWiki namespace numbers. Titles
+// without one of these prefixes are articles (namespace 0).
+var namespacePrefixes = map[string]int{
+	"Talk:":      1,
+	"User:":      2,
+	"Wikipedia:": 4,
+	"File:":      6,
+	"Template:":  10,
+	"Help:":      12,
+	"Category:":  14,
+	"Portal:":    100,
+}
+
+// namespace returns the namespace of a title.
+func namespace(title string) int {
+	if i := strings.Index(title, ":"); i > 0 {
+		if ns, ok := namespacePrefixes[title[:i+1]]; ok {
+			return ns
+		}
+	}
+	return 0
+}
+
+// A Graph is a small, hand-written model of Wikipedia.
+type Graph struct {
+	// pages which exist
+	pages map[string]bool
+	// mapping of pages to the pages they link to, in definition order
+	links map[string][]string
+	// mapping of pages to the pages which link to them, sorted
+	linksHere map[string][]string
+	// mapping of redirect pages to their targets
+	redirects map[string]string
+}
+
+// NewGraph returns an empty graph.
+func NewGraph() *Graph {
+	return &Graph{
+		pages:     make(map[string]bool),
+		links:     make(map[string][]string),
+		linksHere: make(map[string][]string),
+		redirects: make(map[string]string),
+	}
+}
+
+// AddLink adds a link from one page to another. Both pages are created if
+// they don't exist yet.
+func (g *Graph) AddLink(from string, to string) {
+	g.pages[from] = true
+	g.pages[to] = true
+	g.links[from] = append(g.links[from], to)
+	g.linksHere[to] = append(g.linksHere[to], from)
+	sort.Strings(g.linksHere[to])
+}
+
+// AddRedirect makes from a redirect to to. Like on Wikipedia, the redirect
+// page links to its target.
+func (g *Graph) AddRedirect(from string, to string) {
+	g.redirects[from] = to
+	g.AddLink(from, to)
+}
+
+// AddPage creates a page without any links.
+func (g *Graph) AddPage(title string) {
+	g.pages[title] = true
+}
+
+// Pages returns the title of every page in sorted order.
+func (g *Graph) Pages() []string {
+	pages := make([]string, 0, len(g.pages))
+	for page := range g.pages {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+	return pages
+}
+
+// Links returns the pages linked from title.
+func (g *Graph) Links(title string) []string {
+	return g.links[title]
+}
+
+// LinksHere returns the pages which link to title, in sorted order.
+func (g *Graph) LinksHere(title string) []string {
+	return g.linksHere[title]
+}
+
+// Exists returns true if title is a page.
+func (g *Graph) Exists(title string) bool {
+	return g.pages[title]
+}
+
+// ParseGraph reads a graph definition. Each non-empty line which doesn't
+// start with # is one of:
+//
+//	Page -> Linked page | Another linked page
+//	Redirect => Target
+//	Page
+//
+// The last form creates a page without any links. Pages which are never
+// mentioned are missing.
+func ParseGraph(r io.Reader) (*Graph, error) {
+	g := NewGraph()
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if parts := strings.SplitN(line, "=>", 2); len(parts) == 2 {
+			from, to := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			if from == "" || to == "" {
+				return nil, errors.Errorf("line %d: a redirect needs a source and a target", lineNumber)
+			}
+			g.AddRedirect(from, to)
+		} else if parts := strings.SplitN(line, "->", 2); len(parts) == 2 {
+			from := strings.TrimSpace(parts[0])
+			if from == "" {
+				return nil, errors.Errorf("line %d: links need a source page", lineNumber)
+			}
+			g.AddPage(from)
+			for _, to := range strings.Split(parts[1], "|") {
+				if to = strings.TrimSpace(to); to != "" {
+					g.AddLink(from, to)
+				}
+			}
+		} else {
+			g.AddPage(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return g, nil
+}
+
+// LoadGraph reads a graph definition from a file.
+func LoadGraph(path string) (*Graph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	return ParseGraph(f)
+}
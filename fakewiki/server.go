@@ -0,0 +1,363 @@
+// Package fakewiki implements a local stand-in for the MediaWiki API, backed
+// by a small graph definition. It serves the subset of action=query which
+// wikiracer uses (prop=links, prop=linkshere, list=random, list=allpages,
+// list=prefixsearch, and queries of titles without a prop) so that
+// integration tests can run without the network.
+package fakewiki
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	titleindex "github.com/sandlerben/wikiracer/titles"
+)
+
+// A Server is an httptest.Server which answers MediaWiki API queries about a
+// Graph.
+type Server struct {
+	*httptest.Server
+	Graph *Graph
+
+	// Latency is added to every response.
+	Latency time.Duration
+	// If RateLimitEvery is n > 0, every nth request is answered with
+	// 429 Too Many Requests.
+	RateLimitEvery int
+	// MaxLimit caps the number of links in one response so that clients have
+	// to follow continuations (default 500, like the real API).
+	MaxLimit int
+
+	requests int64
+	// guards Latency, RateLimitEvery and MaxLimit once the server has started
+	mu sync.RWMutex
+}
+
+// NewUnstartedServer returns a Server which has not started yet, so that its
+// fields can be changed before calling Start.
+func NewUnstartedServer(g *Graph) *Server {
+	s := &Server{Graph: g, MaxLimit: 500}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewServer starts and returns a Server for g.
+func NewServer(g *Graph) *Server {
+	s := NewUnstartedServer(g)
+	s.Start()
+	return s
+}
+
+// APIURL returns the URL of the server's api.php endpoint.
+func (s *Server) APIURL() string {
+	return s.URL + "/w/api.php"
+}
+
+// Requests returns the number of requests the server has received.
+func (s *Server) Requests() int {
+	return int(atomic.LoadInt64(&s.requests))
+}
+
+// SetLatency changes Latency while the server is running.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.Latency = d
+	s.mu.Unlock()
+}
+
+// SetRateLimitEvery changes RateLimitEvery while the server is running.
+func (s *Server) SetRateLimitEvery(n int) {
+	s.mu.Lock()
+	s.RateLimitEvery = n
+	s.mu.Unlock()
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	n := atomic.AddInt64(&s.requests, 1)
+
+	s.mu.RLock()
+	latency, rateLimitEvery, maxLimit := s.Latency, s.RateLimitEvery, s.MaxLimit
+	s.mu.RUnlock()
+
+	time.Sleep(latency)
+	if rateLimitEvery > 0 && n%int64(rateLimitEvery) == 0 {
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, "Too Many Requests")
+		return
+	}
+	if r.URL.Path != "/w/api.php" {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("action") != "query" {
+		writeAPIError(w, "badvalue", "only action=query is supported")
+		return
+	}
+
+	var response map[string]interface{}
+	switch list := q.Get("list"); list {
+	case "":
+	case "random":
+		response = s.random(q)
+	case "allpages":
+		response = s.allPages(q)
+	case "prefixsearch":
+		response = s.prefixSearch(q)
+	default:
+		writeAPIError(w, "badvalue", "unsupported list "+list)
+		return
+	}
+	if response != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	switch prop := q.Get("prop"); prop {
+	case "":
+		response = s.pageInfo(q)
+	case "links":
+		response = s.query(q, "links", "pl", s.Graph.links, maxLimit)
+	case "linkshere":
+		response = s.query(q, "linkshere", "lh", s.Graph.linksHere, maxLimit)
+	default:
+		writeAPIError(w, "badvalue", "unsupported prop "+prop)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(response)
+}
+
+// query answers a prop=links or prop=linkshere query. prefix is the parameter
+// prefix of the module (pl or lh) and neighbors maps each page to the pages
+// listed under key. The pltitles filter is supported as well.
+func (s *Server) query(q map[string][]string, key string, prefix string, neighbors map[string][]string, maxLimit int) map[string]interface{} {
+	get := func(name string) string {
+		if v := q[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	result := make(map[string]interface{})
+	title := get("titles")
+	if _, ok := q["redirects"]; ok {
+		if target, isRedirect := s.Graph.redirects[title]; isRedirect {
+			result["redirects"] = []map[string]string{{"from": title, "to": target}}
+			title = target
+		}
+	}
+
+	page := map[string]interface{}{
+		"ns":    namespace(title),
+		"title": title,
+	}
+	if !s.Graph.pages[title] {
+		page["missing"] = true
+		result["pages"] = []interface{}{page}
+		return map[string]interface{}{"batchcomplete": true, "query": result}
+	}
+
+	var filtered []string
+	allowed := namespaceFilter(get(prefix + "namespace"))
+	var only map[string]bool
+	if titles := get(prefix + "titles"); titles != "" {
+		only = make(map[string]bool)
+		for _, t := range strings.Split(titles, "|") {
+			only[t] = true
+		}
+	}
+	for _, neighbor := range neighbors[title] {
+		if (allowed == nil || allowed[namespace(neighbor)]) && (only == nil || only[neighbor]) {
+			filtered = append(filtered, neighbor)
+		}
+	}
+	if get(prefix+"dir") == "descending" {
+		reversed := make([]string, len(filtered))
+		for i, neighbor := range filtered {
+			reversed[len(filtered)-1-i] = neighbor
+		}
+		filtered = reversed
+	}
+
+	limit := 10
+	if l := get(prefix + "limit"); l == "max" {
+		limit = maxLimit
+	} else if l != "" {
+		limit, _ = strconv.Atoi(l)
+	}
+	if limit <= 0 || limit > maxLimit {
+		limit = maxLimit
+	}
+
+	offset := 0
+	if c := get(prefix + "continue"); c != "" {
+		offset, _ = strconv.Atoi(c[strings.LastIndex(c, "|")+1:])
+	}
+	if offset > len(filtered) {
+		offset = len(filtered)
+	}
+	end := offset + limit
+	if end > len(filtered) {
+		end = len(filtered)
+	}
+
+	if end > offset {
+		links := make([]map[string]interface{}, 0, end-offset)
+		for _, neighbor := range filtered[offset:end] {
+			links = append(links, map[string]interface{}{"ns": namespace(neighbor), "title": neighbor})
+		}
+		page[key] = links
+	}
+	result["pages"] = []interface{}{page}
+
+	response := map[string]interface{}{"query": result}
+	if end < len(filtered) {
+		response["continue"] = map[string]string{
+			prefix + "continue": title + "|" + strconv.Itoa(end),
+			"continue":          "||",
+		}
+	} else {
+		response["batchcomplete"] = true
+	}
+	return response
+}
+
+// listedPages returns the sorted pages in the namespace given by the parameter
+// prefix + "namespace" (articles by default), leaving out redirects if
+// prefix + "filterredir" is nonredirects.
+func (s *Server) listedPages(q url.Values, prefix string) []string {
+	ns := 0
+	if n, err := strconv.Atoi(q.Get(prefix + "namespace")); err == nil {
+		ns = n
+	}
+	var pages []string
+	for _, page := range s.Graph.Pages() {
+		if _, isRedirect := s.Graph.redirects[page]; isRedirect && q.Get(prefix+"filterredir") == "nonredirects" {
+			continue
+		}
+		if namespace(page) == ns {
+			pages = append(pages, page)
+		}
+	}
+	return pages
+}
+
+// random answers a list=random query.
+func (s *Server) random(q url.Values) map[string]interface{} {
+	pages := s.listedPages(q, "rn")
+	limit, err := strconv.Atoi(q.Get("rnlimit"))
+	if err != nil || limit <= 0 {
+		limit = 1
+	}
+	random := make([]map[string]interface{}, 0, limit)
+	for _, i := range rand.Perm(len(pages)) {
+		if len(random) == limit {
+			break
+		}
+		random = append(random, map[string]interface{}{"ns": namespace(pages[i]), "title": pages[i]})
+	}
+	return map[string]interface{}{"batchcomplete": true, "query": map[string]interface{}{"random": random}}
+}
+
+// allPages answers a list=allpages query, without continuations.
+func (s *Server) allPages(q url.Values) map[string]interface{} {
+	limit, err := strconv.Atoi(q.Get("aplimit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	allPages := []map[string]interface{}{}
+	for _, page := range s.listedPages(q, "ap") {
+		if len(allPages) == limit {
+			break
+		}
+		if page >= q.Get("apfrom") {
+			allPages = append(allPages, map[string]interface{}{"ns": namespace(page), "title": page})
+		}
+	}
+	return map[string]interface{}{"batchcomplete": true, "query": map[string]interface{}{"allpages": allPages}}
+}
+
+// prefixSearch answers a list=prefixsearch query. Like the fuzzy profile of
+// the real API, pages within a couple of typos of the search are listed after
+// those which start with it, whatever the profile.
+func (s *Server) prefixSearch(q url.Values) map[string]interface{} {
+	limit, err := strconv.Atoi(q.Get("pslimit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	allowed := namespaceFilter(q.Get("psnamespace"))
+	if allowed == nil && q.Get("psnamespace") == "" {
+		allowed = map[int]bool{0: true}
+	}
+	var pages []string
+	for _, page := range s.Graph.Pages() {
+		if allowed == nil || allowed[namespace(page)] {
+			pages = append(pages, page)
+		}
+	}
+	found := []map[string]interface{}{}
+	for _, page := range titleindex.New(pages).Suggest(q.Get("pssearch"), limit) {
+		found = append(found, map[string]interface{}{"ns": namespace(page), "title": page})
+	}
+	return map[string]interface{}{"batchcomplete": true, "query": map[string]interface{}{"prefixsearch": found}}
+}
+
+// pageInfo answers a query of titles without a prop, which says which of them
+// are missing. Redirects are followed if asked.
+func (s *Server) pageInfo(q url.Values) map[string]interface{} {
+	result := make(map[string]interface{})
+	var redirects []map[string]string
+	pages := []interface{}{}
+	for _, title := range strings.Split(q.Get("titles"), "|") {
+		if _, ok := q["redirects"]; ok {
+			if target, isRedirect := s.Graph.redirects[title]; isRedirect {
+				redirects = append(redirects, map[string]string{"from": title, "to": target})
+				title = target
+			}
+		}
+		page := map[string]interface{}{"ns": namespace(title), "title": title}
+		if !s.Graph.pages[title] {
+			page["missing"] = true
+		}
+		pages = append(pages, page)
+	}
+	if redirects != nil {
+		result["redirects"] = redirects
+	}
+	result["pages"] = pages
+	return map[string]interface{}{"batchcomplete": true, "query": result}
+}
+
+// namespaceFilter parses a namespace parameter like "0|14". It returns nil if
+// every namespace is allowed.
+func namespaceFilter(param string) map[int]bool {
+	if param == "" || param == "*" {
+		return nil
+	}
+	allowed := make(map[int]bool)
+	for _, part := range strings.Split(param, "|") {
+		if ns, err := strconv.Atoi(part); err == nil {
+			allowed[ns] = true
+		}
+	}
+	return allowed
+}
+
+func writeAPIError(w http.ResponseWriter, code string, info string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "info": info},
+	})
+}
@@ -0,0 +1,170 @@
+// Package fixture records MediaWiki API responses to a directory and replays
+// them offline, so that races can be tested and benchmarked without the
+// network.
+package fixture
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// fixture is the on-disk format of one recorded response.
+type fixture struct {
+	Query  string          `json:"query"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// Key returns the query parameters of u encoded in a stable order. Every
+// parameter which may change the content of a response, such as a limit or a
+// namespace, is part of the key. Only the format of the response (format and
+// formatversion) and the direction links are listed in (*dir, which the racer
+// picks at random) are ignored, since a race which explores every link sees
+// the same links either way.
+func Key(u *url.URL) string {
+	q := u.Query()
+	for name := range q {
+		if ignoredParam(name) {
+			q.Del(name)
+		}
+	}
+	return q.Encode()
+}
+
+func ignoredParam(name string) bool {
+	switch {
+	case name == "format" || name == "formatversion":
+		return true
+	case strings.HasSuffix(name, "dir"):
+		return true
+	}
+	return false
+}
+
+// fileName returns the name of the file a response for key is stored in.
+func fileName(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+// A Recorder is an http.RoundTripper which saves every successful response in
+// a fixture directory.
+type Recorder struct {
+	dir       string
+	transport http.RoundTripper
+	// serializes writes to dir
+	mu sync.Mutex
+}
+
+// NewRecorder returns a Recorder which makes requests with transport and saves
+// the responses in dir. If transport is nil, http.DefaultTransport is used.
+func NewRecorder(dir string, transport http.RoundTripper) *Recorder {
+	return &Recorder{dir: dir, transport: transport}
+}
+
+// RoundTrip makes the request and records the response if it succeeded.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := r.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	key := Key(req.URL)
+	var contents bytes.Buffer
+	enc := json.NewEncoder(&contents)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(fixture{Query: key, Status: resp.StatusCode, Body: body}); err != nil {
+		return nil, errors.Wrapf(err, "response to %s is not JSON", key)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(r.dir, fileName(key)), contents.Bytes(), 0644); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return resp, nil
+}
+
+// A Replayer is an http.RoundTripper which serves responses from a fixture
+// directory.
+type Replayer struct {
+	responses map[string]fixture
+	// if Strict is false, requests for pages which were never recorded are
+	// answered as if the page had no links. Otherwise they fail.
+	Strict bool
+}
+
+// NewReplayer loads every fixture in dir.
+func NewReplayer(dir string) (*Replayer, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r := &Replayer{responses: make(map[string]fixture)}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var f fixture
+		if err := json.Unmarshal(contents, &f); err != nil {
+			return nil, errors.Wrapf(err, "could not parse fixture %s", file)
+		}
+		r.responses[f.Query] = f
+	}
+	return r, nil
+}
+
+// RoundTrip answers the request with the matching recorded response.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := Key(req.URL)
+	f, ok := r.responses[key]
+	if !ok {
+		if r.Strict {
+			return nil, errors.Errorf("no response was recorded for %s", key)
+		}
+		title, _ := json.Marshal(req.URL.Query().Get("titles"))
+		f = fixture{
+			Status: http.StatusOK,
+			Body:   json.RawMessage(fmt.Sprintf(`{"batchcomplete":true,"query":{"pages":[{"ns":0,"title":%s}]}}`, title)),
+		}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(f.Body)),
+		ContentLength: int64(len(f.Body)),
+		Request:       req,
+	}, nil
+}
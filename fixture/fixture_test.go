@@ -0,0 +1,93 @@
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	httpmock "gopkg.in/jarcoal/httpmock.v1"
+)
+
+func TestKeyIgnoresFormattingParams(t *testing.T) {
+	a, _ := url.Parse("https://en.wikipedia.org/w/api.php?action=query&prop=links&titles=Go&pllimit=500&pldir=descending&plnamespace=0&format=json")
+	b, _ := url.Parse("https://en.wikipedia.org/w/api.php?titles=Go&prop=links&action=query&formatversion=2&plnamespace=0&pllimit=500")
+	if Key(a) != Key(b) {
+		t.Errorf("keys %q and %q should match", Key(a), Key(b))
+	}
+
+	// each of these lists different links
+	seen := map[string]string{Key(a): a.RawQuery}
+	for _, query := range []string{
+		"action=query&prop=links&titles=Go&pllimit=500&plnamespace=0&plcontinue=123",
+		"action=query&prop=links&titles=Go&pllimit=500&plnamespace=0|14",
+		"action=query&prop=links&titles=Go&pllimit=10&plnamespace=0",
+		"action=query&prop=linkshere&titles=Go&lhlimit=500&lhnamespace=0&lhprop=title",
+		"action=query&prop=linkshere&titles=Go&lhlimit=500&lhnamespace=0&lhprop=pageid",
+	} {
+		u, _ := url.Parse("https://en.wikipedia.org/w/api.php?" + query)
+		if other, ok := seen[Key(u)]; ok {
+			t.Errorf("the keys of %q and %q should not match", query, other)
+		}
+		seen[Key(u)] = query
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	body := `{"query":{"pages":[{"ns":0,"title":"Go","links":[{"ns":0,"title":"Gopher"}]}]}}`
+	httpmock.RegisterResponder("GET", "https://en.wikipedia.org/w/api.php",
+		httpmock.NewStringResponder(200, body))
+
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recordingClient := &http.Client{Transport: NewRecorder(dir, httpmock.DefaultTransport)}
+	resp, err := recordingClient.Get("https://en.wikipedia.org/w/api.php?action=query&prop=links&titles=Go&pldir=ascending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, _ := ioutil.ReadAll(resp.Body)
+	if string(recorded) != body {
+		t.Errorf("recorder changed the response body to %s", recorded)
+	}
+
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayingClient := &http.Client{Transport: replayer}
+	resp, err = replayingClient.Get("https://en.wikipedia.org/w/api.php?action=query&prop=links&titles=Go&pldir=descending&format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, _ := ioutil.ReadAll(resp.Body)
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, replayed); err != nil {
+		t.Fatal(err)
+	}
+	if compacted.String() != body {
+		t.Errorf("replayer returned %s instead of %s", compacted.String(), body)
+	}
+
+	resp, err = replayingClient.Get("https://en.wikipedia.org/w/api.php?action=query&prop=links&titles=Rust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("unrecorded pages should be answered with 200 but got %d", resp.StatusCode)
+	}
+
+	replayer.Strict = true
+	if _, err := replayingClient.Get("https://en.wikipedia.org/w/api.php?action=query&prop=links&titles=Rust"); err == nil {
+		t.Error("a strict replayer should fail for unrecorded pages")
+	}
+}
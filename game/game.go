@@ -0,0 +1,171 @@
+// Package game keeps track of people playing the Wikipedia Game against the
+// racer.
+package game
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by a Store for sessions it doesn't have.
+var ErrNotFound = errors.New("game not found")
+
+// A Click is one move of a player.
+type Click struct {
+	Title string    `json:"title"`
+	At    time.Time `json:"at"`
+}
+
+// A Session is one game played by a person.
+type Session struct {
+	ID         string `json:"id"`
+	StartTitle string `json:"starttitle"`
+	EndTitle   string `json:"endtitle"`
+	// the namespaces clicks may lead to; empty means all namespaces
+	Namespaces []int     `json:"namespaces"`
+	StartedAt  time.Time `json:"started_at"`
+	// the valid clicks so far, in order
+	Clicks []Click `json:"clicks"`
+	// the number of clicks which were rejected
+	InvalidClicks int  `json:"invalid_clicks"`
+	Finished      bool `json:"finished"`
+
+	// the racer's path from StartTitle to EndTitle, set once the racer is done
+	BotPath  []string `json:"bot_path,omitempty"`
+	BotDone  bool     `json:"bot_done"`
+	BotError string   `json:"bot_error,omitempty"`
+}
+
+// Current returns the page the player is on.
+func (s *Session) Current() string {
+	if len(s.Clicks) == 0 {
+		return s.StartTitle
+	}
+	return s.Clicks[len(s.Clicks)-1].Title
+}
+
+// Path returns every page the player has visited, starting with StartTitle.
+func (s *Session) Path() []string {
+	path := []string{s.StartTitle}
+	for _, click := range s.Clicks {
+		path = append(path, click.Title)
+	}
+	return path
+}
+
+// Elapsed returns the time the player has taken so far, or took to finish.
+func (s *Session) Elapsed() time.Duration {
+	if s.Finished {
+		return s.Clicks[len(s.Clicks)-1].At.Sub(s.StartedAt)
+	}
+	return time.Since(s.StartedAt)
+}
+
+// Click records a move to title at time at, which must already have been
+// checked to be a link on the current page. It finishes the game if title is
+// EndTitle.
+func (s *Session) Click(title string, at time.Time) error {
+	if s.Finished {
+		return errors.New("the game is already finished")
+	}
+	s.Clicks = append(s.Clicks, Click{Title: title, At: at})
+	s.Finished = title == s.EndTitle
+	return nil
+}
+
+// Score rates a finished game. A player who needs as many clicks as the racer
+// scores 1000, fewer clicks score more and more clicks score less. One point is
+// taken away for every second. The score is 0 until the game is finished and
+// the racer has found a path.
+func (s *Session) Score() int {
+	if !s.Finished || len(s.BotPath) < 2 {
+		return 0
+	}
+	score := 1000*(len(s.BotPath)-1)/len(s.Clicks) - int(s.Elapsed().Seconds())
+	if score < 0 {
+		score = 0
+	}
+	return score
+}
+
+// copy returns a deep copy of s.
+func (s *Session) copy() *Session {
+	c := *s
+	c.Namespaces = append(make([]int, 0, len(s.Namespaces)), s.Namespaces...)
+	c.Clicks = append(make([]Click, 0, len(s.Clicks)), s.Clicks...)
+	c.BotPath = append([]string(nil), s.BotPath...)
+	return &c
+}
+
+// A Store keeps sessions. Implementations must be safe for concurrent use, and
+// must not let callers modify stored sessions except through Update.
+type Store interface {
+	// Create stores a new session.
+	Create(s *Session) error
+	// Get returns the session with id, or ErrNotFound.
+	Get(id string) (*Session, error)
+	// Update calls update with the session with id and stores the result
+	// unless update returns an error. No other update of the same session
+	// runs at the same time.
+	Update(id string, update func(s *Session) error) (*Session, error)
+}
+
+// MemoryStore is a Store which keeps the most recent sessions in memory.
+type MemoryStore struct {
+	sync.Mutex
+	size     int
+	sessions map[string]*Session
+	// ids in creation order, used to evict the oldest session
+	order []string
+}
+
+// NewMemoryStore returns a MemoryStore which keeps at most size sessions,
+// evicting the oldest session once it is full.
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{size: size, sessions: make(map[string]*Session)}
+}
+
+// Create stores a copy of s.
+func (m *MemoryStore) Create(s *Session) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.sessions[s.ID]; ok {
+		return errors.Errorf("game %s already exists", s.ID)
+	}
+	m.sessions[s.ID] = s.copy()
+	m.order = append(m.order, s.ID)
+	for len(m.order) > m.size {
+		delete(m.sessions, m.order[0])
+		m.order = m.order[1:]
+	}
+	return nil
+}
+
+// Get returns a copy of the session with id.
+func (m *MemoryStore) Get(id string) (*Session, error) {
+	m.Lock()
+	defer m.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.copy(), nil
+}
+
+// Update changes the session with id while holding the store's lock.
+func (m *MemoryStore) Update(id string, update func(s *Session) error) (*Session, error) {
+	m.Lock()
+	defer m.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	updated := s.copy()
+	if err := update(updated); err != nil {
+		return nil, err
+	}
+	m.sessions[id] = updated
+	return updated.copy(), nil
+}
@@ -0,0 +1,90 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSessionClicks(t *testing.T) {
+	start := time.Now()
+	s := &Session{ID: "id", StartTitle: "start", EndTitle: "end", StartedAt: start}
+	if s.Current() != "start" {
+		t.Errorf("a new game should be on the start page but is on %s", s.Current())
+	}
+
+	s.Click("middle", start.Add(5*time.Second))
+	s.Click("end", start.Add(10*time.Second))
+	if !s.Finished {
+		t.Error("clicking the end page should finish the game")
+	}
+	if err := s.Click("elsewhere", start.Add(15*time.Second)); err == nil {
+		t.Error("a finished game should not accept clicks")
+	}
+	if path := s.Path(); !reflect.DeepEqual(path, []string{"start", "middle", "end"}) {
+		t.Errorf("path should be start, middle, end but is %v", path)
+	}
+	if s.Elapsed() != 10*time.Second {
+		t.Errorf("the game should have taken 10s but took %v", s.Elapsed())
+	}
+}
+
+func TestScore(t *testing.T) {
+	start := time.Now()
+	s := &Session{StartTitle: "start", EndTitle: "end", StartedAt: start}
+	s.Click("a", start)
+	s.Click("b", start)
+	s.Click("c", start)
+	s.Click("end", start.Add(20*time.Second))
+
+	if s.Score() != 0 {
+		t.Errorf("the score should be 0 until the racer is done but is %d", s.Score())
+	}
+	s.BotPath = []string{"start", "x", "end"}
+	if s.Score() != 480 {
+		t.Errorf("four clicks against the racer's two in 20s should score 480 but scored %d", s.Score())
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	m := NewMemoryStore(2)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := m.Create(&Session{ID: id, StartTitle: "start", EndTitle: "end"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Get("a"); err != ErrNotFound {
+		t.Errorf("the oldest game should have been evicted but got %v", err)
+	}
+
+	s, err := m.Get("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Click("elsewhere", time.Now())
+	if s, _ = m.Get("b"); len(s.Clicks) != 0 {
+		t.Error("changing a session returned by Get should not change the store")
+	}
+
+	if _, err := m.Update("b", func(s *Session) error {
+		s.Click("middle", time.Now())
+		return errors.New("no")
+	}); err == nil {
+		t.Error("Update should return the error of update")
+	}
+	if s, _ = m.Get("b"); len(s.Clicks) != 0 {
+		t.Error("a failed update should not be stored")
+	}
+
+	s, err = m.Update("b", func(s *Session) error {
+		return s.Click("middle", time.Now())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Current() != "middle" {
+		t.Errorf("Update should return the updated session but it is on %s", s.Current())
+	}
+}
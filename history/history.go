@@ -0,0 +1,292 @@
+// Package history persists the results of races in an embedded bbolt
+// database.
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// races keyed by finish time and id, so that iterating is chronological
+	racesBucket = []byte("races")
+	// pairStats keyed by start and end title
+	pairsBucket = []byte("pairs")
+)
+
+// the outcomes of a race
+const (
+	Found   = "found"
+	Timeout = "timeout"
+	Failed  = "error"
+)
+
+// Config is the configuration a race ran with.
+type Config struct {
+	TimeLimit       time.Duration `json:"time_limit"`
+	ForwardWorkers  int           `json:"forward_workers"`
+	BackwardWorkers int           `json:"backward_workers"`
+	AllLinks        bool          `json:"all_links"`
+	Namespaces      []int         `json:"namespaces"`
+	Seed            int64         `json:"seed"`
+	Deterministic   bool          `json:"deterministic"`
+	// the kinds of edges followed; empty means only links
+	Edges []string `json:"edges,omitempty"`
+}
+
+// linksOnly returns true if the race only followed links.
+func (c Config) linksOnly() bool {
+	for _, edge := range c.Edges {
+		if edge != "link" {
+			return false
+		}
+	}
+	return true
+}
+
+// A Record is one race.
+type Record struct {
+	ID         string    `json:"id"`
+	StartTitle string    `json:"starttitle"`
+	EndTitle   string    `json:"endtitle"`
+	Config     Config    `json:"config"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Found, Timeout or Failed
+	Outcome string        `json:"outcome"`
+	Path    []string      `json:"path,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Elapsed time.Duration `json:"elapsed"`
+	// the number of pages reached from each side
+	ForwardPages  int `json:"forward_pages"`
+	BackwardPages int `json:"backward_pages"`
+}
+
+// PairStats sums up every race between the same pages.
+type PairStats struct {
+	StartTitle string `json:"starttitle"`
+	EndTitle   string `json:"endtitle"`
+	Races      int    `json:"races"`
+	Timeouts   int    `json:"timeouts"`
+	Errors     int    `json:"errors"`
+	// the total time taken by races which found a path
+	FoundElapsed time.Duration `json:"found_elapsed"`
+	// the shortest path made of links any race found
+	ShortestPath []string `json:"shortest_path,omitempty"`
+	// the namespaces explored by the race which found ShortestPath; empty
+	// means all namespaces
+	ShortestPathNamespaces []int `json:"shortest_path_namespaces,omitempty"`
+}
+
+// AverageElapsed returns the average time taken by races which found a path.
+func (p PairStats) AverageElapsed() time.Duration {
+	found := p.Races - p.Timeouts - p.Errors
+	if found == 0 {
+		return 0
+	}
+	return p.FoundElapsed / time.Duration(found)
+}
+
+// A Filter selects records. Empty fields match every record.
+type Filter struct {
+	StartTitle string
+	EndTitle   string
+	Outcome    string
+	Since      time.Time
+	Until      time.Time
+	// only records older than the record with this cursor
+	Before string
+	// the most records to return
+	Limit int
+}
+
+// Store is a history of races.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the history database at path.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open history database %s", path)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(racesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(pairsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return errors.WithStack(s.db.Close())
+}
+
+// recordKey orders records by finish time. The id keeps keys unique.
+func recordKey(r Record) []byte {
+	key := make([]byte, 8, 8+len(r.ID))
+	binary.BigEndian.PutUint64(key, uint64(r.FinishedAt.UnixNano()))
+	return append(key, r.ID...)
+}
+
+// pairKey identifies a pair of pages.
+func pairKey(startTitle string, endTitle string) []byte {
+	return []byte(startTitle + "\x00" + endTitle)
+}
+
+// Put stores r and adds it to the stats of its pair.
+func (s *Store) Put(r Record) error {
+	value, err := json.Marshal(r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(racesBucket).Put(recordKey(r), value); err != nil {
+			return err
+		}
+
+		pairs := tx.Bucket(pairsBucket)
+		key := pairKey(r.StartTitle, r.EndTitle)
+		stats := PairStats{StartTitle: r.StartTitle, EndTitle: r.EndTitle}
+		if existing := pairs.Get(key); existing != nil {
+			if err := json.Unmarshal(existing, &stats); err != nil {
+				return err
+			}
+		}
+		stats.Races++
+		switch r.Outcome {
+		case Timeout:
+			stats.Timeouts++
+		case Failed:
+			stats.Errors++
+		default:
+			stats.FoundElapsed += r.Elapsed
+			if r.Config.linksOnly() && (stats.ShortestPath == nil || len(r.Path) < len(stats.ShortestPath)) {
+				stats.ShortestPath = r.Path
+				stats.ShortestPathNamespaces = r.Config.Namespaces
+			}
+		}
+		value, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		return pairs.Put(key, value)
+	})
+	return errors.WithStack(err)
+}
+
+// Query returns the records matching f, newest first, and a cursor to pass as
+// f.Before to get the next page, which is empty if there are no more records.
+func (s *Store) Query(f Filter) ([]Record, string, error) {
+	var records []Record
+	next := ""
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(racesBucket).Cursor()
+		var k, v []byte
+		if f.Before != "" {
+			before, err := hex.DecodeString(f.Before)
+			if err != nil {
+				return errors.Errorf("invalid cursor %q", f.Before)
+			}
+			// Seek finds the first key at or after before, so step back
+			if k, v = c.Seek(before); k == nil {
+				k, v = c.Last()
+			}
+			for k != nil && bytes.Compare(k, before) >= 0 {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Last()
+		}
+
+		for ; k != nil; k, v = c.Prev() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if !f.Since.IsZero() && r.FinishedAt.Before(f.Since) {
+				// every older record is before Since too
+				break
+			}
+			if (!f.Until.IsZero() && r.FinishedAt.After(f.Until)) ||
+				(f.StartTitle != "" && r.StartTitle != f.StartTitle) ||
+				(f.EndTitle != "" && r.EndTitle != f.EndTitle) ||
+				(f.Outcome != "" && r.Outcome != f.Outcome) {
+				continue
+			}
+			if f.Limit > 0 && len(records) == f.Limit {
+				next = hex.EncodeToString(recordKey(records[len(records)-1]))
+				break
+			}
+			records = append(records, r)
+		}
+		return nil
+	})
+	return records, next, errors.WithStack(err)
+}
+
+// Pairs calls each with the stats of every pair of pages raced so far.
+func (s *Store) Pairs(each func(PairStats) error) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pairsBucket).ForEach(func(k, v []byte) error {
+			var stats PairStats
+			if err := json.Unmarshal(v, &stats); err != nil {
+				return err
+			}
+			return each(stats)
+		})
+	})
+	return errors.WithStack(err)
+}
+
+// Leaderboard returns the limit hardest pairs and the limit pairs with the
+// longest shortest paths. The hardest pairs are those whose races most often
+// failed to find a path, with ties going to the pair which took longest on
+// average.
+func (s *Store) Leaderboard(limit int) ([]PairStats, []PairStats, error) {
+	var hardest, longest []PairStats
+	err := s.Pairs(func(stats PairStats) error {
+		hardest = append(hardest, stats)
+		if stats.ShortestPath != nil {
+			longest = append(longest, stats)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.SliceStable(hardest, func(i, j int) bool {
+		a, b := hardest[i], hardest[j]
+		// compare the share of races which didn't find a path
+		aMissed, bMissed := (a.Timeouts+a.Errors)*b.Races, (b.Timeouts+b.Errors)*a.Races
+		if aMissed != bMissed {
+			return aMissed > bMissed
+		}
+		return a.AverageElapsed() > b.AverageElapsed()
+	})
+	sort.SliceStable(longest, func(i, j int) bool {
+		return len(longest[i].ShortestPath) > len(longest[j].ShortestPath)
+	})
+	if len(hardest) > limit {
+		hardest = hardest[:limit]
+	}
+	if len(longest) > limit {
+		longest = longest[:limit]
+	}
+	return hardest, longest, nil
+}
@@ -0,0 +1,126 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// openTestStore opens a store in a temporary directory which cleanup removes.
+func openTestStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(filepath.Join(dir, "history.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []Record{
+		{ID: "1", StartTitle: "a", EndTitle: "b", Outcome: Found, Path: []string{"a", "b"}},
+		{ID: "2", StartTitle: "a", EndTitle: "c", Outcome: Timeout},
+		{ID: "3", StartTitle: "a", EndTitle: "b", Outcome: Failed, Error: "oops"},
+		{ID: "4", StartTitle: "d", EndTitle: "b", Outcome: Found, Path: []string{"d", "a", "b"}},
+	}
+	for i := range records {
+		records[i].FinishedAt = start.Add(time.Duration(i) * time.Hour)
+		if err := s.Put(records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(f Filter) ([]string, string) {
+		found, next, err := s.Query(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, r := range found {
+			ids = append(ids, r.ID)
+		}
+		return ids, next
+	}
+
+	if got, next := ids(Filter{}); !reflect.DeepEqual(got, []string{"4", "3", "2", "1"}) || next != "" {
+		t.Errorf("every record should be returned newest first, got %v and cursor %q", got, next)
+	}
+	if got, _ := ids(Filter{StartTitle: "a", EndTitle: "b"}); !reflect.DeepEqual(got, []string{"3", "1"}) {
+		t.Errorf("filtering by pair should return 3 and 1, got %v", got)
+	}
+	if got, _ := ids(Filter{Outcome: Found}); !reflect.DeepEqual(got, []string{"4", "1"}) {
+		t.Errorf("filtering by outcome should return 4 and 1, got %v", got)
+	}
+	if got, _ := ids(Filter{Since: start.Add(time.Hour), Until: start.Add(2 * time.Hour)}); !reflect.DeepEqual(got, []string{"3", "2"}) {
+		t.Errorf("filtering by time should return 3 and 2, got %v", got)
+	}
+
+	got, next := ids(Filter{Limit: 3})
+	if !reflect.DeepEqual(got, []string{"4", "3", "2"}) || next == "" {
+		t.Fatalf("the first page should be 4, 3 and 2 with a cursor, got %v and cursor %q", got, next)
+	}
+	if got, next = ids(Filter{Limit: 3, Before: next}); !reflect.DeepEqual(got, []string{"1"}) || next != "" {
+		t.Errorf("the second page should be 1 without a cursor, got %v and cursor %q", got, next)
+	}
+
+	if _, _, err := s.Query(Filter{Before: "not hex"}); err == nil {
+		t.Error("an invalid cursor should be an error")
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	s, cleanup := openTestStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	records := []Record{
+		{ID: "1", StartTitle: "a", EndTitle: "b", Outcome: Found, Path: []string{"a", "x", "b"}, Elapsed: time.Second},
+		{ID: "2", StartTitle: "a", EndTitle: "b", Outcome: Found, Path: []string{"a", "b"}, Elapsed: 3 * time.Second},
+		{ID: "3", StartTitle: "c", EndTitle: "d", Outcome: Timeout},
+		{ID: "4", StartTitle: "e", EndTitle: "f", Outcome: Found, Path: []string{"e", "x", "y", "f"}, Elapsed: time.Second},
+	}
+	for i, r := range records {
+		r.FinishedAt = now.Add(time.Duration(i))
+		if err := s.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var pairs []PairStats
+	s.Pairs(func(stats PairStats) error {
+		pairs = append(pairs, stats)
+		return nil
+	})
+	if len(pairs) != 3 {
+		t.Fatalf("there should be 3 pairs but there are %d", len(pairs))
+	}
+	ab := pairs[0]
+	if ab.Races != 2 || ab.AverageElapsed() != 2*time.Second || !reflect.DeepEqual(ab.ShortestPath, []string{"a", "b"}) {
+		t.Errorf("a to b should have 2 races averaging 2s with shortest path [a b], got %+v", ab)
+	}
+
+	hardest, longest, err := s.Leaderboard(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hardest) != 2 || hardest[0].StartTitle != "c" || hardest[1].StartTitle != "a" {
+		t.Errorf("c to d never finished and a to b took longest, got %+v", hardest)
+	}
+	if len(longest) != 2 || longest[0].StartTitle != "e" || longest[1].StartTitle != "a" {
+		t.Errorf("e to f has the longest path, got %+v", longest)
+	}
+}
@@ -2,13 +2,22 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	_ "net/http/pprof" // import for side effects
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/history"
 	"github.com/sandlerben/wikiracer/web"
+	"google.golang.org/grpc"
 )
 
 func init() {
@@ -17,20 +26,126 @@ func init() {
 }
 
 func main() {
+	batchFile := flag.String("batch", "", "race the pairs in this file (JSON or newline delimited JSON), print the results and exit")
+	flag.Parse()
+
+	if *batchFile != "" {
+		f, err := os.Open(*batchFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if err := web.RunBatch(f, os.Stdout); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+
+	// keep a history of races unless WIKIRACER_HISTORY_PATH is set to ""
+	historyPath, ok := os.LookupEnv("WIKIRACER_HISTORY_PATH")
+	if !ok {
+		historyPath = "wikiracer.db"
+	}
+	if historyPath != "" {
+		store, err := history.Open(historyPath)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		defer func() {
+			// every race has been recorded by now
+			if err := store.Close(); err != nil {
+				log.Errorf("%+v", err)
+			}
+		}()
+		if err := web.SetHistory(store); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	// require API keys if a file to keep them in is configured
+	if keysPath := os.Getenv("WIKIRACER_KEYS_PATH"); keysPath != "" {
+		if err := web.LoadKeys(keysPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	// suggest titles offline if there is a list of them
+	if titleIndexPath := os.Getenv("WIKIRACER_TITLE_INDEX"); titleIndexPath != "" {
+		if err := web.LoadTitleIndex(titleIndexPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
 	router := web.NewRouter()
 	middlewareRouter := web.ApplyMiddleware(router)
 
-	// serve http
-	http.Handle("/", middlewareRouter)
+	// serve http; net/http/pprof registers its handlers on
+	// http.DefaultServeMux, and they are only served to the admin once API
+	// keys are required
+	serveMux := http.NewServeMux()
+	serveMux.Handle("/", middlewareRouter)
+	serveMux.Handle("/debug/pprof/", web.RequireAdminKey(http.DefaultServeMux))
 
 	var port string
-	var ok bool
 	if port, ok = os.LookupEnv("WIKIRACER_PORT"); !ok {
 		port = "8000"
 	}
+	drainTimeout := 20 * time.Second
+	if drainTimeoutString, ok := os.LookupEnv("WIKIRACER_DRAIN_TIMEOUT"); ok {
+		var err error
+		if drainTimeout, err = time.ParseDuration(drainTimeoutString); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// serve gRPC on a separate port if one is configured
+	var grpcServer *grpc.Server
+	if grpcPort, ok := os.LookupEnv("WIKIRACER_GRPC_PORT"); ok {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%s", grpcPort))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("gRPC server is running at localhost:%s", grpcPort)
+		grpcServer = web.NewGRPCServer()
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+
+	// explore the pages of a coordinator's distributed races if one is
+	// configured
+	nodeCtx, stopNode := context.WithCancel(context.Background())
+	if coordinatorURL, ok := os.LookupEnv("WIKIRACER_COORDINATOR_URL"); ok {
+		nodeWorkers := 10
+		if nodeWorkersString, ok := os.LookupEnv("WIKIRACER_NODE_WORKERS"); ok {
+			var err error
+			if nodeWorkers, err = strconv.Atoi(nodeWorkersString); err != nil {
+				log.Fatal(err)
+			}
+		}
+		log.Infof("exploring pages for the coordinator at %s with %d workers", coordinatorURL, nodeWorkers)
+		go web.RunNode(nodeCtx, coordinatorURL, os.Getenv("WIKIRACER_COORDINATOR_API_KEY"), nodeWorkers)
+	}
+
 	log.Infof("Server is running at http://localhost:%s", port)
-	addr := fmt.Sprintf(":%s", port)
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Error(err)
+	server := &http.Server{Addr: fmt.Sprintf(":%s", port), Handler: serveMux}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// drain the races in flight on SIGTERM or Ctrl-C
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+	log.Infof("received %s, shutting down within %s", sig, drainTimeout)
+	stopNode()
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := web.Shutdown(ctx, server, grpcServer); err != nil {
+		log.Errorf("%+v", err)
 	}
+	log.Info("Server stopped")
 }
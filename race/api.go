@@ -0,0 +1,110 @@
+package race
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/pkg/errors"
+)
+
+// apiClient makes requests to the MediaWiki API at config.APIURL. Every racer
+// has one, and functions which only query the API, like VerifyPath, use one
+// on its own so that they don't allocate the frontiers of a race.
+type apiClient struct {
+	// the parameters of the race or query
+	config Config
+	client *http.Client
+	// cancelled once the requests are no longer needed
+	ctx context.Context
+	// the number of times a request is retried while rate limited
+	maxRateLimitRetries int
+}
+
+func newAPIClient(ctx context.Context, config Config) *apiClient {
+	return &apiClient{
+		config:              config,
+		client:              &http.Client{Transport: config.Transport},
+		ctx:                 ctx,
+		maxRateLimitRetries: maxRateLimitRetries,
+	}
+}
+
+// loopUntilResponse makes requests to the MediaWiki API until it does not get
+// code=429 "Too Many Requests", giving up after c.maxRateLimitRetries retries.
+// Other unsuccessful responses are UpstreamUnavailable errors.
+func (c *apiClient) loopUntilResponse(u *url.URL) (*http.Response, error) {
+	for retries := 0; ; retries++ {
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		resp, err := c.client.Do(req.WithContext(c.ctx))
+		if err != nil {
+			return nil, errors.WithStack(&Error{Kind: UpstreamUnavailable, Message: "could not reach the MediaWiki API", Err: err})
+		}
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			resp.Body.Close()
+			if retries == c.maxRateLimitRetries {
+				return nil, newError(RateLimited, "the MediaWiki API is rate limiting requests")
+			}
+			select {
+			case <-c.ctx.Done():
+				return nil, errors.WithStack(c.ctx.Err())
+			case <-time.After(time.Millisecond * 100):
+			}
+		case resp.StatusCode != http.StatusOK:
+			resp.Body.Close()
+			return nil, newError(UpstreamUnavailable, "the MediaWiki API responded with status %d", resp.StatusCode)
+		default:
+			return resp, nil
+		}
+	}
+}
+
+// get requests the API with the parameters in q, on top of any in
+// config.APIURL, and returns the body of the response.
+func (c *apiClient) get(q url.Values) ([]byte, error) {
+	u, err := url.Parse(c.config.APIURL)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	params := u.Query()
+	for k, v := range q {
+		params[k] = v
+	}
+	u.RawQuery = params.Encode()
+
+	resp, err := c.loopUntilResponse(u)
+	if err != nil {
+		return nil, err
+	}
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return bodyBytes, nil
+}
+
+// query runs an API query with the parameters in q and returns the response.
+// Errors reported by the API are returned as such.
+func (c *apiClient) query(q url.Values) ([]byte, error) {
+	q.Set("format", "json")
+	q.Set("formatversion", "2")
+	bodyBytes, err := c.get(q)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := jsonparser.GetString(bodyBytes, "error", "info"); err == nil {
+		if code, _ := jsonparser.GetString(bodyBytes, "error", "code"); code == "missingtitle" {
+			return nil, newError(PageNotFound, "%s", info)
+		}
+		return nil, newError(UpstreamUnavailable, "the MediaWiki API returned an error: %s", info)
+	}
+	return bodyBytes, nil
+}
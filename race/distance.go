@@ -0,0 +1,293 @@
+package race
+
+import (
+	"context"
+	"time"
+)
+
+// Distance is one entry of a DistanceMatrix.
+type Distance struct {
+	// the number of links followed, or -1 if no path is known
+	Hops int
+	// true if Hops is proven to be the shortest distance. An exact distance of
+	// -1 means that there is no path at all.
+	Exact bool
+}
+
+// A DistanceMatrix holds the distances between every pair of Titles.
+// Distances[i][j] is the distance from Titles[i] to Titles[j].
+type DistanceMatrix struct {
+	Titles    []string
+	Distances [][]Distance
+}
+
+// distanceSearch runs a breadth first search forward from every source and
+// backward to every target at the same time. Each level of every search is
+// explored together, so a page which is on several frontiers is only
+// queried once, and every search is shared by all the pairs it belongs to.
+type distanceSearch struct {
+	c                *apiClient
+	sources, targets []string
+
+	// forward[i] maps pages to their distance from sources[i]
+	forward []map[string]int
+	// backward[j] maps pages to their distance to targets[j]
+	backward []map[string]int
+	// the pages at the deepest level of each search
+	forwardFrontiers, backwardFrontiers [][]string
+	// the number of levels each search has explored
+	forwardDepths, backwardDepths []int
+
+	// mapping of pages to the pages they link to
+	links map[string][]string
+	// mapping of pages to the pages which link to them
+	linksHere map[string][]string
+}
+
+func newDistanceSearch(sources []string, targets []string, config Config) *distanceSearch {
+	s := &distanceSearch{
+		c:         newAPIClient(context.Background(), config),
+		sources:   sources,
+		targets:   targets,
+		links:     make(map[string][]string),
+		linksHere: make(map[string][]string),
+	}
+	for _, source := range sources {
+		s.forward = append(s.forward, map[string]int{source: 0})
+		s.forwardFrontiers = append(s.forwardFrontiers, []string{source})
+		s.forwardDepths = append(s.forwardDepths, 0)
+	}
+	for _, target := range targets {
+		s.backward = append(s.backward, map[string]int{target: 0})
+		s.backwardFrontiers = append(s.backwardFrontiers, []string{target})
+		s.backwardDepths = append(s.backwardDepths, 0)
+	}
+	return s
+}
+
+// Distances computes the distance matrix of titles. It explores until every
+// distance is proven or the time limit in config passes, in which case the
+// shortest distances found so far are returned as upper bounds. Unlike a
+// race, every link of each page is explored regardless of ExploreAllLinks.
+func Distances(titles []string, config Config) (*DistanceMatrix, error) {
+	distances, err := newDistanceSearch(titles, titles, config).run()
+	if err != nil {
+		return nil, err
+	}
+	matrix := &DistanceMatrix{Titles: titles, Distances: distances}
+	matrix.applyUpperBounds()
+	return matrix, nil
+}
+
+// ShortestDistance computes the distance from start to end like Distances,
+// without exploring the way back.
+func ShortestDistance(start string, end string, config Config) (Distance, error) {
+	distances, err := newDistanceSearch([]string{start}, []string{end}, config).run()
+	if err != nil {
+		return Distance{}, err
+	}
+	return distances[0][0], nil
+}
+
+// run explores until every distance is proven or the time limit passes and
+// returns the distances from each source to each target.
+func (s *distanceSearch) run() ([][]Distance, error) {
+	done := make(chan struct{})
+	timer := time.AfterFunc(s.c.config.TimeLimit, func() { close(done) })
+	defer timer.Stop()
+
+	for {
+		distances := s.distances()
+		forwardSearches, backwardSearches := s.unresolved(distances)
+		if len(forwardSearches) == 0 && len(backwardSearches) == 0 {
+			return distances, nil
+		}
+
+		// expand whichever side has fewer pages to query
+		wType := forwardType
+		if s.frontierSize(backwardSearches, backwardType) < s.frontierSize(forwardSearches, forwardType) {
+			wType = backwardType
+		}
+		searches := forwardSearches
+		if wType == backwardType {
+			searches = backwardSearches
+		}
+
+		if err := s.fetch(searches, wType, done); err != nil {
+			return nil, err
+		}
+		select {
+		case <-done:
+			// the level may not have been fetched completely
+			return distances, nil
+		default:
+		}
+		s.expand(searches, wType)
+	}
+}
+
+// distances computes the distances proven or found so far.
+func (s *distanceSearch) distances() [][]Distance {
+	distances := make([][]Distance, len(s.sources))
+	for i, source := range s.sources {
+		distances[i] = make([]Distance, len(s.targets))
+		for j, target := range s.targets {
+			if source == target {
+				distances[i][j] = Distance{Hops: 0, Exact: true}
+				continue
+			}
+
+			hops := -1
+			smaller, larger := s.forward[i], s.backward[j]
+			if len(smaller) > len(larger) {
+				smaller, larger = larger, smaller
+			}
+			for page, d := range smaller {
+				if e, ok := larger[page]; ok && (hops == -1 || d+e < hops) {
+					hops = d + e
+				}
+			}
+
+			// both searches see every path with at most forwardDepth +
+			// backwardDepth links, and every path they have found is at
+			// most that long, so the shortest one found is a shortest path.
+			// A search with an empty frontier has seen every page it can.
+			exact := hops != -1 || len(s.forwardFrontiers[i]) == 0 || len(s.backwardFrontiers[j]) == 0
+			distances[i][j] = Distance{Hops: hops, Exact: exact}
+		}
+	}
+	return distances
+}
+
+// unresolved returns the forward and backward searches which still belong to
+// a pair whose distance isn't proven.
+func (s *distanceSearch) unresolved(distances [][]Distance) ([]int, []int) {
+	var forwardSearches, backwardSearches []int
+	for i := range s.sources {
+		for j := range s.targets {
+			if !distances[i][j].Exact {
+				forwardSearches = append(forwardSearches, i)
+				break
+			}
+		}
+	}
+	for j := range s.targets {
+		for i := range s.sources {
+			if !distances[i][j].Exact {
+				backwardSearches = append(backwardSearches, j)
+				break
+			}
+		}
+	}
+	return forwardSearches, backwardSearches
+}
+
+// frontierSize returns the number of pages which would be queried to expand
+// searches.
+func (s *distanceSearch) frontierSize(searches []int, wType workerType) int {
+	pages := make(map[string]bool)
+	for _, i := range searches {
+		frontier, cache := s.forwardFrontiers[i], s.links
+		if wType == backwardType {
+			frontier, cache = s.backwardFrontiers[i], s.linksHere
+		}
+		for _, page := range frontier {
+			if _, ok := cache[page]; !ok {
+				pages[page] = true
+			}
+		}
+	}
+	return len(pages)
+}
+
+// fetch queries the neighbors of every page on the frontiers of searches
+// which haven't been queried yet. It gives up early once done is closed.
+func (s *distanceSearch) fetch(searches []int, wType workerType, done <-chan struct{}) error {
+	cache := s.links
+	if wType == backwardType {
+		cache = s.linksHere
+	}
+
+	seen := make(map[string]bool)
+	var toFetch []string
+	for _, i := range searches {
+		frontier := s.forwardFrontiers[i]
+		if wType == backwardType {
+			frontier = s.backwardFrontiers[i]
+		}
+		for _, page := range frontier {
+			if _, ok := cache[page]; !ok && !seen[page] {
+				seen[page] = true
+				toFetch = append(toFetch, page)
+			}
+		}
+	}
+
+	fetched, err := s.c.fetchAllNeighbors(toFetch, wType, done)
+	if err != nil {
+		return err
+	}
+	for page, result := range fetched {
+		if result.missing && s.isEndpoint(page) {
+			return newError(PageNotFound, "the page %s does not exist", page)
+		}
+		cache[page] = result.titles
+	}
+	return nil
+}
+
+// isEndpoint returns true if page is one of the sources or targets.
+func (s *distanceSearch) isEndpoint(page string) bool {
+	for _, title := range s.sources {
+		if title == page {
+			return true
+		}
+	}
+	for _, title := range s.targets {
+		if title == page {
+			return true
+		}
+	}
+	return false
+}
+
+// expand moves searches one level deeper using the fetched neighbors.
+func (s *distanceSearch) expand(searches []int, wType workerType) {
+	for _, i := range searches {
+		distances, frontier, depth, cache := s.forward[i], &s.forwardFrontiers[i], &s.forwardDepths[i], s.links
+		if wType == backwardType {
+			distances, frontier, depth, cache = s.backward[i], &s.backwardFrontiers[i], &s.backwardDepths[i], s.linksHere
+		}
+
+		var next []string
+		for _, page := range *frontier {
+			for _, neighbor := range cache[page] {
+				if _, ok := distances[neighbor]; !ok {
+					distances[neighbor] = *depth + 1
+					next = append(next, neighbor)
+				}
+			}
+		}
+		*frontier = next
+		*depth++
+	}
+}
+
+// applyUpperBounds improves distances which aren't proven by going through
+// the other titles, since the distance from a to c is at most the distance
+// from a to b plus the distance from b to c.
+func (matrix *DistanceMatrix) applyUpperBounds() {
+	d := matrix.Distances
+	for k := range d {
+		for i := range d {
+			for j := range d {
+				if d[i][j].Exact || d[i][k].Hops == -1 || d[k][j].Hops == -1 {
+					continue
+				}
+				if through := d[i][k].Hops + d[k][j].Hops; d[i][j].Hops == -1 || through < d[i][j].Hops {
+					d[i][j].Hops = through
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package race
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how long a node may take to report on a page it leased before the page is
+// leased to another node
+var leaseTimeout = 30 * time.Second
+
+// how long a node waits before asking for work again when there is none
+var pollInterval = 200 * time.Millisecond
+
+// A Task is a page for a node to explore.
+type Task struct {
+	Title string `json:"title"`
+	// explore the pages which link to Title rather than the pages it links to
+	Backward bool `json:"backward,omitempty"`
+}
+
+// A Result is what a node found exploring a Task.
+type Result struct {
+	Task
+	Neighbors []string `json:"neighbors"`
+	// true if the page does not exist
+	Missing bool `json:"missing,omitempty"`
+}
+
+// An Assignment is the work a node leased from a Cluster.
+type Assignment struct {
+	// the race the tasks belong to, or "" if there is no work
+	Race   string `json:"race"`
+	Config Config `json:"config"`
+	Tasks  []Task `json:"tasks"`
+}
+
+// A Cluster hands out the pages of distributed races to the nodes exploring
+// them.
+type Cluster interface {
+	// Lease returns up to n tasks of one race. A task which isn't reported
+	// in time is leased again.
+	Lease(n int) (Assignment, error)
+	// Report sends the results of tasks leased from race.
+	Report(race string, results []Result) error
+}
+
+// A LinkSource explores a page for a node.
+type LinkSource func(task Task, config Config) (Result, error)
+
+// APILinks is the LinkSource which queries the MediaWiki API at
+// config.APIURL. Every link of the page is returned.
+func APILinks(task Task, config Config) (Result, error) {
+	wType := forwardType
+	if task.Backward {
+		wType = backwardType
+	}
+	n, err := newAPIClient(context.Background(), config).fetchNeighbors(task.Title, wType)
+	return Result{Task: task, Neighbors: n.titles, Missing: n.missing}, err
+}
+
+// coordinator is a race whose frontiers and visited pages are kept in this
+// process while its pages are explored by the nodes leasing from a Hub. It
+// detects the meeting point like any other race.
+type coordinator struct {
+	*defaultRacer
+	id  string
+	hub *Hub
+	// guards leases
+	sync.Mutex
+	// the pages leased to nodes and when they were leased
+	leases map[Task]time.Time
+}
+
+// Run registers the race with the hub so that nodes can lease its pages, and
+// finds a path like any other race.
+func (c *coordinator) Run() ([]string, error) {
+	c.hub.add(c)
+	defer c.hub.remove(c.id)
+	return c.defaultRacer.Run()
+}
+
+// MissingTitles returns no pages: the coordinator doesn't query the wiki, so
+// missing pages are found by the nodes exploring them.
+func (c *coordinator) MissingTitles() ([]string, error) {
+	return nil, nil
+}
+
+// lease returns up to n pages, starting with those whose lease expired.
+func (c *coordinator) lease(n int) []Task {
+	c.Lock()
+	defer c.Unlock()
+	tasks := []Task{}
+	now := time.Now()
+	for task, leased := range c.leases {
+		if len(tasks) == n {
+			break
+		}
+		if now.Sub(leased) > leaseTimeout {
+			tasks = append(tasks, task)
+		}
+	}
+	// take pages from both sides in turn
+	for more := true; more && len(tasks) < n; {
+		more = false
+		select {
+		case title := <-c.forwardLinks:
+			tasks = append(tasks, Task{Title: title})
+			more = true
+		default:
+		}
+		if len(tasks) == n {
+			break
+		}
+		select {
+		case title := <-c.backwardLinks:
+			tasks = append(tasks, Task{Title: title, Backward: true})
+			more = true
+		default:
+		}
+	}
+	for _, task := range tasks {
+		c.leases[task] = now
+		// a Snapshot lists leased pages as pending
+		c.cursors(task.wType()).set(task.Title, nil)
+	}
+	return tasks
+}
+
+// report adds the neighbors of the pages which were explored to the race.
+// Results for pages which aren't leased, e.g. because another node already
+// reported them, are ignored.
+func (c *coordinator) report(results []Result) {
+	for _, result := range results {
+		c.Lock()
+		_, leased := c.leases[result.Task]
+		delete(c.leases, result.Task)
+		c.Unlock()
+		if !leased {
+			continue
+		}
+		wType := result.wType()
+		if result.Missing {
+			if result.Title == c.startTitle || result.Title == c.endTitle {
+				c.handleErrInWorker(newError(PageNotFound, "the page %s does not exist", result.Title))
+			}
+		}
+		for _, neighbor := range result.Neighbors {
+			c.visit(wType, LinkEdge, result.Title, neighbor)
+		}
+		c.cursors(wType).remove(result.Title)
+	}
+}
+
+func (t Task) wType() workerType {
+	if t.Backward {
+		return backwardType
+	}
+	return forwardType
+}
+
+// A Hub is the Cluster of the distributed races running in this process.
+type Hub struct {
+	sync.Mutex
+	races map[string]*coordinator
+	// the ids of the races in the order they lease pages
+	order []string
+	// the id of the last race
+	lastID int
+}
+
+// NewHub returns a Hub without any races.
+func NewHub() *Hub {
+	return &Hub{races: make(map[string]*coordinator)}
+}
+
+// NewRacer returns a Racer whose pages are explored by the nodes leasing from
+// h rather than by its own workers. It only follows links.
+func (h *Hub) NewRacer(startTitle string, endTitle string, config Config) Racer {
+	h.Lock()
+	h.lastID++
+	id := strconv.Itoa(h.lastID)
+	h.Unlock()
+	config.Edges = nil
+	c := &coordinator{
+		defaultRacer: newDefaultRacer(startTitle, endTitle, config),
+		id:           id,
+		hub:          h,
+		leases:       make(map[Task]time.Time),
+	}
+	c.remote = true
+	return c
+}
+
+func (h *Hub) add(c *coordinator) {
+	h.Lock()
+	h.races[c.id] = c
+	h.order = append(h.order, c.id)
+	h.Unlock()
+}
+
+func (h *Hub) remove(id string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.races, id)
+	for i, other := range h.order {
+		if other == id {
+			h.order = append(h.order[:i], h.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Lease returns up to n pages of the first race which has any. Races take
+// turns, so that every race gets nodes.
+func (h *Hub) Lease(n int) (Assignment, error) {
+	h.Lock()
+	defer h.Unlock()
+	for i := range h.order {
+		c := h.races[h.order[i]]
+		if tasks := c.lease(n); len(tasks) > 0 {
+			// this race goes last next time
+			h.order = append(append(h.order[:i:i], h.order[i+1:]...), c.id)
+			return Assignment{Race: c.id, Config: c.config, Tasks: tasks}, nil
+		}
+	}
+	return Assignment{}, nil
+}
+
+// Report adds results to race. Results for races which have finished are
+// ignored.
+func (h *Hub) Report(race string, results []Result) error {
+	h.Lock()
+	c, ok := h.races[race]
+	h.Unlock()
+	if ok {
+		c.report(results)
+	}
+	return nil
+}
+
+// Races returns the number of distributed races running.
+func (h *Hub) Races() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.races)
+}
+
+// RunNode explores the pages of the races in cluster with source, using
+// workers goroutines, until ctx is done. A page which can't be explored is
+// left for the cluster to lease again.
+func RunNode(ctx context.Context, cluster Cluster, source LinkSource, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodeWorker(ctx, cluster, source)
+		}()
+	}
+	wg.Wait()
+}
+
+// nodeWorker leases one page at a time from cluster and reports what source
+// finds.
+func nodeWorker(ctx context.Context, cluster Cluster, source LinkSource) {
+	for ctx.Err() == nil {
+		assignment, err := cluster.Lease(1)
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+		if err != nil || assignment.Race == "" {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		results := make([]Result, 0, len(assignment.Tasks))
+		for _, task := range assignment.Tasks {
+			result, err := source(task, assignment.Config)
+			if err != nil {
+				log.Errorf("%+v", err)
+				continue
+			}
+			results = append(results, result)
+		}
+		if err := cluster.Report(assignment.Race, results); err != nil {
+			log.Errorf("%+v", err)
+		}
+	}
+}
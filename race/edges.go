@@ -0,0 +1,302 @@
+package race
+
+import (
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/buger/jsonparser"
+	"github.com/pkg/errors"
+)
+
+// An EdgeKind is a way of moving from one page to another.
+type EdgeKind string
+
+// the kinds of edges a race may follow
+const (
+	// a link from one page to another
+	LinkEdge EdgeKind = "link"
+	// from a page to a category it is in, or from a category to one of its
+	// members
+	CategoryEdge EdgeKind = "category"
+	// from a page to a template it uses
+	TemplateEdge EdgeKind = "template"
+	// from a template to a page which uses it
+	TransclusionEdge EdgeKind = "transclusion"
+)
+
+// the namespaces of categories and templates
+const (
+	categoryNamespace = 14
+	templateNamespace = 10
+)
+
+// ParseEdgeKind returns the EdgeKind named s.
+func ParseEdgeKind(s string) (EdgeKind, error) {
+	switch kind := EdgeKind(s); kind {
+	case LinkEdge, CategoryEdge, TemplateEdge, TransclusionEdge:
+		return kind, nil
+	}
+	return "", errors.Errorf("unknown edge kind %q", s)
+}
+
+// An Edger reports which kind of edge each hop of the path found by a race
+// follows.
+type Edger interface {
+	EdgeKinds() []EdgeKind
+}
+
+// EdgeKinds returns the kind of edge each hop of the path found by Run
+// follows, or nil if Run hasn't found a path.
+func (r *defaultRacer) EdgeKinds() []EdgeKind {
+	r.meetingPoint.Lock()
+	defer r.meetingPoint.Unlock()
+	return r.pathKinds
+}
+
+// followsLinks returns true if the race follows links.
+func (c Config) followsLinks() bool {
+	return len(c.Edges) == 0 || c.follows(LinkEdge)
+}
+
+// follows returns true if the race follows edges of kind.
+func (c Config) follows(kind EdgeKind) bool {
+	for _, k := range c.Edges {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// LinksOnly returns true if the race only follows links, which is the default.
+func (c Config) LinksOnly() bool {
+	for _, k := range c.Edges {
+		if k != LinkEdge {
+			return false
+		}
+	}
+	return true
+}
+
+// explore adds the neighbors of title along every kind of edge the race
+// follows.
+func (r *defaultRacer) explore(title string, wType workerType) error {
+	// the cursor stays behind if the race ends before title is explored, so
+	// that a Snapshot can pick up where it left off
+	cursors := r.cursors(wType)
+	if _, ok := cursors.get(title); !ok {
+		cursors.set(title, nil)
+	}
+	if r.config.followsLinks() {
+		if err := r.exploreLinks(title, wType); err != nil {
+			return err
+		}
+	}
+	for _, q := range r.edgeQueries(title, wType) {
+		if err := r.exploreEdges(title, wType, q); err != nil {
+			return err
+		}
+	}
+	cursors.remove(title)
+	return nil
+}
+
+// An edgeQuery lists the neighbors of a page along one kind of edge other
+// than links.
+type edgeQuery struct {
+	kind EdgeKind
+	// the prop module, or the list module if list is set
+	module string
+	list   bool
+	// the parameter prefix of the module
+	prefix string
+	// the namespace the neighbors are in, in addition to the race's
+	namespace int
+}
+
+// edgeQueries returns the queries which list the neighbors of title along
+// every kind of edge the race follows, except links.
+//
+// Category edges go both ways, so they are queried the same way in both
+// directions. Template edges are the reverse of transclusion edges.
+func (r *defaultRacer) edgeQueries(title string, wType workerType) []edgeQuery {
+	var queries []edgeQuery
+	if r.config.follows(CategoryEdge) {
+		queries = append(queries, edgeQuery{kind: CategoryEdge, module: "categories", prefix: "cl", namespace: categoryNamespace})
+		// only categories have members. The prefix is the English name of the
+		// namespace, which other wikis may translate.
+		if strings.HasPrefix(title, "Category:") {
+			queries = append(queries, edgeQuery{kind: CategoryEdge, module: "categorymembers", list: true, prefix: "cm", namespace: categoryNamespace})
+		}
+	}
+	templates := edgeQuery{module: "templates", prefix: "tl", namespace: templateNamespace}
+	transcludedIn := edgeQuery{module: "transcludedin", prefix: "ti", namespace: templateNamespace}
+	if r.config.follows(TemplateEdge) {
+		q := templates
+		if wType == backwardType {
+			q = transcludedIn
+		}
+		q.kind = TemplateEdge
+		queries = append(queries, q)
+	}
+	if r.config.follows(TransclusionEdge) {
+		q := transcludedIn
+		if wType == backwardType {
+			q = templates
+		}
+		q.kind = TransclusionEdge
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+// edgeNamespaces returns the namespaces the neighbors of a query may be in,
+// or "" for all namespaces.
+func (r *defaultRacer) edgeNamespaces(q edgeQuery) string {
+	if q.module == "categories" || q.module == "templates" {
+		// these only ever list categories or templates
+		return namespaceParam([]int{q.namespace})
+	}
+	if len(r.config.Namespaces) == 0 {
+		return ""
+	}
+	return namespaceParam(append(append([]int(nil), r.config.Namespaces...), q.namespace))
+}
+
+// exploreEdges runs q for title and adds the neighbors it lists like the links
+// workers do.
+func (r *defaultRacer) exploreEdges(title string, wType workerType, q edgeQuery) error {
+	params := url.Values{}
+	params.Set("action", "query")
+	params.Set("format", "json")
+	params.Set("formatversion", "2")
+	params.Set(q.prefix+"limit", "500")
+	if q.list {
+		params.Set("list", q.module)
+		params.Set(q.prefix+"title", title)
+	} else {
+		params.Set("prop", q.module)
+		params.Set("titles", title)
+	}
+	if q.module == "categories" {
+		// hidden categories are for maintenance, not for readers
+		params.Set("clshow", "!hidden")
+	}
+	if namespaces := r.edgeNamespaces(q); namespaces != "" {
+		params.Set(q.prefix+"namespace", namespaces)
+	}
+
+	visitAll := func(parent string, list []byte) {
+		jsonparser.ArrayEach(list, func(neighbor []byte, dataType jsonparser.ValueType, offset int, err error) {
+			if child, err := jsonparser.GetString(neighbor, "title"); err == nil {
+				r.visit(wType, q.kind, parent, child)
+			}
+		})
+	}
+	return r.continueQuery(params, q.prefix, r.config.ExploreAllLinks, func(bodyBytes []byte) error {
+		if q.list {
+			if list, _, _, err := jsonparser.Get(bodyBytes, "query", q.module); err == nil {
+				visitAll(title, list)
+			}
+			return nil
+		}
+		_, err := jsonparser.ArrayEach(bodyBytes, func(page []byte, dataType jsonparser.ValueType, offset int, err error) {
+			parent, _ := jsonparser.GetString(page, "title")
+			// the error here would just imply a missing key, it can be ignored
+			if missing, _ := jsonparser.GetBoolean(page, "missing"); missing {
+				if parent == r.startTitle || parent == r.endTitle {
+					r.handleErrInWorker(newError(PageNotFound, "the page %s does not exist", parent))
+				}
+				return
+			}
+			if list, _, _, err := jsonparser.Get(page, q.module); err == nil {
+				visitAll(parent, list)
+			}
+		}, "query", "pages")
+		if err != nil {
+			return errors.Wrap(err, string(bodyBytes))
+		}
+		return nil
+	}, nil)
+}
+
+// visit records that child is a neighbor of parent along an edge of kind, in
+// the direction of wType. The race ends if child has been reached from the
+// other side.
+//
+// child is added to the path map before the other side's map is checked, so
+// when both sides reach a page at the same time at least one of them sees the
+// other. Entries are never overwritten, which keeps every chain of parents
+// free of cycles.
+func (r *defaultRacer) visit(wType workerType, kind EdgeKind, parent string, child string) {
+	mapFromMyComponent, mapFromOtherComponent := &r.pathFromStartMap, &r.pathFromEndMap
+	kindsFromMyComponent := &r.kindsFromStartMap
+	linksFromMyComponent := &r.linksFromStart
+	myChan := r.forwardLinks
+	if wType == backwardType {
+		mapFromMyComponent, mapFromOtherComponent = &r.pathFromEndMap, &r.pathFromStartMap
+		kindsFromMyComponent = &r.kindsFromEndMap
+		linksFromMyComponent = &r.linksFromEnd
+		myChan = r.backwardLinks
+	}
+
+	if child != parent {
+		linksFromMyComponent.inc(child)
+	}
+	added := child != parent && r.add(mapFromMyComponent, kindsFromMyComponent, child, parent, kind)
+	if _, ok := mapFromOtherComponent.get(child); ok {
+		log.Debugf("found answer in worker! intersection at %s", child)
+		// the first meeting point wins
+		r.closeOnce.Do(func() {
+			r.meetingPoint.set(child)
+			close(r.done)
+		}) // kill all goroutines
+		return
+	}
+	if added {
+		select {
+		case myChan <- child:
+		case <-r.done:
+			// the race is over, and nobody reads myChan anymore
+		}
+	}
+}
+
+// add maps child to parent in paths unless child is already there, and
+// records the kind of edge from parent to child in kinds. Races which only
+// follow links don't record kinds, which saves memory. It returns true if
+// child was added.
+func (r *defaultRacer) add(paths *concurrentMap, kinds *concurrentMap, child string, parent string, kind EdgeKind) bool {
+	if r.config.LinksOnly() {
+		return paths.putIfAbsent(child, parent)
+	}
+	// kinds is held while child is added so that whoever finds child in
+	// paths also finds its kind
+	kinds.Lock()
+	defer kinds.Unlock()
+	if !paths.putIfAbsent(child, parent) {
+		return false
+	}
+	kinds.m[child] = string(kind)
+	return true
+}
+
+// kindsOfPath returns the kind of edge each hop of path follows, where path
+// meets at path[meeting].
+func (r *defaultRacer) kindsOfPath(path []string, meeting int) []EdgeKind {
+	kinds := make([]EdgeKind, len(path)-1)
+	for i := range kinds {
+		// pages before the meeting point were reached from their predecessor,
+		// and pages after it from their successor
+		kind, ok := r.kindsFromStartMap.get(path[i+1])
+		if i >= meeting {
+			kind, ok = r.kindsFromEndMap.get(path[i])
+		}
+		kinds[i] = EdgeKind(kind)
+		if !ok {
+			kinds[i] = LinkEdge
+		}
+	}
+	return kinds
+}
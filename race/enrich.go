@@ -0,0 +1,234 @@
+package race
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/buger/jsonparser"
+	"github.com/pkg/errors"
+)
+
+// A Page is a page on a path, with the details the MediaWiki API has about it.
+type Page struct {
+	Title     string `json:"title"`
+	PageID    int64  `json:"pageid"`
+	Namespace int    `json:"ns"`
+	// a plain text summary of the page, if extracts were requested
+	Extract string `json:"extract,omitempty"`
+}
+
+// A Hop is the link from one page on a path to the next.
+type Hop struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// the text of the link in From; empty if the link wasn't found in the
+	// wikitext, e.g. because a template adds it
+	AnchorText string `json:"anchor_text"`
+	// the sentence of From which contains the link
+	Context string `json:"context"`
+}
+
+// An EnrichedPath describes every page and hop of a path.
+type EnrichedPath struct {
+	Pages []Page `json:"pages"`
+	Hops  []Hop  `json:"hops"`
+}
+
+var (
+	// matches [[target]] and [[target|anchor text]]
+	wikiLinkRegexp = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|([^\[\]]*))?\]\]`)
+	// innermost templates, which are removed until none are left
+	templateRegexp = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+	refRegexp      = regexp.MustCompile(`(?s)<ref[^>/]*/>|<ref[^>]*>.*?</ref>|<!--.*?-->`)
+)
+
+// linkMarker surrounds the anchor text of the link being looked for once the
+// wikitext has been turned into plain text.
+const linkMarker = "\x00"
+
+// EnrichPath looks up the pages on path and the links between them. Only the
+// pages on the path are queried: one query for every page's details (and
+// extract, if extracts is set) and one parse query for the wikitext of every
+// page but the last.
+func EnrichPath(path []string, extracts bool, config Config) (*EnrichedPath, error) {
+	c := newAPIClient(context.Background(), config)
+	if len(path) == 0 {
+		return &EnrichedPath{Pages: []Page{}, Hops: []Hop{}}, nil
+	}
+	enriched := &EnrichedPath{Hops: make([]Hop, len(path)-1)}
+
+	var pagesErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		enriched.Pages, pagesErr = c.pageDetails(path, extracts)
+	}()
+
+	hopErrs := make([]error, len(path)-1)
+	for i := 0; i+1 < len(path); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hop := Hop{From: path[i], To: path[i+1]}
+			wikitext, err := c.wikitext(hop.From)
+			if err != nil {
+				hopErrs[i] = err
+				return
+			}
+			hop.AnchorText, hop.Context = findLink(wikitext, hop.To)
+			enriched.Hops[i] = hop
+		}(i)
+	}
+	wg.Wait()
+
+	if pagesErr != nil {
+		return nil, pagesErr
+	}
+	for _, err := range hopErrs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return enriched, nil
+}
+
+// pageDetails returns the ID, namespace and optionally the extract of every
+// page in titles, in the same order.
+func (c *apiClient) pageDetails(titles []string, extracts bool) ([]Page, error) {
+	q := url.Values{}
+	q.Set("action", "query")
+	q.Set("titles", strings.Join(titles, "|"))
+	q.Set("prop", "info")
+	if extracts {
+		q.Set("prop", "info|extracts")
+		q.Set("exintro", "1")
+		q.Set("explaintext", "1")
+		q.Set("exsentences", "2")
+		q.Set("exlimit", "max")
+	}
+	bodyBytes, err := c.query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	byTitle := make(map[string]Page)
+	_, err = jsonparser.ArrayEach(bodyBytes, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		var page Page
+		page.Title, _ = jsonparser.GetString(value, "title")
+		page.PageID, _ = jsonparser.GetInt(value, "pageid")
+		ns, _ := jsonparser.GetInt(value, "ns")
+		page.Namespace = int(ns)
+		page.Extract, _ = jsonparser.GetString(value, "extract")
+		byTitle[page.Title] = page
+	}, "query", "pages")
+	if err != nil {
+		return nil, errors.Wrap(err, string(bodyBytes))
+	}
+	// the API returns titles in its own order, and normalized
+	normalized := make(map[string]string)
+	jsonparser.ArrayEach(bodyBytes, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		from, _ := jsonparser.GetString(value, "from")
+		to, _ := jsonparser.GetString(value, "to")
+		normalized[from] = to
+	}, "query", "normalized")
+
+	pages := make([]Page, len(titles))
+	for i, title := range titles {
+		page, ok := byTitle[title]
+		if !ok {
+			page, ok = byTitle[normalized[title]]
+		}
+		if !ok {
+			page = Page{Title: title}
+		}
+		pages[i] = page
+	}
+	return pages, nil
+}
+
+// wikitext returns the wikitext of title.
+func (c *apiClient) wikitext(title string) (string, error) {
+	q := url.Values{}
+	q.Set("action", "parse")
+	q.Set("page", title)
+	q.Set("prop", "wikitext")
+	bodyBytes, err := c.query(q)
+	if err != nil {
+		return "", err
+	}
+	wikitext, err := jsonparser.GetString(bodyBytes, "parse", "wikitext")
+	if err != nil {
+		return "", errors.Wrap(err, string(bodyBytes))
+	}
+	return wikitext, nil
+}
+
+// normalizeTitle turns a link target into the title it links to.
+func normalizeTitle(target string) string {
+	if i := strings.Index(target, "#"); i >= 0 {
+		target = target[:i]
+	}
+	target = strings.TrimSpace(strings.Replace(target, "_", " ", -1))
+	target = strings.TrimPrefix(target, ":")
+	first, size := utf8.DecodeRuneInString(target)
+	return string(unicode.ToUpper(first)) + target[size:]
+}
+
+// findLink finds the first link to title in wikitext and returns its anchor
+// text and the sentence it is in, both as plain text. It returns empty strings
+// if there is no such link.
+func findLink(wikitext string, title string) (string, string) {
+	title = normalizeTitle(title)
+	for previous := ""; previous != wikitext; {
+		previous = wikitext
+		wikitext = templateRegexp.ReplaceAllString(wikitext, "")
+	}
+	wikitext = refRegexp.ReplaceAllString(wikitext, "")
+
+	anchorText := ""
+	found := false
+	plain := wikiLinkRegexp.ReplaceAllStringFunc(wikitext, func(link string) string {
+		groups := wikiLinkRegexp.FindStringSubmatch(link)
+		text := groups[2]
+		if text == "" {
+			text = strings.TrimPrefix(groups[1], ":")
+		}
+		if !found && normalizeTitle(groups[1]) == title {
+			found = true
+			anchorText = text
+			return linkMarker + text + linkMarker
+		}
+		return text
+	})
+	if !found {
+		return "", ""
+	}
+	stripFormatting := strings.NewReplacer("'''", "", "''", "")
+	anchorText = stripFormatting.Replace(anchorText)
+	plain = stripFormatting.Replace(plain)
+
+	// the sentence ends at the end of the line or at a full stop followed by
+	// a space, outside the link itself
+	start := strings.Index(plain, linkMarker)
+	end := start + len(linkMarker) + strings.Index(plain[start+len(linkMarker):], linkMarker) + len(linkMarker)
+	sentenceStart := strings.LastIndex(plain[:start], "\n") + 1
+	if i := strings.LastIndex(plain[sentenceStart:start], ". "); i >= 0 {
+		sentenceStart += i + 2
+	}
+	sentenceEnd := len(plain)
+	if i := strings.Index(plain[end:], "\n"); i >= 0 {
+		sentenceEnd = end + i
+	}
+	if i := strings.Index(plain[end:sentenceEnd], ". "); i >= 0 {
+		sentenceEnd = end + i + 1
+	}
+	context := strings.Replace(plain[sentenceStart:sentenceEnd], linkMarker, "", -1)
+	// leave out the markup of list items
+	return anchorText, strings.TrimSpace(strings.TrimLeft(context, "*#:; "))
+}
@@ -0,0 +1,75 @@
+package race
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// An ErrorKind says why a race failed.
+type ErrorKind int
+
+// the kinds of errors races return
+const (
+	// an unexpected error, e.g. a response the racer doesn't understand
+	UnknownError ErrorKind = iota
+	// the start or end page does not exist
+	PageNotFound
+	// the MediaWiki API could not be reached or returned an error
+	UpstreamUnavailable
+	// the MediaWiki API kept answering 429 Too Many Requests
+	RateLimited
+	// the race was cancelled before it finished
+	Cancelled
+	// the parameters of the race are invalid
+	InvalidInput
+	// the server is shutting down and doesn't start new races
+	ShuttingDown
+)
+
+var errorKindNames = map[ErrorKind]string{
+	UnknownError:        "unknown",
+	PageNotFound:        "page_not_found",
+	UpstreamUnavailable: "upstream_unavailable",
+	RateLimited:         "rate_limited",
+	Cancelled:           "cancelled",
+	InvalidInput:        "invalid_input",
+	ShuttingDown:        "shutting_down",
+}
+
+// String returns the name of k, e.g. page_not_found.
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+// Error is an error of a known kind. Its message is meant for clients, so it
+// doesn't have any internal details.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	// the underlying error, if any, which is logged but not shown to clients
+	Err error
+	// close matches for the titles which don't exist, for PageNotFound errors
+	Suggestions map[string][]string
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// newError returns an *Error of kind with a stack trace.
+func newError(kind ErrorKind, format string, args ...interface{}) error {
+	return errors.WithStack(&Error{Kind: kind, Message: fmt.Sprintf(format, args...)})
+}
+
+// AsError returns the *Error which caused err, or an *Error of kind
+// UnknownError with a generic message if err was not caused by one.
+func AsError(err error) *Error {
+	if e, ok := errors.Cause(err).(*Error); ok {
+		return e
+	}
+	return &Error{Kind: UnknownError, Message: "an unexpected error has occurred"}
+}
@@ -0,0 +1,305 @@
+package race
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// A Grapher can describe the part of the Wikipedia graph it explored.
+type Grapher interface {
+	Graph(maxNodes int) *Graph
+}
+
+// Edge is a link from one page to another which was followed during a race.
+type Edge struct {
+	From string
+	To   string
+	// true if the edge was found exploring forward from the start page
+	Forward bool
+}
+
+// Graph is a snapshot of the search trees explored from the start page and
+// from the end page.
+type Graph struct {
+	StartTitle   string
+	EndTitle     string
+	MeetingPoint string
+	Path         []string
+	Edges        []Edge
+	// true if some edges were left out to respect the node limit
+	Truncated bool
+}
+
+// Graph returns the pages explored by the racer. At most maxNodes pages are
+// included, although pages on the final path are always included.
+func (r *defaultRacer) Graph(maxNodes int) *Graph {
+	r.meetingPoint.Lock()
+	g := &Graph{
+		StartTitle:   r.startTitle,
+		EndTitle:     r.endTitle,
+		MeetingPoint: r.meetingPoint.s,
+		Path:         append([]string(nil), r.path...),
+	}
+	r.meetingPoint.Unlock()
+
+	// path edges come first so that they survive truncation
+	for i := 0; i+1 < len(g.Path); i++ {
+		parent, ok := r.pathFromStartMap.get(g.Path[i+1])
+		forward := ok && parent == g.Path[i]
+		g.Edges = append(g.Edges, Edge{From: g.Path[i], To: g.Path[i+1], Forward: forward})
+	}
+
+	var rest []Edge
+	r.pathFromStartMap.RLock()
+	for child, parent := range r.pathFromStartMap.m {
+		if parent != "" {
+			rest = append(rest, Edge{From: parent, To: child, Forward: true})
+		}
+	}
+	r.pathFromStartMap.RUnlock()
+	r.pathFromEndMap.RLock()
+	for child, parent := range r.pathFromEndMap.m {
+		if parent != "" {
+			rest = append(rest, Edge{From: child, To: parent, Forward: false})
+		}
+	}
+	r.pathFromEndMap.RUnlock()
+
+	sort.Slice(rest, func(i, j int) bool {
+		if rest[i].Forward != rest[j].Forward {
+			return rest[i].Forward
+		}
+		if rest[i].From != rest[j].From {
+			return rest[i].From < rest[j].From
+		}
+		return rest[i].To < rest[j].To
+	})
+
+	onPath := g.pathEdges()
+	for _, e := range rest {
+		if !onPath[e] {
+			g.Edges = append(g.Edges, e)
+		}
+	}
+	return g.Truncate(maxNodes)
+}
+
+// Truncate returns a copy of the graph with at most maxNodes pages. Pages on
+// the final path are always kept; other edges are kept in order while they fit.
+func (g *Graph) Truncate(maxNodes int) *Graph {
+	t := *g
+	t.Edges = nil
+
+	nodes := make(map[string]bool)
+	for _, page := range g.Path {
+		nodes[page] = true
+	}
+	onPath := g.pathEdges()
+	for _, e := range g.Edges {
+		newNodes := 0
+		if !nodes[e.From] {
+			newNodes++
+		}
+		if !nodes[e.To] {
+			newNodes++
+		}
+		if !onPath[e] && len(nodes)+newNodes > maxNodes {
+			t.Truncated = true
+			continue
+		}
+		nodes[e.From] = true
+		nodes[e.To] = true
+		t.Edges = append(t.Edges, e)
+	}
+	return &t
+}
+
+// pathEdges returns the set of edges which make up the final path.
+func (g *Graph) pathEdges() map[Edge]bool {
+	onPath := make(map[Edge]bool)
+	for i := 0; i+1 < len(g.Path); i++ {
+		onPath[Edge{From: g.Path[i], To: g.Path[i+1], Forward: true}] = true
+		onPath[Edge{From: g.Path[i], To: g.Path[i+1], Forward: false}] = true
+	}
+	return onPath
+}
+
+// nodes returns every page in the graph in a stable order.
+func (g *Graph) nodes() []string {
+	seen := make(map[string]bool)
+	var nodes []string
+	add := func(page string) {
+		if !seen[page] {
+			seen[page] = true
+			nodes = append(nodes, page)
+		}
+	}
+	for _, page := range g.Path {
+		add(page)
+	}
+	for _, e := range g.Edges {
+		add(e.From)
+		add(e.To)
+	}
+	return nodes
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language. The final path is
+// drawn in red and the meeting point is filled.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var err error
+	printf := func(format string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+
+	onPathNode := make(map[string]bool)
+	for _, page := range g.Path {
+		onPathNode[page] = true
+	}
+	onPath := g.pathEdges()
+
+	printf("digraph wikirace {\n")
+	for _, page := range g.nodes() {
+		attrs := ""
+		if page == g.MeetingPoint {
+			attrs = ` [style=filled, fillcolor=gold, color=red]`
+		} else if onPathNode[page] {
+			attrs = ` [color=red]`
+		}
+		printf("\t%s%s;\n", strconv.Quote(page), attrs)
+	}
+	for _, e := range g.Edges {
+		attrs := ""
+		if onPath[e] {
+			attrs = ` [color=red, penwidth=2]`
+		} else if !e.Forward {
+			attrs = ` [style=dashed]`
+		}
+		printf("\t%s -> %s%s;\n", strconv.Quote(e.From), strconv.Quote(e.To), attrs)
+	}
+	printf("}\n")
+	return err
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// WriteGraphML writes the graph as GraphML. Nodes and edges on the final path
+// have on_path set and the meeting point has meeting_point set.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "on_path", For: "all", AttrName: "on_path", AttrType: "boolean"},
+			{ID: "meeting_point", For: "node", AttrName: "meeting_point", AttrType: "boolean"},
+			{ID: "forward", For: "edge", AttrName: "forward", AttrType: "boolean"},
+		},
+	}
+	doc.Graph.EdgeDefault = "directed"
+
+	onPathNode := make(map[string]bool)
+	for _, page := range g.Path {
+		onPathNode[page] = true
+	}
+	onPath := g.pathEdges()
+
+	for _, page := range g.nodes() {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: page,
+			Data: []graphMLData{
+				{Key: "on_path", Value: strconv.FormatBool(onPathNode[page])},
+				{Key: "meeting_point", Value: strconv.FormatBool(page == g.MeetingPoint)},
+			},
+		})
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: e.From,
+			Target: e.To,
+			Data: []graphMLData{
+				{Key: "on_path", Value: strconv.FormatBool(onPath[e])},
+				{Key: "forward", Value: strconv.FormatBool(e.Forward)},
+			},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+// WriteJSONLines writes the graph as newline delimited JSON. The first line
+// describes the race and every following line is an edge.
+func (g *Graph) WriteJSONLines(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	path := g.Path
+	if path == nil {
+		path = []string{}
+	}
+	err := enc.Encode(map[string]interface{}{
+		"type":          "race",
+		"start":         g.StartTitle,
+		"end":           g.EndTitle,
+		"meeting_point": g.MeetingPoint,
+		"path":          path,
+		"truncated":     g.Truncated,
+	})
+	if err != nil {
+		return err
+	}
+
+	onPath := g.pathEdges()
+	for _, e := range g.Edges {
+		err := enc.Encode(map[string]interface{}{
+			"type":    "edge",
+			"from":    e.From,
+			"to":      e.To,
+			"forward": e.Forward,
+			"on_path": onPath[e],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package race
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Neighborhood explores the pages within depth links of title, following
+// links out of pages, or into them if in is set. Like Distances, every link
+// of each page is explored. Each level is passed to visit as soon as it has
+// been explored, starting with title itself at distance 0, with the pages of
+// a level in sorted order.
+//
+// Exploration stops once maxNodes pages have been visited or the time limit in
+// config passes. Neighborhood returns true if it stopped before reaching depth
+// for either reason. If visit returns false, exploration stops as well.
+func Neighborhood(title string, depth int, in bool, maxNodes int, config Config,
+	visit func(distance int, pages []string) bool) (bool, error) {
+	c := newAPIClient(context.Background(), config)
+	wType := forwardType
+	if in {
+		wType = backwardType
+	}
+
+	done := make(chan struct{})
+	timer := time.AfterFunc(config.TimeLimit, func() { close(done) })
+	defer timer.Stop()
+
+	seen := map[string]bool{title: true}
+	frontier := []string{title}
+	visited := 0
+	for distance := 0; len(frontier) > 0; distance++ {
+		if visited+len(frontier) > maxNodes {
+			if frontier = frontier[:maxNodes-visited]; len(frontier) > 0 {
+				visit(distance, frontier)
+			}
+			return true, nil
+		}
+		visited += len(frontier)
+		if !visit(distance, frontier) {
+			return true, nil
+		}
+		if distance == depth {
+			return false, nil
+		}
+
+		fetched, err := c.fetchAllNeighbors(frontier, wType, done)
+		if err != nil {
+			return false, err
+		}
+		if fetched[title].missing {
+			return false, newError(PageNotFound, "the page %s does not exist", title)
+		}
+		select {
+		case <-done:
+			// the level may not have been fetched completely
+			return true, nil
+		default:
+		}
+
+		var next []string
+		for _, page := range frontier {
+			for _, neighbor := range fetched[page].titles {
+				if !seen[neighbor] {
+					seen[neighbor] = true
+					next = append(next, neighbor)
+				}
+			}
+		}
+		sort.Strings(next)
+		frontier = next
+	}
+	// every page reachable from title has been visited
+	return false, nil
+}
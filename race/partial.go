@@ -0,0 +1,84 @@
+package race
+
+// the number of closest approaches a Partial lists from each side
+const maxApproaches = 5
+
+// A Partialer describes how far a race got without finding a path.
+type Partialer interface {
+	Partial() *Partial
+}
+
+// An Approach is a page reached from one side of a race.
+type Approach struct {
+	Title string `json:"title"`
+	// the number of explored pages which link to the page, or which the page
+	// links to when exploring backward from the end page
+	Links int `json:"links"`
+	// the number of hops from the start page, or to the end page
+	Hops int `json:"hops"`
+}
+
+// Partial is the information a race gathered before it gave up.
+type Partial struct {
+	// pages reached from each side
+	ForwardPages  int `json:"forward_pages"`
+	BackwardPages int `json:"backward_pages"`
+	// pages reached from each side which had not been explored yet
+	ForwardFrontier  int `json:"forward_frontier"`
+	BackwardFrontier int `json:"backward_frontier"`
+	// the most linked pages reached from each side, most linked first. Hubs
+	// link to a lot of pages, so these are the pages most likely to be a hop
+	// away from the other side.
+	ForwardApproaches  []Approach `json:"forward_approaches"`
+	BackwardApproaches []Approach `json:"backward_approaches"`
+	// a path from the start page to the first forward approach followed by a
+	// path from the first backward approach to the end page. The hop between
+	// the two approaches is a guess which may not exist.
+	BestGuess []string `json:"best_guess"`
+}
+
+// Partial returns what the racer found out while it ran. It is meant to be
+// called after Run returns without a path.
+func (r *defaultRacer) Partial() *Partial {
+	progress := r.Progress()
+	p := &Partial{
+		ForwardPages:       progress.ForwardPages,
+		BackwardPages:      progress.BackwardPages,
+		ForwardFrontier:    len(r.forwardLinks),
+		BackwardFrontier:   len(r.backwardLinks),
+		ForwardApproaches:  r.approaches(&r.linksFromStart, &r.pathFromStartMap),
+		BackwardApproaches: r.approaches(&r.linksFromEnd, &r.pathFromEndMap),
+	}
+
+	if len(p.ForwardApproaches) == 0 && len(p.BackwardApproaches) == 0 {
+		return p
+	}
+	fromStart := []string{r.startTitle}
+	if len(p.ForwardApproaches) > 0 {
+		fromStart = getPath(p.ForwardApproaches[0].Title, &r.pathFromStartMap)
+		reverse(fromStart)
+	}
+	toEnd := []string{r.endTitle}
+	if len(p.BackwardApproaches) > 0 {
+		toEnd = getPath(p.BackwardApproaches[0].Title, &r.pathFromEndMap)
+	}
+	p.BestGuess = append(fromStart, toEnd...)
+	return p
+}
+
+// approaches returns the most linked pages in counts, except the start and end
+// pages, with their distance from the side of the race whose paths are given.
+func (r *defaultRacer) approaches(counts *concurrentCounter, paths *concurrentMap) []Approach {
+	approaches := []Approach{}
+	for _, title := range counts.top(maxApproaches + 2) {
+		if title == r.startTitle || title == r.endTitle || len(approaches) == maxApproaches {
+			continue
+		}
+		approaches = append(approaches, Approach{
+			Title: title,
+			Links: counts.get(title),
+			Hops:  len(getPath(title, paths)) - 1,
+		})
+	}
+	return approaches
+}
@@ -2,6 +2,9 @@
 package race
 
 import (
+	"context"
+	"math/rand"
+	"net/http"
 	"sync"
 	"time"
 
@@ -19,13 +22,82 @@ type Racer interface {
 	Run() ([]string, error)
 }
 
+// A Canceler is a Racer which can be stopped before it finishes.
+type Canceler interface {
+	// Cancel makes Run return a Cancelled error unless it has already
+	// finished.
+	Cancel()
+}
+
+// A Stopper is a Racer which can be ended early.
+type Stopper interface {
+	// Stop ends the race as if its time limit had run out, so Run returns the
+	// path if one was found and nil otherwise.
+	Stop()
+}
+
+// Config holds the parameters which control how a single race is run.
+type Config struct {
+	// explored until this limit and then give up
+	TimeLimit time.Duration `json:"time_limit"`
+	// number of goroutines exploring forward from the start page
+	NumForwardLinksRoutines int `json:"forward_workers"`
+	// number of goroutines exploring backward from the end page
+	NumBackwardLinksRoutines int `json:"backward_workers"`
+	// keep querying the API until every link on a page has been returned
+	ExploreAllLinks bool `json:"all_links"`
+	// the MediaWiki namespaces to explore; empty means all namespaces
+	Namespaces []int `json:"namespaces"`
+	// seeds all randomness in the race; 0 picks a seed from the clock
+	Seed int64 `json:"seed"`
+	// explore with a single goroutine so that the same API responses always
+	// produce the same path
+	Deterministic bool `json:"deterministic"`
+	// the kinds of edges to follow; empty means only links
+	Edges []EdgeKind `json:"edges,omitempty"`
+	// the MediaWiki api.php endpoint to query
+	APIURL string `json:"api_url"`
+	// makes requests to the MediaWiki API; nil uses http.DefaultTransport
+	Transport http.RoundTripper `json:"-"`
+}
+
+// DefaultConfig returns the configuration used when a race does not override
+// any parameters. It is initialized from the environment.
+func DefaultConfig() Config {
+	c := defaultConfig
+	c.Namespaces = append([]int(nil), defaultConfig.Namespaces...)
+	c.Edges = append([]EdgeKind(nil), defaultConfig.Edges...)
+	return c
+}
+
 type defaultRacer struct {
+	// queries the MediaWiki API with the parameters of this race, which are
+	// in its config
+	*apiClient
 	startTitle string
 	endTitle   string
 	// mapping of pages to the page that linked to them (found from startTitle)
 	pathFromStartMap concurrentMap
 	// mapping of pages to the page they linked to (found from endTitle)
 	pathFromEndMap concurrentMap
+	// the kinds of edges by which the pages in pathFromStartMap and
+	// pathFromEndMap were reached, unless the race only follows links
+	kindsFromStartMap concurrentMap
+	kindsFromEndMap   concurrentMap
+	// the number of explored pages which link to (from the start) or are
+	// linked from (from the end) each page reached
+	linksFromStart concurrentCounter
+	linksFromEnd   concurrentCounter
+	// the pages being explored from each side, with the parameters which
+	// continue the queries for their links
+	forwardCursors  cursorMap
+	backwardCursors cursorMap
+	// true if the race continues from a Snapshot, which already has the
+	// start and end pages
+	resumed bool
+	// true if the pages are explored by the nodes of a Hub rather than by
+	// workers
+	remote bool
 	// pages found exploring from startTitle which should be explored
 	forwardLinks chan string
 	// pages found exploring from endTitle which should be explored
@@ -34,50 +106,85 @@ type defaultRacer struct {
 	done chan bool
 	// ensures that `done` is only closed once
 	closeOnce sync.Once
-	// explored until this limit and then give up
-	timeLimit time.Duration
+	// cancels the context of the apiClient once the race is over, which
+	// aborts requests in flight
+	cancel context.CancelFunc
+	// the workers started by Run, which waits for them before returning
+	workers sync.WaitGroup
+	// the source of all randomness in this race
+	rand lockedRand
 	// err that should be passed back to requester
 	err error
 	// the page at which the connected component from startTitle meets the
 	// conntected component from endTitle
 	meetingPoint lockerString
+	// the path found by Run and the kind of edge each hop follows, guarded by
+	// meetingPoint
+	path      []string
+	pathKinds []EdgeKind
 }
 
-func newDefaultRacer(startTitle string, endTitle string, timeLimit time.Duration) *defaultRacer {
+func newDefaultRacer(startTitle string, endTitle string, config Config) *defaultRacer {
 	r := new(defaultRacer)
 	r.startTitle = startTitle
 	r.endTitle = endTitle
 	r.pathFromStartMap = concurrentMap{m: make(map[string]string)}
 	r.pathFromEndMap = concurrentMap{m: make(map[string]string)}
+	r.kindsFromStartMap = concurrentMap{m: make(map[string]string)}
+	r.kindsFromEndMap = concurrentMap{m: make(map[string]string)}
+	r.linksFromStart = concurrentCounter{m: make(map[string]int)}
+	r.linksFromEnd = concurrentCounter{m: make(map[string]int)}
+	r.forwardCursors = cursorMap{m: make(map[string]map[string]string)}
+	r.backwardCursors = cursorMap{m: make(map[string]map[string]string)}
 	r.forwardLinks = make(chan string, forwardLinksChannelSize)
 	r.backwardLinks = make(chan string, backwardLinksChannelSize)
 	r.done = make(chan bool, 1)
-	r.timeLimit = timeLimit
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	r.apiClient = newAPIClient(ctx, config)
+	r.rand = lockedRand{r: rand.New(rand.NewSource(config.Seed))}
 	return r
 }
 
-// NewRacer returns a Racer which can run a race from start to end.
-func NewRacer(startTitle string, endTitle string, timeLimit time.Duration) Racer {
-	return newDefaultRacer(startTitle, endTitle, timeLimit)
+// NewRacer returns a Racer which can run a race from start to end using the
+// supplied configuration.
+func NewRacer(startTitle string, endTitle string, config Config) Racer {
+	return newDefaultRacer(startTitle, endTitle, config)
 }
 
 // Run finds a path from start to end and returns it.
 func (r *defaultRacer) Run() ([]string, error) {
-	r.pathFromStartMap.put(r.startTitle, "")
-	r.pathFromEndMap.put(r.endTitle, "")
-	r.forwardLinks <- r.startTitle
-	r.backwardLinks <- r.endTitle
-
-	for i := 0; i < config.numForwardLinksRoutines; i++ {
-		go r.forwardLinksWorker()
+	if !r.resumed {
+		r.pathFromStartMap.put(r.startTitle, "")
+		r.pathFromEndMap.put(r.endTitle, "")
+		r.forwardLinks <- r.startTitle
+		r.backwardLinks <- r.endTitle
 	}
-	for i := 0; i < config.numBackwardLinksRoutines; i++ {
-		go r.backwardLinksWorker()
+
+	// the frontiers of a remote race are explored by the nodes which lease
+	// pages from them, not by local workers
+	if !r.remote {
+		if r.config.Deterministic {
+			r.startWorker(r.deterministicWorker)
+		} else {
+			for i := 0; i < r.config.NumForwardLinksRoutines; i++ {
+				r.startWorker(r.forwardLinksWorker)
+			}
+			for i := 0; i < r.config.NumBackwardLinksRoutines; i++ {
+				r.startWorker(r.backwardLinksWorker)
+			}
+		}
 	}
-	timer := time.NewTimer(r.timeLimit)
+	timer := time.NewTimer(r.config.TimeLimit)
 	go r.giveUpAfterTime(timer)
 	_ = <-r.done
 
+	timer.Stop()
+	r.stopWorkers()
+
 	log.Debugf("forwardLinks length is %d and backwardLinks length is %d", len(r.forwardLinks), len(r.backwardLinks))
 	if r.err != nil {
 		return nil, errors.WithStack(r.err)
@@ -102,7 +209,25 @@ func (r *defaultRacer) Run() ([]string, error) {
 
 	pathFromEnd := getPath(r.meetingPoint.s, &r.pathFromEndMap)
 	finalPath := append(pathFromStart, pathFromEnd...)
+	r.path = finalPath
+	r.pathKinds = r.kindsOfPath(finalPath, len(pathFromStart))
 
 	r.meetingPoint.Unlock()
 	return finalPath, nil
 }
+
+// Stop ends the race as if its time limit had run out.
+func (r *defaultRacer) Stop() {
+	r.closeOnce.Do(func() {
+		close(r.done) // kill all goroutines
+	})
+}
+
+// Cancel stops the race. Run returns a Cancelled error unless it had already
+// finished.
+func (r *defaultRacer) Cancel() {
+	r.closeOnce.Do(func() {
+		r.err = newError(Cancelled, "the race was cancelled")
+		close(r.done)
+	})
+}
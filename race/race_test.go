@@ -1,13 +1,25 @@
 package race
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/sandlerben/wikiracer/fakewiki"
+	"github.com/sandlerben/wikiracer/fixture"
 	httpmock "gopkg.in/jarcoal/httpmock.v1"
 )
 
@@ -19,11 +31,6 @@ var (
 	backwardLinksResponseWithContinue = `{"continue": {"plcontinue": "39027|0|Shawn_Michaels","continue": "||"},"query":{"pages":[{"pageid":8569916,"ns":0,"title":"end","linkshere":[{"ns":14,"title":"Hebrew language"}]}]}`
 )
 
-func init() {
-	// mock the randomness of workers.go
-	rand.Seed(0)
-}
-
 func TestLoopUntilResponse(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -41,7 +48,7 @@ func TestLoopUntilResponse(t *testing.T) {
 			return resp, nil
 		})
 
-	r := newDefaultRacer("start", "end", 1*time.Minute)
+	r := newDefaultRacer("start", "end", DefaultConfig())
 	u, _ := url.Parse("http://example.com")
 	resp, err := r.loopUntilResponse(u)
 	if err != nil {
@@ -91,12 +98,13 @@ func TestForwardLinksWorker(t *testing.T) {
 	httpmock.RegisterResponder("GET", u.String(),
 		httpmock.NewStringResponder(200, forwardLinksResponse))
 
-	r := newDefaultRacer("start", "German language", 1*time.Minute)
+	r := newDefaultRacer("start", "German language", DefaultConfig())
 	r.pathFromEndMap.put("German language", "")
 
 	r.forwardLinks <- linkToGet
-	go r.forwardLinksWorker()
+	r.startWorker(r.forwardLinksWorker)
 	_ = <-r.done // we will only go past this line if forwardLinksWorker closes done
+	r.stopWorkers()
 
 	samplePages := []string{"English language", "French language", "Spanish language", "German language"}
 	for _, page := range samplePages {
@@ -115,11 +123,12 @@ func TestForwardLinksWorkerHandleErr(t *testing.T) {
 	httpmock.RegisterResponder("GET", u.String(),
 		httpmock.NewStringResponder(200, `{"batchcomplete":true}`))
 
-	r := newDefaultRacer("start", "end", 1*time.Minute)
+	r := newDefaultRacer("start", "end", DefaultConfig())
 
 	r.forwardLinks <- linkToGet
-	go r.forwardLinksWorker()
+	r.startWorker(r.forwardLinksWorker)
 	_ = <-r.done // we will only go past this line if forwardLinksWorker closes done
+	r.stopWorkers()
 
 	samplePages := []string{"English language", "French language", "Spanish language", "German language"}
 	for _, page := range samplePages {
@@ -152,12 +161,13 @@ func TestBackwardLinksWorker(t *testing.T) {
 	httpmock.RegisterResponder("GET", u.String(),
 		httpmock.NewStringResponder(200, backwardLinksResponse))
 
-	r := newDefaultRacer("German language", "end", 1*time.Minute)
+	r := newDefaultRacer("German language", "end", DefaultConfig())
 	r.pathFromStartMap.put("German language", "")
 
 	r.backwardLinks <- linkToGet
-	go r.backwardLinksWorker()
+	r.startWorker(r.backwardLinksWorker)
 	_ = <-r.done // we will only go past this line if backwardLinksWorker closes done
+	r.stopWorkers()
 
 	samplePages := []string{"English language", "French language", "Spanish language", "German language"}
 	for _, page := range samplePages {
@@ -176,11 +186,12 @@ func TestBackwardLinksWorkerHandleErr(t *testing.T) {
 	httpmock.RegisterResponder("GET", u.String(),
 		httpmock.NewStringResponder(200, `{"batchcomplete":true}`))
 
-	r := newDefaultRacer("start", "end", 1*time.Minute)
+	r := newDefaultRacer("start", "end", DefaultConfig())
 
 	r.backwardLinks <- linkToGet
-	go r.backwardLinksWorker()
+	r.startWorker(r.backwardLinksWorker)
 	_ = <-r.done // we will only go past this line if backwardLinksWorker closes done
+	r.stopWorkers()
 
 	samplePages := []string{"English language", "French language", "Spanish language", "German language"}
 	for _, page := range samplePages {
@@ -189,3 +200,959 @@ func TestBackwardLinksWorkerHandleErr(t *testing.T) {
 		}
 	}
 }
+
+func newExploredRacer() *defaultRacer {
+	r := newDefaultRacer("start", "end", DefaultConfig())
+	r.pathFromStartMap.put("start", "")
+	r.pathFromStartMap.put("a", "start")
+	r.pathFromStartMap.put("b", "start")
+	r.pathFromStartMap.put("meet", "a")
+	r.pathFromEndMap.put("end", "")
+	r.pathFromEndMap.put("c", "end")
+	r.pathFromEndMap.put("meet", "c")
+	r.meetingPoint.set("meet")
+	r.path = []string{"start", "a", "meet", "c", "end"}
+	return r
+}
+
+func TestGraph(t *testing.T) {
+	g := newExploredRacer().Graph(100)
+
+	expected := []Edge{
+		{From: "start", To: "a", Forward: true},
+		{From: "a", To: "meet", Forward: true},
+		{From: "meet", To: "c", Forward: false},
+		{From: "c", To: "end", Forward: false},
+		{From: "start", To: "b", Forward: true},
+	}
+	if !reflect.DeepEqual(g.Edges, expected) {
+		t.Errorf("Graph returned edges %v instead of %v", g.Edges, expected)
+	}
+	if g.Truncated {
+		t.Error("graph should not be truncated")
+	}
+
+	g = g.Truncate(5)
+	if !g.Truncated || len(g.Edges) != 4 {
+		t.Errorf("truncated graph should only contain the path but has edges %v", g.Edges)
+	}
+}
+
+func TestGraphWriters(t *testing.T) {
+	g := newExploredRacer().Graph(100)
+
+	var dot bytes.Buffer
+	if err := g.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"meet" [style=filled, fillcolor=gold, color=red];`, `"start" -> "a" [color=red, penwidth=2];`, `"start" -> "b";`} {
+		if !strings.Contains(dot.String(), s) {
+			t.Errorf("DOT output does not contain %s:\n%s", s, dot.String())
+		}
+	}
+
+	var graphML bytes.Buffer
+	if err := g.WriteGraphML(&graphML); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(graphML.String(), `<edge source="start" target="b">`) {
+		t.Errorf("GraphML output is missing an edge:\n%s", graphML.String())
+	}
+
+	var jsonLines bytes.Buffer
+	if err := g.WriteJSONLines(&jsonLines); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(jsonLines.String(), "\n"); lines != 6 {
+		t.Errorf("JSON lines output should have 6 lines but has %d", lines)
+	}
+}
+
+// graphResponder answers links and linkshere queries for a small fake graph.
+func graphResponder(links map[string][]string) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		title := q.Get("titles")
+		var neighbors []string
+		key := "links"
+		if q.Get("prop") == "linkshere" {
+			key = "linkshere"
+			for from, tos := range links {
+				for _, to := range tos {
+					if to == title {
+						neighbors = append(neighbors, from)
+					}
+				}
+			}
+			sort.Strings(neighbors)
+		} else {
+			neighbors = links[title]
+		}
+		if q.Get("pldir") == "descending" {
+			neighbors = append([]string(nil), neighbors...)
+			sort.Sort(sort.Reverse(sort.StringSlice(neighbors)))
+		}
+
+		var linksJSON []string
+		for _, n := range neighbors {
+			linksJSON = append(linksJSON, fmt.Sprintf(`{"ns":0,"title":%q}`, n))
+		}
+		body := fmt.Sprintf(`{"query":{"pages":[{"ns":0,"title":%q,%q:[%s]}]}}`,
+			title, key, strings.Join(linksJSON, ","))
+		return httpmock.NewStringResponse(200, body), nil
+	}
+}
+
+func TestDeterministicRun(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://en.wikipedia.org/w/api.php",
+		graphResponder(map[string][]string{
+			"start": {"a", "b", "c"},
+			"a":     {"x"},
+			"b":     {"y"},
+			"c":     {"z"},
+			"x":     {"end"},
+			"y":     {"end"},
+			"z":     {"end"},
+		}))
+
+	config := DefaultConfig()
+	config.Seed = 7
+	config.Deterministic = true
+
+	var first []string
+	for i := 0; i < 5; i++ {
+		path, err := newDefaultRacer("start", "end", config).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(path) != 4 || path[0] != "start" || path[3] != "end" {
+			t.Fatalf("Run returned an invalid path %v", path)
+		}
+		if first == nil {
+			first = path
+		} else if !reflect.DeepEqual(path, first) {
+			t.Errorf("Run returned %v and then %v with the same seed", first, path)
+		}
+	}
+}
+
+func TestDistances(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://en.wikipedia.org/w/api.php",
+		graphResponder(map[string][]string{
+			"start":  {"a", "b"},
+			"a":      {"c"},
+			"b":      {"end"},
+			"c":      {"end"},
+			"end":    {"start"},
+			"island": {},
+		}))
+
+	matrix, err := Distances([]string{"start", "c", "end", "island"}, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]int{
+		{0, 2, 2, -1},
+		{2, 0, 1, -1},
+		{1, 3, 0, -1},
+		{-1, -1, -1, 0},
+	}
+	for i := range expected {
+		for j := range expected[i] {
+			d := matrix.Distances[i][j]
+			if d.Hops != expected[i][j] || !d.Exact {
+				t.Errorf("distance from %s to %s should be exactly %d but is %+v",
+					matrix.Titles[i], matrix.Titles[j], expected[i][j], d)
+			}
+		}
+	}
+}
+
+func TestDistanceUpperBounds(t *testing.T) {
+	matrix := &DistanceMatrix{
+		Titles: []string{"a", "b", "c"},
+		Distances: [][]Distance{
+			{{0, true}, {2, true}, {-1, false}},
+			{{-1, false}, {0, true}, {3, false}},
+			{{1, true}, {-1, false}, {0, true}},
+		},
+	}
+	matrix.applyUpperBounds()
+
+	if d := matrix.Distances[0][2]; d.Hops != 5 || d.Exact {
+		t.Errorf("distance from a to c should be at most 5 but is %+v", d)
+	}
+	if d := matrix.Distances[2][1]; d.Hops != 3 || d.Exact {
+		t.Errorf("distance from c to b should be at most 3 but is %+v", d)
+	}
+	if d := matrix.Distances[1][0]; d.Hops != 4 || d.Exact {
+		t.Errorf("distance from b to a should be at most 4 but is %+v", d)
+	}
+}
+
+func TestNeighborhood(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://en.wikipedia.org/w/api.php",
+		graphResponder(map[string][]string{
+			"start": {"b", "a"},
+			"a":     {"c", "start"},
+			"b":     {"c", "d"},
+			"c":     {"e"},
+		}))
+
+	var levels [][]string
+	visit := func(distance int, pages []string) bool {
+		levels = append(levels, pages)
+		return true
+	}
+
+	truncated, err := Neighborhood("start", 2, false, 100, DefaultConfig(), visit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{{"start"}, {"a", "b"}, {"c", "d"}}
+	if truncated || !reflect.DeepEqual(levels, expected) {
+		t.Errorf("Neighborhood visited %v (truncated %v) instead of %v", levels, truncated, expected)
+	}
+
+	levels = nil
+	truncated, err = Neighborhood("c", 5, true, 100, DefaultConfig(), visit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = [][]string{{"c"}, {"a", "b"}, {"start"}}
+	if truncated || !reflect.DeepEqual(levels, expected) {
+		t.Errorf("Neighborhood visited %v (truncated %v) instead of %v", levels, truncated, expected)
+	}
+
+	levels = nil
+	truncated, err = Neighborhood("start", 2, false, 4, DefaultConfig(), visit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = [][]string{{"start"}, {"a", "b"}, {"c"}}
+	if !truncated || !reflect.DeepEqual(levels, expected) {
+		t.Errorf("Neighborhood visited %v (truncated %v) instead of %v", levels, truncated, expected)
+	}
+}
+
+var record = flag.Bool("record", false, "record the end-to-end fixtures from the live MediaWiki API instead of replaying them")
+
+// fixtureTransport returns a transport which replays the fixtures called name,
+// or records them if the -record flag is set. Requests which weren't recorded
+// fail.
+//
+// The kevin-bacon-to-philosophy fixtures are synthetic: they were generated
+// from a small hand-written graph, not recorded from Wikipedia.
+func fixtureTransport(tb testing.TB, name string) http.RoundTripper {
+	dir := filepath.Join("testdata", "fixtures", name)
+	if *record {
+		return fixture.NewRecorder(dir, nil)
+	}
+	replayer, err := fixture.NewReplayer(dir)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	replayer.Strict = true
+	return replayer
+}
+
+func replayedConfig(tb testing.TB, name string) Config {
+	config := DefaultConfig()
+	config.Transport = fixtureTransport(tb, name)
+	config.ExploreAllLinks = true
+	config.Seed = 1
+	return config
+}
+
+func TestReplayedRace(t *testing.T) {
+	config := replayedConfig(t, "kevin-bacon-to-philosophy")
+	path, err := newDefaultRacer("Kevin Bacon", "Philosophy", config).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) < 2 || path[0] != "Kevin Bacon" || path[len(path)-1] != "Philosophy" {
+		t.Errorf("Run returned an invalid path %v", path)
+	}
+}
+
+func TestReplayedRaceIsDeterministic(t *testing.T) {
+	config := replayedConfig(t, "kevin-bacon-to-philosophy")
+	config.Deterministic = true
+
+	first, err := newDefaultRacer("Kevin Bacon", "Philosophy", config).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newDefaultRacer("Kevin Bacon", "Philosophy", config).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || !reflect.DeepEqual(first, second) {
+		t.Errorf("Run returned %v and then %v with the same seed", first, second)
+	}
+}
+
+func BenchmarkReplayedRace(b *testing.B) {
+	config := replayedConfig(b, "kevin-bacon-to-philosophy")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := newDefaultRacer("Kevin Bacon", "Philosophy", config).Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReplayedRaceDeterministic(b *testing.B) {
+	config := replayedConfig(b, "kevin-bacon-to-philosophy")
+	config.Deterministic = true
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := newDefaultRacer("Kevin Bacon", "Philosophy", config).Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFindLink(t *testing.T) {
+	wikitext := "{{Infobox person\n| spouse = [[Kyra Sedgwick]]\n}}\n" +
+		"'''Kevin Bacon''' is an actor.<ref>{{cite web|title=A. B.}}</ref> He starred in ''[[Footloose (1984 film)|Footloose]]'' and [[JFK (film)|JFK]]. He is married to [[kyra_Sedgwick]].\n" +
+		"== See also ==\n* [[Six Degrees of Kevin Bacon]]"
+
+	tests := []struct {
+		title      string
+		anchorText string
+		context    string
+	}{
+		{"Footloose (1984 film)", "Footloose", "He starred in Footloose and JFK."},
+		{"Kyra Sedgwick", "kyra_Sedgwick", "He is married to kyra_Sedgwick."},
+		{"Six Degrees of Kevin Bacon", "Six Degrees of Kevin Bacon", "Six Degrees of Kevin Bacon"},
+		{"Philosophy", "", ""},
+	}
+	for _, test := range tests {
+		anchorText, context := findLink(wikitext, test.title)
+		if anchorText != test.anchorText || context != test.context {
+			t.Errorf("link to %s should be %q in %q but got %q in %q",
+				test.title, test.anchorText, test.context, anchorText, context)
+		}
+	}
+}
+
+func TestEnrichPath(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://en.wikipedia.org/w/api.php",
+		func(req *http.Request) (*http.Response, error) {
+			q := req.URL.Query()
+			if q.Get("action") == "parse" {
+				wikitext := map[string]string{
+					"start":  "start links to [[Middle|the middle]]. Nothing else.",
+					"middle": "The end is [[end]].",
+				}[q.Get("page")]
+				return httpmock.NewStringResponse(200, fmt.Sprintf(`{"parse":{"title":%q,"wikitext":%q}}`, q.Get("page"), wikitext)), nil
+			}
+			if q.Get("prop") != "info|extracts" {
+				return httpmock.NewStringResponse(200, `{"error":{"code":"badvalue","info":"no extracts"}}`), nil
+			}
+			return httpmock.NewStringResponse(200, `{"query":{
+				"normalized":[{"from":"start","to":"Start"}],
+				"pages":[
+					{"pageid":3,"ns":0,"title":"middle","extract":"The middle."},
+					{"pageid":1,"ns":0,"title":"Start","extract":"The start."},
+					{"ns":0,"title":"end","missing":true}
+				]}}`), nil
+		})
+
+	enriched, err := EnrichPath([]string{"start", "middle", "end"}, true, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPages := []Page{
+		{Title: "Start", PageID: 1, Extract: "The start."},
+		{Title: "middle", PageID: 3, Extract: "The middle."},
+		{Title: "end"},
+	}
+	if !reflect.DeepEqual(enriched.Pages, expectedPages) {
+		t.Errorf("pages should be %+v but are %+v", expectedPages, enriched.Pages)
+	}
+	expectedHops := []Hop{
+		{From: "start", To: "middle", AnchorText: "the middle", Context: "start links to the middle."},
+		{From: "middle", To: "end", AnchorText: "end", Context: "The end is end."},
+	}
+	if !reflect.DeepEqual(enriched.Hops, expectedHops) {
+		t.Errorf("hops should be %+v but are %+v", expectedHops, enriched.Hops)
+	}
+
+	if _, err := EnrichPath([]string{"start", "middle"}, false, DefaultConfig()); err == nil {
+		t.Error("an API error should be returned")
+	}
+}
+
+// edgeResponder answers prop and list queries from a map of modules to the
+// pages each page lists under that module.
+func edgeResponder(modules map[string]map[string][]string) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		toJSON := func(titles []string) string {
+			var pages []string
+			for _, title := range titles {
+				pages = append(pages, fmt.Sprintf(`{"title":%q}`, title))
+			}
+			return "[" + strings.Join(pages, ",") + "]"
+		}
+		if list := q.Get("list"); list != "" {
+			body := fmt.Sprintf(`{"query":{%q:%s}}`, list, toJSON(modules[list][q.Get("cmtitle")]))
+			return httpmock.NewStringResponse(200, body), nil
+		}
+		prop, title := q.Get("prop"), q.Get("titles")
+		body := fmt.Sprintf(`{"query":{"pages":[{"title":%q,%q:%s}]}}`, title, prop, toJSON(modules[prop][title]))
+		return httpmock.NewStringResponse(200, body), nil
+	}
+}
+
+func TestEdgeKinds(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://en.wikipedia.org/w/api.php",
+		edgeResponder(map[string]map[string][]string{
+			"categories":      {"Kevin Bacon": {"Category:Actors"}, "Tom Hanks": {"Category:Actors"}},
+			"categorymembers": {"Category:Actors": {"Kevin Bacon", "Tom Hanks"}},
+			"templates":       {"Kevin Bacon": {"Template:Footloose"}, "Lori Singer": {"Template:Footloose"}},
+			"transcludedin":   {"Template:Footloose": {"Kevin Bacon", "Lori Singer"}},
+		}))
+
+	tests := []struct {
+		end   string
+		edges []EdgeKind
+		path  []string
+		kinds []EdgeKind
+	}{
+		{"Tom Hanks", []EdgeKind{LinkEdge, CategoryEdge},
+			[]string{"Kevin Bacon", "Category:Actors", "Tom Hanks"}, []EdgeKind{CategoryEdge, CategoryEdge}},
+		{"Lori Singer", []EdgeKind{TemplateEdge, TransclusionEdge},
+			[]string{"Kevin Bacon", "Template:Footloose", "Lori Singer"}, []EdgeKind{TemplateEdge, TransclusionEdge}},
+	}
+	for _, test := range tests {
+		config := DefaultConfig()
+		config.Edges = test.edges
+		config.TimeLimit = 5 * time.Second
+		r := newDefaultRacer("Kevin Bacon", test.end, config)
+		path, err := r.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(path, test.path) {
+			t.Errorf("path to %s should be %v but is %v", test.end, test.path, path)
+		}
+		if kinds := r.EdgeKinds(); !reflect.DeepEqual(kinds, test.kinds) {
+			t.Errorf("edges to %s should be %v but are %v", test.end, test.kinds, kinds)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Edges = []EdgeKind{TemplateEdge}
+	config.TimeLimit = 100 * time.Millisecond
+	if path, err := NewRacer("Kevin Bacon", "Lori Singer", config).Run(); err != nil || path != nil {
+		t.Errorf("without transclusion edges there should be no path, got %v and %v", path, err)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://example.com/busy",
+		httpmock.NewStringResponder(429, "try again"))
+	httpmock.RegisterResponder("GET", "http://example.com/down",
+		httpmock.NewStringResponder(503, "down"))
+	r := newDefaultRacer("start", "end", DefaultConfig())
+	r.maxRateLimitRetries = 2
+	for path, kind := range map[string]ErrorKind{"/busy": RateLimited, "/down": UpstreamUnavailable} {
+		u, _ := url.Parse("http://example.com" + path)
+		if _, err := r.loopUntilResponse(u); AsError(err).Kind != kind {
+			t.Errorf("%s should be a %s error but got %v", path, kind, err)
+		}
+	}
+
+	// both workers request the page at once, so each needs its own body
+	httpmock.RegisterResponder("GET", "https://en.wikipedia.org/w/api.php",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewStringResponse(200, `{"query":{"pages":[{"ns":0,"title":"start","missing":true}]}}`), nil
+		})
+	if _, err := NewRacer("start", "end", DefaultConfig()).Run(); AsError(err).Kind != PageNotFound {
+		t.Errorf("a missing start page should be a page_not_found error but got %v", err)
+	}
+
+	r = newDefaultRacer("start", "end", DefaultConfig())
+	r.Cancel()
+	if _, err := r.Run(); AsError(err).Kind != Cancelled {
+		t.Errorf("a cancelled race should return a cancelled error but got %v", err)
+	}
+
+	if e := AsError(errors.New("internal detail")); e.Kind != UnknownError || strings.Contains(e.Message, "detail") {
+		t.Errorf("other errors should be unknown without details, got %+v", e)
+	}
+}
+
+func TestPutIfAbsentConcurrently(t *testing.T) {
+	var c concurrentMap
+	c.m = make(map[string]string)
+	wins := make(chan string, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if c.putIfAbsent("page", fmt.Sprint(i)) {
+				wins <- fmt.Sprint(i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(wins)
+
+	var winners []string
+	for w := range wins {
+		winners = append(winners, w)
+	}
+	if len(winners) != 1 {
+		t.Fatalf("exactly one put should win but %d did", len(winners))
+	}
+	if v, _ := c.get("page"); v != winners[0] {
+		t.Errorf("page should map to the winner %s but maps to %s", winners[0], v)
+	}
+}
+
+// stressGraph returns a random graph of n pages which link to degree pages
+// each.
+func stressGraph(n int, degree int) *fakewiki.Graph {
+	rnd := rand.New(rand.NewSource(42))
+	g := fakewiki.NewGraph()
+	for i := 0; i < n; i++ {
+		g.AddPage(fmt.Sprintf("Page %d", i))
+		for j := 0; j < degree; j++ {
+			g.AddLink(fmt.Sprintf("Page %d", i), fmt.Sprintf("Page %d", rnd.Intn(n)))
+		}
+	}
+	return g
+}
+
+// TestStress runs many races with many workers at once against a fake wiki
+// which rate limits some requests. It is meant for `go test -race`, which
+// reports any unsynchronized access to the racers' shared state.
+func TestStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+	g := stressGraph(300, 4)
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+	wiki.SetRateLimitEvery(7)
+
+	config := DefaultConfig()
+	config.APIURL = wiki.APIURL()
+	config.NumForwardLinksRoutines = 8
+	config.NumBackwardLinksRoutines = 8
+	config.TimeLimit = 30 * time.Second
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(start string, end string) {
+			defer wg.Done()
+			path, err := NewRacer(start, end, config).Run()
+			if err != nil {
+				t.Errorf("%s -> %s: %+v", start, end, err)
+				return
+			}
+			if path == nil {
+				// the graph is random, so end may be unreachable
+				return
+			}
+			if path[0] != start || path[len(path)-1] != end {
+				t.Errorf("%s -> %s: path %v has the wrong ends", start, end, path)
+			}
+			seen := make(map[string]bool)
+			for j, page := range path {
+				if seen[page] {
+					t.Errorf("%s -> %s: path %v visits %s twice", start, end, path, page)
+				}
+				seen[page] = true
+				if j > 0 && !contains(g.Links(path[j-1]), page) {
+					t.Errorf("%s -> %s: path %v has no link from %s to %s", start, end, path, path[j-1], page)
+				}
+			}
+		}(fmt.Sprintf("Page %d", i), fmt.Sprintf("Page %d", 299-i))
+	}
+	wg.Wait()
+}
+
+func contains(titles []string, title string) bool {
+	for _, t := range titles {
+		if t == title {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStop(t *testing.T) {
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> middle\nother -> end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+	config := DefaultConfig()
+	config.APIURL = wiki.APIURL()
+	config.TimeLimit = time.Minute
+
+	r := NewRacer("start", "end", config)
+	time.AfterFunc(50*time.Millisecond, r.(Stopper).Stop)
+	began := time.Now()
+	path, err := r.Run()
+	if path != nil || err != nil {
+		t.Errorf("a stopped race should return no path and no error, got %v and %v", path, err)
+	}
+	if elapsed := time.Since(began); elapsed > 10*time.Second {
+		t.Errorf("the race should stop right away but took %s", elapsed)
+	}
+}
+
+func TestPartial(t *testing.T) {
+	// end can't be reached from start
+	g, err := fakewiki.ParseGraph(strings.NewReader(`
+		start -> a | b
+		a -> hub
+		b -> hub
+		hub -> c
+		x -> end
+		y -> x
+		z -> x
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+	config := DefaultConfig()
+	config.APIURL = wiki.APIURL()
+	config.TimeLimit = 500 * time.Millisecond
+
+	r := NewRacer("start", "end", config)
+	if path, err := r.Run(); path != nil || err != nil {
+		t.Fatalf("there should be no path, got %v and %v", path, err)
+	}
+	p := r.(Partialer).Partial()
+	if p.ForwardPages != 5 || p.BackwardPages != 4 {
+		t.Errorf("expected 5 pages forward and 4 backward but got %d and %d", p.ForwardPages, p.BackwardPages)
+	}
+	if p.ForwardFrontier != 0 || p.BackwardFrontier != 0 {
+		t.Errorf("every page should have been explored but the frontiers are %d and %d", p.ForwardFrontier, p.BackwardFrontier)
+	}
+	if first := p.ForwardApproaches[0]; first != (Approach{Title: "hub", Links: 2, Hops: 2}) {
+		t.Errorf("hub should be the closest forward approach but got %+v", first)
+	}
+	if first := p.BackwardApproaches[0]; first != (Approach{Title: "x", Links: 1, Hops: 1}) {
+		t.Errorf("x should be the closest backward approach but got %+v", first)
+	}
+	if len(p.BestGuess) != 5 || p.BestGuess[0] != "start" || p.BestGuess[2] != "hub" || p.BestGuess[3] != "x" || p.BestGuess[4] != "end" {
+		t.Errorf("unexpected best guess %v", p.BestGuess)
+	}
+}
+
+func TestExploreFromCursor(t *testing.T) {
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> a | b | c\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewUnstartedServer(g)
+	wiki.MaxLimit = 1
+	wiki.Start()
+	defer wiki.Close()
+	config := DefaultConfig()
+	config.APIURL = wiki.APIURL()
+	config.ExploreAllLinks = true
+
+	r := newDefaultRacer("start", "end", config)
+	r.pathFromStartMap.put("start", "")
+	r.forwardCursors.set("start", map[string]string{"continue": "||", "plcontinue": "start|2"})
+	if err := r.explore("start", forwardType); err != nil {
+		t.Fatal(err)
+	}
+	for page, expected := range map[string]bool{"a": false, "b": false, "c": true} {
+		if _, ok := r.pathFromStartMap.get(page); ok != expected {
+			t.Errorf("%s should be reached: %v", page, expected)
+		}
+	}
+	if _, ok := r.forwardCursors.get("start"); ok {
+		t.Errorf("the cursor of an explored page should be removed")
+	}
+}
+
+func TestSnapshotAndResume(t *testing.T) {
+	g, err := fakewiki.ParseGraph(strings.NewReader(`
+		start -> p1
+		p1 -> p2
+		p2 -> p3
+		p3 -> p4
+		p4 -> p5
+		p5 -> p6
+		p6 -> end
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewUnstartedServer(g)
+	// slow enough that each side only gets a couple of pages into the chain
+	wiki.Latency = 100 * time.Millisecond
+	wiki.Start()
+	defer wiki.Close()
+	config := DefaultConfig()
+	config.APIURL = wiki.APIURL()
+	config.TimeLimit = 150 * time.Millisecond
+
+	r := NewRacer("start", "end", config)
+	if path, err := r.Run(); path != nil || err != nil {
+		t.Fatalf("the race should time out but got %v and %v", path, err)
+	}
+	snapshot := r.(Snapshotter).Snapshot()
+	if len(snapshot.ForwardPending) == 0 || len(snapshot.BackwardPending) == 0 {
+		t.Fatalf("both sides should have pages left to explore, got %+v", snapshot)
+	}
+
+	// snapshots move between servers as JSON
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Snapshot
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snapshot, &decoded) {
+		t.Errorf("the snapshot changed when encoded: %+v became %+v", snapshot, decoded)
+	}
+
+	requests := wiki.Requests()
+	config.TimeLimit = 10 * time.Second
+	resumed, err := Resume(&decoded, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := resumed.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"start", "p1", "p2", "p3", "p4", "p5", "p6", "end"}
+	if !reflect.DeepEqual(path, expected) {
+		t.Errorf("the resumed race found %v instead of %v", path, expected)
+	}
+	// the pages explored before the snapshot aren't requested again
+	if again := wiki.Requests() - requests; again > 6 {
+		t.Errorf("the resumed race made %d requests, which is more than the pages left", again)
+	}
+
+	decoded.Version = 0
+	if _, err := Resume(&decoded, config); AsError(err).Kind != InvalidInput {
+		t.Errorf("snapshots of other versions should be invalid, got %v", err)
+	}
+}
+
+func TestResumeInvalidSnapshots(t *testing.T) {
+	snapshots := map[string]*Snapshot{
+		"cyclic": {
+			PathFromStart: map[string]string{"start": "", "A": "B", "B": "A"},
+		},
+		"broken": {
+			PathFromStart: map[string]string{"start": "", "A": "nowhere"},
+		},
+		"no root": {
+			PathFromStart: map[string]string{"start": "A", "A": "start"},
+		},
+		"unreached pending page": {
+			PathFromStart:  map[string]string{"start": "", "A": "start"},
+			ForwardPending: []Cursor{{Title: "B"}},
+		},
+	}
+	for name, snapshot := range snapshots {
+		snapshot.Version = snapshotVersion
+		snapshot.StartTitle = "start"
+		snapshot.EndTitle = "end"
+		snapshot.PathFromEnd = map[string]string{"end": ""}
+		if _, err := Resume(snapshot, DefaultConfig()); AsError(err).Kind != InvalidInput {
+			t.Errorf("the %s snapshot should be invalid, got %v", name, err)
+		}
+	}
+}
+
+// graphLinks is a LinkSource which reads g instead of querying an API.
+func graphLinks(g *fakewiki.Graph) LinkSource {
+	return func(task Task, config Config) (Result, error) {
+		result := Result{Task: task, Missing: !g.Exists(task.Title)}
+		result.Neighbors = g.Links(task.Title)
+		if task.Backward {
+			result.Neighbors = g.LinksHere(task.Title)
+		}
+		return result, nil
+	}
+}
+
+func TestDistributedRace(t *testing.T) {
+	g := stressGraph(300, 4)
+	hub := NewHub()
+	ctx, stopNodes := context.WithCancel(context.Background())
+	var nodes sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		nodes.Add(1)
+		go func() {
+			defer nodes.Done()
+			RunNode(ctx, hub, graphLinks(g), 4)
+		}()
+	}
+	defer func() {
+		stopNodes()
+		nodes.Wait()
+	}()
+
+	config := DefaultConfig()
+	config.TimeLimit = 30 * time.Second
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(start string, end string) {
+			defer wg.Done()
+			path, err := hub.NewRacer(start, end, config).Run()
+			if err != nil {
+				t.Errorf("%s -> %s: %+v", start, end, err)
+				return
+			}
+			if len(path) == 0 || path[0] != start || path[len(path)-1] != end {
+				t.Errorf("%s -> %s: path %v has the wrong ends", start, end, path)
+				return
+			}
+			for j := 1; j < len(path); j++ {
+				if !contains(g.Links(path[j-1]), path[j]) {
+					t.Errorf("%s -> %s: path %v has no link from %s to %s", start, end, path, path[j-1], path[j])
+				}
+			}
+		}(fmt.Sprintf("Page %d", i), fmt.Sprintf("Page %d", 299-i))
+	}
+	wg.Wait()
+	if n := hub.Races(); n != 0 {
+		t.Errorf("finished races should leave the hub, but %d are left", n)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	defer func(d time.Duration) { leaseTimeout = d }(leaseTimeout)
+	leaseTimeout = 50 * time.Millisecond
+
+	hub := NewHub()
+	config := DefaultConfig()
+	config.TimeLimit = time.Minute
+	r := hub.NewRacer("start", "end", config)
+	result := make(chan []string)
+	go func() {
+		path, err := r.Run()
+		if err != nil {
+			t.Error(err)
+		}
+		result <- path
+	}()
+	for hub.Races() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a node leases both pages and never reports
+	expected := []Task{{Title: "start"}, {Title: "end", Backward: true}}
+	lost, _ := hub.Lease(10)
+	if !reflect.DeepEqual(lost.Tasks, expected) {
+		t.Fatalf("expected to lease %v, got %v", expected, lost.Tasks)
+	}
+	if a, _ := hub.Lease(10); a.Race != "" {
+		t.Fatalf("leased pages should not be leased again right away, got %v", a.Tasks)
+	}
+
+	time.Sleep(2 * leaseTimeout)
+	again, _ := hub.Lease(10)
+	sort.Slice(again.Tasks, func(i, j int) bool { return again.Tasks[i].Title > again.Tasks[j].Title })
+	if again.Race != lost.Race || !reflect.DeepEqual(again.Tasks, expected) {
+		t.Fatalf("expired leases should be leased again, got %v", again.Tasks)
+	}
+	hub.Report(again.Race, []Result{
+		{Task: Task{Title: "start"}, Neighbors: []string{"middle"}},
+		{Task: Task{Title: "end", Backward: true}, Neighbors: []string{"middle"}},
+	})
+	if path := <-result; !reflect.DeepEqual(path, []string{"start", "middle", "end"}) {
+		t.Errorf("expected the path through middle, got %v", path)
+	}
+	// the first node reports too late
+	hub.Report(lost.Race, []Result{{Task: Task{Title: "start"}}})
+}
+
+func TestDistributedMissingPage(t *testing.T) {
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> middle\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub()
+	ctx, stopNode := context.WithCancel(context.Background())
+	defer stopNode()
+	go RunNode(ctx, hub, graphLinks(g), 2)
+
+	config := DefaultConfig()
+	config.TimeLimit = 30 * time.Second
+	_, err = hub.NewRacer("start", "nowhere", config).Run()
+	if e := AsError(err); e.Kind != PageNotFound {
+		t.Errorf("expected a page_not_found error, got %+v", err)
+	}
+}
+
+func TestMissingTitlesAndSuggest(t *testing.T) {
+	g, err := fakewiki.ParseGraph(strings.NewReader("Kevin Bacon -> Footloose\nFootloose -> Philosophy\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+	config := DefaultConfig()
+	config.APIURL = wiki.APIURL()
+
+	cases := []struct {
+		start, end string
+		missing    []string
+	}{
+		{"Kevin Bacon", "Philosophy", nil},
+		{"Kevin Bacn", "Philosophy", []string{"Kevin Bacn"}},
+		{"Kevin Bacn", "Philosophie", []string{"Kevin Bacn", "Philosophie"}},
+	}
+	for _, c := range cases {
+		missing, err := newDefaultRacer(c.start, c.end, config).MissingTitles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(missing, c.missing) {
+			t.Errorf("MissingTitles for %s and %s returned %q instead of %q", c.start, c.end, missing, c.missing)
+		}
+	}
+
+	suggestions, err := Suggest("Kevin Bacn", 5, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(suggestions, []string{"Kevin Bacon"}) {
+		t.Errorf("unexpected suggestions %q", suggestions)
+	}
+}
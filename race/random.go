@@ -0,0 +1,103 @@
+package race
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"strconv"
+
+	"github.com/buger/jsonparser"
+	"github.com/pkg/errors"
+)
+
+// RandomPages returns n random pages, which are not redirects, from the first
+// namespace in config.Namespaces (or articles if there is none) using
+// list=random.
+func RandomPages(n int, config Config) ([]string, error) {
+	c := newAPIClient(context.Background(), config)
+	q := url.Values{}
+	q.Set("list", "random")
+	q.Set("rnlimit", strconv.Itoa(n))
+	q.Set("rnnamespace", strconv.Itoa(c.listNamespace()))
+	q.Set("rnfilterredir", "nonredirects")
+
+	pages, err := c.listQuery(q, "random")
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) < n {
+		return nil, errors.Errorf("asked for %d random pages but got %d", n, len(pages))
+	}
+	return pages[:n], nil
+}
+
+// SeededPage picks a page which only depends on seed and the contents of the
+// wiki: the first page, in alphabetical order, after a prefix generated from
+// seed. Like RandomPages, redirects are skipped.
+func SeededPage(seed int64, config Config) (string, error) {
+	c := newAPIClient(context.Background(), config)
+	letters := "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	random := rand.New(rand.NewSource(seed))
+	prefix := []byte{letters[random.Intn(len(letters))]}
+	for i := 0; i < 2; i++ {
+		prefix = append(prefix, letters[random.Intn(len(letters))]+'a'-'A')
+	}
+
+	q := url.Values{}
+	q.Set("list", "allpages")
+	q.Set("aplimit", "1")
+	q.Set("apnamespace", strconv.Itoa(c.listNamespace()))
+	q.Set("apfilterredir", "nonredirects")
+	q.Set("apfrom", string(prefix))
+
+	pages, err := c.listQuery(q, "allpages")
+	if err != nil {
+		return "", err
+	}
+	if len(pages) == 0 {
+		// the prefix is after the last page, so wrap around to the first one
+		q.Del("apfrom")
+		if pages, err = c.listQuery(q, "allpages"); err != nil {
+			return "", err
+		}
+	}
+	if len(pages) == 0 {
+		return "", errors.New("the wiki has no pages")
+	}
+	return pages[0], nil
+}
+
+// listNamespace returns the namespace which lists of pages are taken from.
+func (c *apiClient) listNamespace() int {
+	if len(c.config.Namespaces) > 0 {
+		return c.config.Namespaces[0]
+	}
+	return 0
+}
+
+// listQuery runs a single list query with the parameters in q and returns the
+// titles listed under key.
+func (c *apiClient) listQuery(q url.Values, key string) ([]string, error) {
+	q.Set("action", "query")
+	q.Set("format", "json")
+	q.Set("formatversion", "2")
+	bodyBytes, err := c.get(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var titles []string
+	_, err = jsonparser.ArrayEach(bodyBytes, func(page []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if title, err := jsonparser.GetString(page, "title"); err == nil {
+			titles = append(titles, title)
+		}
+	}, "query", key)
+	if err != nil {
+		// an empty list is left out of the response
+		if _, dataType, _, _ := jsonparser.Get(bodyBytes, "query"); dataType == jsonparser.Object {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, string(bodyBytes))
+	}
+	return titles, nil
+}
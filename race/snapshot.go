@@ -0,0 +1,253 @@
+package race
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// the version of the snapshot format, which is bumped whenever a change would
+// keep older snapshots from resuming correctly
+const snapshotVersion = 1
+
+// A Snapshotter can save the state of a race which has finished running so
+// that it can be resumed later, possibly by another server.
+type Snapshotter interface {
+	Snapshot() *Snapshot
+}
+
+// A Cursor is a page which was reached but not fully explored.
+type Cursor struct {
+	Title string `json:"title"`
+	// the query parameters which continue the query for the links of the
+	// page, e.g. plcontinue; empty if the query starts over
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// A Snapshot is the search state of a race. It is plain data, so it can be
+// stored as JSON and resumed by any server.
+type Snapshot struct {
+	Version    int    `json:"version"`
+	StartTitle string `json:"starttitle"`
+	EndTitle   string `json:"endtitle"`
+	Config     Config `json:"config"`
+	// map every page reached from each side to the page it was reached from;
+	// the start and end pages map to ""
+	PathFromStart map[string]string `json:"path_from_start"`
+	PathFromEnd   map[string]string `json:"path_from_end"`
+	// the kind of edge by which every page was reached, unless the race only
+	// follows links
+	KindsFromStart map[string]string `json:"kinds_from_start,omitempty"`
+	KindsFromEnd   map[string]string `json:"kinds_from_end,omitempty"`
+	// the number of explored pages linked to or from every page reached
+	LinksFromStart map[string]int `json:"links_from_start"`
+	LinksFromEnd   map[string]int `json:"links_from_end"`
+	// the pages left to explore from each side
+	ForwardPending  []Cursor `json:"forward_pending"`
+	BackwardPending []Cursor `json:"backward_pending"`
+}
+
+// cursorMap is a thread-safe map from the pages being explored to the
+// parameters which continue the queries for their links
+type cursorMap struct {
+	sync.Mutex
+	m map[string]map[string]string
+}
+
+// get(title) returns the parameters stored for title
+func (c *cursorMap) get(title string) (map[string]string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	params, ok := c.m[title]
+	return params, ok
+}
+
+// set(title, params) stores params for title
+func (c *cursorMap) set(title string, params map[string]string) {
+	c.Lock()
+	c.m[title] = params
+	c.Unlock()
+}
+
+// remove(title) removes title once it has been explored
+func (c *cursorMap) remove(title string) {
+	c.Lock()
+	delete(c.m, title)
+	c.Unlock()
+}
+
+// cursors returns the cursors of the side of the race wType explores.
+func (r *defaultRacer) cursors(wType workerType) *cursorMap {
+	if wType == backwardType {
+		return &r.backwardCursors
+	}
+	return &r.forwardCursors
+}
+
+// cursorParams returns the values of keys which are set in q.
+func cursorParams(q url.Values, keys ...string) map[string]string {
+	params := make(map[string]string)
+	for _, k := range keys {
+		if v := q.Get(k); v != "" {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+// Snapshot returns the search state of the race. It must only be called after
+// Run has returned.
+func (r *defaultRacer) Snapshot() *Snapshot {
+	s := &Snapshot{
+		Version:         snapshotVersion,
+		StartTitle:      r.startTitle,
+		EndTitle:        r.endTitle,
+		Config:          r.config,
+		PathFromStart:   copyMap(&r.pathFromStartMap),
+		PathFromEnd:     copyMap(&r.pathFromEndMap),
+		LinksFromStart:  copyCounts(&r.linksFromStart),
+		LinksFromEnd:    copyCounts(&r.linksFromEnd),
+		ForwardPending:  pending(r.forwardLinks, &r.forwardCursors),
+		BackwardPending: pending(r.backwardLinks, &r.backwardCursors),
+	}
+	if !r.config.LinksOnly() {
+		s.KindsFromStart = copyMap(&r.kindsFromStartMap)
+		s.KindsFromEnd = copyMap(&r.kindsFromEndMap)
+	}
+	return s
+}
+
+// pending returns the pages which were being explored when the race ended,
+// followed by the pages in frontier. frontier is left as it was.
+func pending(frontier chan string, cursors *cursorMap) []Cursor {
+	cursors.Lock()
+	defer cursors.Unlock()
+	pages := []Cursor{}
+	for title, params := range cursors.m {
+		pages = append(pages, Cursor{Title: title, Params: params})
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Title < pages[j].Title })
+	for i, n := 0, len(frontier); i < n; i++ {
+		title := <-frontier
+		frontier <- title
+		if _, ok := cursors.m[title]; !ok {
+			pages = append(pages, Cursor{Title: title})
+		}
+	}
+	return pages
+}
+
+func copyMap(c *concurrentMap) map[string]string {
+	c.RLock()
+	defer c.RUnlock()
+	m := make(map[string]string, len(c.m))
+	for k, v := range c.m {
+		m[k] = v
+	}
+	return m
+}
+
+func copyCounts(c *concurrentCounter) map[string]int {
+	c.Lock()
+	defer c.Unlock()
+	m := make(map[string]int, len(c.m))
+	for k, v := range c.m {
+		m[k] = v
+	}
+	return m
+}
+
+// validate checks that snapshot can be resumed. Snapshots may come from
+// clients, so every page must lead back to the start or end page without going
+// around in circles, or getPath would never return, and every pending page must
+// have been reached.
+func (snapshot *Snapshot) validate() error {
+	if snapshot.StartTitle == "" || snapshot.EndTitle == "" {
+		return newError(InvalidInput, "the snapshot is incomplete")
+	}
+	if len(snapshot.ForwardPending) > forwardLinksChannelSize || len(snapshot.BackwardPending) > backwardLinksChannelSize {
+		return newError(InvalidInput, "the snapshot has too many pending pages")
+	}
+	if err := validatePathMap(snapshot.PathFromStart, snapshot.StartTitle, snapshot.ForwardPending); err != nil {
+		return err
+	}
+	return validatePathMap(snapshot.PathFromEnd, snapshot.EndTitle, snapshot.BackwardPending)
+}
+
+// validatePathMap checks that following pathMap from every page, and from
+// every pending page, ends at root, which maps to "".
+func validatePathMap(pathMap map[string]string, root string, pending []Cursor) error {
+	if parent, ok := pathMap[root]; !ok || parent != "" {
+		return newError(InvalidInput, "the snapshot is incomplete")
+	}
+	// pages known to lead to root
+	valid := map[string]bool{root: true}
+	for page := range pathMap {
+		// pages on the way from page, which must not be seen twice
+		seen := map[string]bool{}
+		current := page
+		for !valid[current] {
+			parent, ok := pathMap[current]
+			if !ok || parent == "" {
+				return newError(InvalidInput, "the path from %s in the snapshot doesn't lead to %s", page, root)
+			} else if seen[current] {
+				return newError(InvalidInput, "the path from %s in the snapshot goes around in circles", page)
+			}
+			seen[current] = true
+			current = parent
+		}
+		for p := range seen {
+			valid[p] = true
+		}
+	}
+	for _, c := range pending {
+		if !valid[c.Title] {
+			return newError(InvalidInput, "the pending page %s in the snapshot was never reached", c.Title)
+		}
+	}
+	return nil
+}
+
+// Resume returns a Racer which continues the race saved in snapshot with
+// config, which is usually snapshot.Config with a new time limit.
+func Resume(snapshot *Snapshot, config Config) (Racer, error) {
+	if snapshot.Version != snapshotVersion {
+		return nil, newError(InvalidInput, "snapshots of version %d can't be resumed, only version %d", snapshot.Version, snapshotVersion)
+	}
+	if err := snapshot.validate(); err != nil {
+		return nil, err
+	}
+	r := newDefaultRacer(snapshot.StartTitle, snapshot.EndTitle, config)
+	r.resumed = true
+	for k, v := range snapshot.PathFromStart {
+		r.pathFromStartMap.m[k] = v
+	}
+	for k, v := range snapshot.PathFromEnd {
+		r.pathFromEndMap.m[k] = v
+	}
+	for k, v := range snapshot.KindsFromStart {
+		r.kindsFromStartMap.m[k] = v
+	}
+	for k, v := range snapshot.KindsFromEnd {
+		r.kindsFromEndMap.m[k] = v
+	}
+	for k, v := range snapshot.LinksFromStart {
+		r.linksFromStart.m[k] = v
+	}
+	for k, v := range snapshot.LinksFromEnd {
+		r.linksFromEnd.m[k] = v
+	}
+	for _, c := range snapshot.ForwardPending {
+		if len(c.Params) > 0 {
+			r.forwardCursors.m[c.Title] = c.Params
+		}
+		r.forwardLinks <- c.Title
+	}
+	for _, c := range snapshot.BackwardPending {
+		if len(c.Params) > 0 {
+			r.backwardCursors.m[c.Title] = c.Params
+		}
+		r.backwardLinks <- c.Title
+	}
+	return r, nil
+}
@@ -0,0 +1,95 @@
+package race
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/buger/jsonparser"
+	"github.com/pkg/errors"
+)
+
+// A Resolver can check that the start and end pages of its race exist before
+// the race starts, and suggest pages which do if they don't.
+type Resolver interface {
+	// MissingTitles returns the start and end pages which don't exist.
+	MissingTitles() ([]string, error)
+	// Suggest returns up to n titles close to title.
+	Suggest(title string, n int) ([]string, error)
+}
+
+// Suggest returns up to n titles of pages in config.Namespaces close to query,
+// using list=prefixsearch with the fuzzy profile so that typos are forgiven.
+func Suggest(query string, n int, config Config) ([]string, error) {
+	return newAPIClient(context.Background(), config).Suggest(query, n)
+}
+
+// Suggest returns up to n titles close to title.
+func (c *apiClient) Suggest(title string, n int) ([]string, error) {
+	q := url.Values{}
+	q.Set("list", "prefixsearch")
+	q.Set("pssearch", title)
+	q.Set("pslimit", strconv.Itoa(n))
+	q.Set("psprofile", "fuzzy")
+	namespaces := "*"
+	if len(c.config.Namespaces) > 0 {
+		namespaces = namespaceParam(c.config.Namespaces)
+	}
+	q.Set("psnamespace", namespaces)
+	return c.listQuery(q, "prefixsearch")
+}
+
+// MissingTitles returns the start and end pages which don't exist, with one
+// query. Titles are normalized, and a title which redirects to a page exists,
+// so redirects are followed for this check even though the race doesn't follow
+// them. A resumed race reached its pages before, so they aren't checked.
+func (r *defaultRacer) MissingTitles() ([]string, error) {
+	if r.resumed {
+		return nil, nil
+	}
+	q := url.Values{}
+	q.Set("action", "query")
+	q.Set("titles", r.startTitle+"|"+r.endTitle)
+	q.Set("redirects", "1")
+	bodyBytes, err := r.query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// the title each title was normalized or redirected to
+	renamed := make(map[string]string)
+	for _, key := range []string{"normalized", "redirects"} {
+		jsonparser.ArrayEach(bodyBytes, func(rename []byte, dataType jsonparser.ValueType, offset int, err error) {
+			from, _ := jsonparser.GetString(rename, "from")
+			to, _ := jsonparser.GetString(rename, "to")
+			renamed[from] = to
+		}, "query", key)
+	}
+	missing := make(map[string]bool)
+	_, err = jsonparser.ArrayEach(bodyBytes, func(page []byte, dataType jsonparser.ValueType, offset int, err error) {
+		title, _ := jsonparser.GetString(page, "title")
+		// the error here would just imply a missing key, it can be ignored
+		isMissing, _ := jsonparser.GetBoolean(page, "missing")
+		invalid, _ := jsonparser.GetBoolean(page, "invalid")
+		if isMissing || invalid {
+			missing[title] = true
+		}
+	}, "query", "pages")
+	if err != nil {
+		return nil, errors.Wrap(err, string(bodyBytes))
+	}
+
+	var titles []string
+	for _, title := range []string{r.startTitle, r.endTitle} {
+		resolved := title
+		for i := 0; i < 2; i++ {
+			if to, ok := renamed[resolved]; ok {
+				resolved = to
+			}
+		}
+		if missing[resolved] || missing[title] {
+			titles = append(titles, title)
+		}
+	}
+	return titles, nil
+}
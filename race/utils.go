@@ -1,6 +1,10 @@
 package race
 
-import "sync"
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
 
 // lockerString is a thread-safe string wrapper
 type lockerString struct {
@@ -15,6 +19,19 @@ func (l *lockerString) set(k string) {
 	l.Unlock()
 }
 
+// lockedRand is a thread-safe *rand.Rand
+type lockedRand struct {
+	sync.Mutex
+	r *rand.Rand
+}
+
+// intn(n) returns a random int in [0, n)
+func (l *lockedRand) intn(n int) int {
+	l.Lock()
+	defer l.Unlock()
+	return l.r.Intn(n)
+}
+
 // concurrentMap is a thread-safe map[string]string
 type concurrentMap struct {
 	sync.RWMutex
@@ -28,6 +45,18 @@ func (c *concurrentMap) put(k string, v string) {
 	c.Unlock()
 }
 
+// putIfAbsent(k,v) maps k to v unless k is already in the map. It returns
+// true if it did.
+func (c *concurrentMap) putIfAbsent(k string, v string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.m[k]; ok {
+		return false
+	}
+	c.m[k] = v
+	return true
+}
+
 // get(k) returns the value of k in the map
 func (c *concurrentMap) get(k string) (string, bool) {
 	c.RLock()
@@ -36,6 +65,47 @@ func (c *concurrentMap) get(k string) (string, bool) {
 	return v, ok
 }
 
+// concurrentCounter is a thread-safe count of strings
+type concurrentCounter struct {
+	sync.Mutex
+	m map[string]int
+}
+
+// inc(k) adds one to the count of k
+func (c *concurrentCounter) inc(k string) {
+	c.Lock()
+	c.m[k]++
+	c.Unlock()
+}
+
+// top(n) returns the n strings with the highest counts, highest first. Ties
+// are broken alphabetically.
+func (c *concurrentCounter) top(n int) []string {
+	c.Lock()
+	keys := make([]string, 0, len(c.m))
+	for k := range c.m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if c.m[keys[i]] != c.m[keys[j]] {
+			return c.m[keys[i]] > c.m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	c.Unlock()
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// get(k) returns the count of k
+func (c *concurrentCounter) get(k string) int {
+	c.Lock()
+	defer c.Unlock()
+	return c.m[k]
+}
+
 // getPath uses a mapping from nodes to other nodes to compute a path from start
 func getPath(start string, pathMap *concurrentMap) []string {
 	currentNode := start
@@ -0,0 +1,77 @@
+package race
+
+import (
+	"context"
+
+	"github.com/buger/jsonparser"
+	"github.com/pkg/errors"
+)
+
+// A Progresser reports how far a race has got while it is running.
+type Progresser interface {
+	Progress() Progress
+}
+
+// Progress describes how much of the graph a race has explored so far.
+type Progress struct {
+	// pages reached exploring forward from the start page
+	ForwardPages int
+	// pages reached exploring backward from the end page
+	BackwardPages int
+}
+
+// Progress returns the number of pages reached from each side of the race.
+func (r *defaultRacer) Progress() Progress {
+	r.pathFromStartMap.RLock()
+	forwardPages := len(r.pathFromStartMap.m)
+	r.pathFromStartMap.RUnlock()
+	r.pathFromEndMap.RLock()
+	backwardPages := len(r.pathFromEndMap.m)
+	r.pathFromEndMap.RUnlock()
+	return Progress{ForwardPages: forwardPages, BackwardPages: backwardPages}
+}
+
+// VerifyPath checks that every page in path links to the next one. It returns
+// the index of the first page which does not link to the next page, or -1 if
+// the whole path is valid.
+func VerifyPath(path []string, config Config) (int, error) {
+	c := newAPIClient(context.Background(), config)
+	for i := 0; i+1 < len(path); i++ {
+		ok, err := c.linksTo(path[i], path[i+1])
+		if err != nil {
+			return i, err
+		}
+		if !ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// LinksTo returns true if the page from links to the page to.
+func LinksTo(from string, to string, config Config) (bool, error) {
+	return newAPIClient(context.Background(), config).linksTo(from, to)
+}
+
+// linksTo returns true if the page from links to the page to.
+func (c *apiClient) linksTo(from string, to string) (bool, error) {
+	q := c.linksParams(from, forwardType)
+	q.Set("pltitles", to)
+	bodyBytes, err := c.get(q)
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	_, err = jsonparser.ArrayEach(bodyBytes, func(page []byte, dataType jsonparser.ValueType, offset int, err error) {
+		jsonparser.ArrayEach(page, func(link []byte, dataType jsonparser.ValueType, offset int, err error) {
+			if title, _ := jsonparser.GetString(link, "title"); title == to {
+				found = true
+			}
+		}, "links")
+	}, "query", "pages")
+	if err != nil {
+		return false, errors.Wrap(err, string(bodyBytes))
+	}
+	return found, nil
+}
@@ -1,12 +1,11 @@
 package race
 
 import (
-	"io/ioutil"
-	"math/rand"
-	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -22,74 +21,96 @@ const (
 	backwardType
 )
 
-type configuration struct {
-	numForwardLinksRoutines  int
-	numBackwardLinksRoutines int
-	exploreAllLinks          bool
-	exploreOnlyArticles      bool
-}
+// defaultConfig is the configuration used by races which don't override it.
+var defaultConfig Config
 
-// Config represents the configuration for this wikiracer.
-var config configuration
+// the number of times a request answered with 429 Too Many Requests is retried
+var maxRateLimitRetries int
 
 func init() {
-	config = configuration{
-		numForwardLinksRoutines:  15,
-		numBackwardLinksRoutines: 15,
-		exploreAllLinks:          false,
-		exploreOnlyArticles:      true,
+	defaultConfig = Config{
+		TimeLimit:                1 * time.Minute,
+		NumForwardLinksRoutines:  15,
+		NumBackwardLinksRoutines: 15,
+		ExploreAllLinks:          false,
+		Namespaces:               []int{0},
+		APIURL:                   "https://en.wikipedia.org/w/api.php",
 	}
 
 	var err error
+	if apiURL, ok := os.LookupEnv("WIKIRACER_API_URL"); ok {
+		defaultConfig.APIURL = apiURL
+	}
+	if timeLimit, ok := os.LookupEnv("WIKIRACER_TIME_LIMIT"); ok {
+		defaultConfig.TimeLimit, err = time.ParseDuration(timeLimit)
+	}
 	if numForwardLinksRoutines, ok := os.LookupEnv("NUM_FORWARD_LINKS_ROUTINES"); ok {
-		config.numForwardLinksRoutines, err = strconv.Atoi(numForwardLinksRoutines)
+		defaultConfig.NumForwardLinksRoutines, err = strconv.Atoi(numForwardLinksRoutines)
 	}
 	if numBackwardLinksRoutines, ok := os.LookupEnv("NUM_BACKWARD_LINKS_ROUTINES"); ok {
-		config.numBackwardLinksRoutines, err = strconv.Atoi(numBackwardLinksRoutines)
+		defaultConfig.NumBackwardLinksRoutines, err = strconv.Atoi(numBackwardLinksRoutines)
 	}
 	if exploreAllLinks, ok := os.LookupEnv("EXPLORE_ALL_LINKS"); ok {
-		config.exploreAllLinks, err = strconv.ParseBool(exploreAllLinks)
+		defaultConfig.ExploreAllLinks, err = strconv.ParseBool(exploreAllLinks)
+	}
+	maxRateLimitRetries = 100
+	if retries, ok := os.LookupEnv("WIKIRACER_MAX_RATE_LIMIT_RETRIES"); ok {
+		maxRateLimitRetries, err = strconv.Atoi(retries)
 	}
-	// TODO: ADD TO README
 	if exploreOnlyArticles, ok := os.LookupEnv("EXPLORE_ONLY_ARTICLES"); ok {
-		config.exploreAllLinks, err = strconv.ParseBool(exploreOnlyArticles)
+		var onlyArticles bool
+		if onlyArticles, err = strconv.ParseBool(exploreOnlyArticles); err == nil && !onlyArticles {
+			defaultConfig.Namespaces = nil
+		}
 	}
 	if err != nil {
 		log.Panic(err)
 	}
 }
 
+// namespaceParam formats namespaces the way the MediaWiki API expects them.
+func namespaceParam(namespaces []int) string {
+	parts := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		parts[i] = strconv.Itoa(ns)
+	}
+	return strings.Join(parts, "|")
+}
+
+// startWorker runs worker in a new goroutine which stopWorkers waits for.
+func (r *defaultRacer) startWorker(worker func()) {
+	r.workers.Add(1)
+	go func() {
+		defer r.workers.Done()
+		worker()
+	}()
+}
+
+// stopWorkers aborts the requests of the workers and waits for them to return,
+// so that no worker outlives the race.
+func (r *defaultRacer) stopWorkers() {
+	r.cancel()
+	r.workers.Wait()
+}
+
 // handleErrInWorker contains common error handling logic for when an error
-// occurs in a worker goroutine
+// occurs in a worker goroutine. Only the first error is kept, and only if
+// nothing else has ended the race yet. Since r.err is written before done is
+// closed, Run can read it once done is closed.
 func (r *defaultRacer) handleErrInWorker(err error) {
+	if r.ctx.Err() != nil {
+		// the race is over and its requests were aborted
+		return
+	}
 	log.Error("err occurred in worker")
 	log.Errorf("%+v", err)
-	r.err = err
 
 	r.closeOnce.Do(func() {
+		r.err = err
 		close(r.done) // kill all goroutines
 	})
 }
 
-// loopUntilResponse makes requests to the MediaWiki API until it does not get
-// code=429 "Too Many Requests"
-func (r *defaultRacer) loopUntilResponse(u *url.URL) (*http.Response, error) {
-	var resp *http.Response
-	for {
-		var err error
-		resp, err = http.Get(u.String())
-		if err != nil {
-			return nil, err
-		}
-		if resp.StatusCode == 429 {
-			time.Sleep(time.Millisecond * 100)
-		} else {
-			break
-		}
-	}
-	return resp, nil
-}
-
 // higherOrderIteratePages returns a function which iterates through a `page`
 // json blob for a worker. The function returned is compliant with the
 // jsonparser.ArrayEach API.
@@ -98,17 +119,8 @@ func (r *defaultRacer) loopUntilResponse(u *url.URL) (*http.Response, error) {
 // and backwardLinks workers is extremely similar (with a few variables
 // swapped.)
 func (r *defaultRacer) higherOrderIteratePages(wType workerType) func([]byte, jsonparser.ValueType, int, error) {
-	var mapFromMyComponent, mapFromOtherComponent *concurrentMap
-	var myChan chan string
-	var linksJSONKey string
-
-	if wType == forwardType {
-		mapFromMyComponent, mapFromOtherComponent = &r.pathFromStartMap, &r.pathFromEndMap
-		myChan = r.forwardLinks
-		linksJSONKey = "links"
-	} else if wType == backwardType {
-		mapFromMyComponent, mapFromOtherComponent = &r.pathFromEndMap, &r.pathFromStartMap
-		myChan = r.backwardLinks
+	linksJSONKey := "links"
+	if wType == backwardType {
 		linksJSONKey = "linkshere"
 	}
 
@@ -123,7 +135,7 @@ func (r *defaultRacer) higherOrderIteratePages(wType workerType) func([]byte, js
 		if missing {
 			// this error should only end the race is it's caused by the user
 			if parentPageTitle == r.startTitle || parentPageTitle == r.endTitle {
-				r.handleErrInWorker(errors.Errorf("the page %s does not exist", parentPageTitle))
+				r.handleErrInWorker(newError(PageNotFound, "the page %s does not exist", parentPageTitle))
 			}
 			return
 		}
@@ -133,22 +145,7 @@ func (r *defaultRacer) higherOrderIteratePages(wType workerType) func([]byte, js
 				r.handleErrInWorker(errors.WithStack(err))
 				return
 			}
-			if _, ok := mapFromOtherComponent.get(childPageTitle); ok {
-				log.Debugf("found answer in worker! intersection at %s", childPageTitle)
-				mapFromMyComponent.put(childPageTitle, parentPageTitle)
-
-				r.meetingPoint.set(childPageTitle)
-
-				r.closeOnce.Do(func() {
-					close(r.done)
-				}) // kill all goroutines
-				return
-			}
-			_, childOk := mapFromMyComponent.get(childPageTitle)
-			if !childOk && childPageTitle != parentPageTitle {
-				mapFromMyComponent.put(childPageTitle, parentPageTitle)
-				myChan <- childPageTitle
-			}
+			r.visit(wType, LinkEdge, parentPageTitle, childPageTitle)
 		}, linksJSONKey)
 		if err != nil {
 			// handle the err unless it's just a missing key
@@ -169,77 +166,10 @@ func (r *defaultRacer) forwardLinksWorker() {
 		case _ = <-r.done:
 			return
 		case linkToGet := <-r.forwardLinks:
-			u, err := url.Parse("https://en.wikipedia.org/w/api.php")
-			if err != nil {
-				r.handleErrInWorker(errors.WithStack(err))
+			if err := r.explore(linkToGet, forwardType); err != nil {
+				r.handleErrInWorker(err)
 				return
 			}
-
-			// the wikimedia API sometimes doesn't return all results in one response.
-			// these variables allow the client to query for more results.
-			moreResults := true
-			continueResult := ""
-			plcontinueResult := ""
-
-			q := u.Query()
-			q.Set("action", "query")
-			q.Set("format", "json")
-			q.Set("prop", "links")
-			q.Set("titles", linkToGet)
-			q.Set("formatversion", "2")
-			q.Set("pllimit", "500")
-			if rand.Intn(2) == 1 { // let's mix things up a little
-				q.Set("pldir", "descending")
-			}
-			if config.exploreOnlyArticles {
-				q.Set("plnamespace", "0")
-			}
-
-			for moreResults {
-				if len(continueResult) > 0 {
-					q.Set("continue", continueResult)
-					q.Set("plcontinue", plcontinueResult)
-				}
-				u.RawQuery = q.Encode()
-
-				resp, err := r.loopUntilResponse(u)
-				if err != nil {
-					r.handleErrInWorker(err)
-					return
-				}
-				bodyBytes, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					r.handleErrInWorker(errors.WithStack(err))
-					return
-				}
-
-				_, err = jsonparser.ArrayEach(bodyBytes,
-					r.higherOrderIteratePages(forwardType), "query", "pages")
-				if err != nil {
-					r.handleErrInWorker(errors.Wrap(err, string(bodyBytes)))
-					return
-				}
-
-				continueBlock, dataType, _, err := jsonparser.Get(bodyBytes, "continue")
-				if err != nil && dataType != jsonparser.NotExist {
-					r.handleErrInWorker(errors.WithStack(err))
-					return
-				}
-				if len(continueBlock) == 0 || !config.exploreAllLinks {
-					moreResults = false
-				} else {
-					continueResult, err = jsonparser.GetString(bodyBytes, "continue", "continue")
-					if err != nil {
-						r.handleErrInWorker(errors.WithStack(err))
-						return
-					}
-					plcontinueResult, err = jsonparser.GetString(bodyBytes, "continue", "plcontinue")
-					if err != nil {
-						r.handleErrInWorker(errors.WithStack(err))
-						return
-					}
-				}
-			}
 		}
 	}
 }
@@ -252,78 +182,163 @@ func (r *defaultRacer) backwardLinksWorker() {
 		case _ = <-r.done:
 			return
 		case linkToGet := <-r.backwardLinks:
-			u, err := url.Parse("https://en.wikipedia.org/w/api.php")
-			if err != nil {
-				r.handleErrInWorker(errors.WithStack(err))
+			if err := r.explore(linkToGet, backwardType); err != nil {
+				r.handleErrInWorker(err)
 				return
 			}
+		}
+	}
+}
 
-			// the wikimedia API sometimes doesn't return all results in one response.
-			// these variables allow the client to query for more results.
-			moreResults := true
-			continueResult := ""
-			lhcontinueResult := ""
-
-			q := u.Query()
-			q.Set("action", "query")
-			q.Set("format", "json")
-			q.Set("prop", "linkshere")
-			q.Set("lhprop", "title")
-			q.Set("titles", linkToGet)
-			q.Set("formatversion", "2")
-			q.Set("lhlimit", "500")
-			if config.exploreOnlyArticles {
-				q.Set("lhnamespace", "0")
+// deterministicWorker replaces the forwardLinks and backwardLinks workers when
+// the race is deterministic. It alternately explores one page forward and one
+// page backward so that the order in which pages are explored only depends on
+// the API responses.
+func (r *defaultRacer) deterministicWorker() {
+	for {
+		select {
+		case _ = <-r.done:
+			return
+		default:
+		}
+
+		explored := false
+		select {
+		case linkToGet := <-r.forwardLinks:
+			explored = true
+			if err := r.explore(linkToGet, forwardType); err != nil {
+				r.handleErrInWorker(err)
+				return
 			}
+		default:
+		}
 
-			for moreResults {
+		select {
+		case _ = <-r.done:
+			return
+		default:
+		}
 
-				if len(continueResult) > 0 {
-					q.Set("continue", continueResult)
-					q.Set("lhcontinue", lhcontinueResult)
-				}
-				u.RawQuery = q.Encode()
+		select {
+		case linkToGet := <-r.backwardLinks:
+			explored = true
+			if err := r.explore(linkToGet, backwardType); err != nil {
+				r.handleErrInWorker(err)
+				return
+			}
+		default:
+		}
 
-				resp, err := r.loopUntilResponse(u)
-				if err != nil {
-					r.handleErrInWorker(err)
-					return
-				}
-				bodyBytes, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					r.handleErrInWorker(errors.WithStack(err))
-					return
-				}
+		if !explored {
+			// both frontiers are empty, so no path can be found
+			_ = <-r.done
+			return
+		}
+	}
+}
 
-				_, err = jsonparser.ArrayEach(bodyBytes,
-					r.higherOrderIteratePages(backwardType), "query", "pages")
-				if err != nil {
-					r.handleErrInWorker(errors.Wrap(err, string(bodyBytes)))
-					return
-				}
+// linksModule returns the prop module which lists the pages linked from a page
+// if wType is forwardType, or the pages which link to it if wType is
+// backwardType, and the prefix of its parameters.
+func linksModule(wType workerType) (string, string) {
+	if wType == backwardType {
+		return "linkshere", "lh"
+	}
+	return "links", "pl"
+}
 
-				continueBlock, dataType, _, err := jsonparser.Get(bodyBytes, "continue")
-				if err != nil && dataType != jsonparser.NotExist {
-					r.handleErrInWorker(errors.WithStack(err))
-					return
-				}
-				if len(continueBlock) == 0 || !config.exploreAllLinks {
-					moreResults = false
-				} else {
-					continueResult, err = jsonparser.GetString(bodyBytes, "continue", "continue")
-					if err != nil {
-						r.handleErrInWorker(errors.WithStack(err))
-						return
-					}
-					lhcontinueResult, err = jsonparser.GetString(bodyBytes, "continue", "lhcontinue")
-					if err != nil {
-						r.handleErrInWorker(errors.WithStack(err))
-						return
-					}
-				}
-			}
+// linksParams returns the query for the links of title in the direction of
+// wType, in the namespaces of the race.
+func (c *apiClient) linksParams(title string, wType workerType) url.Values {
+	prop, prefix := linksModule(wType)
+	q := url.Values{}
+	q.Set("action", "query")
+	q.Set("format", "json")
+	q.Set("prop", prop)
+	q.Set("titles", title)
+	q.Set("formatversion", "2")
+	q.Set(prefix+"limit", "500")
+	if wType == backwardType {
+		q.Set("lhprop", "title")
+	}
+	if len(c.config.Namespaces) > 0 {
+		q.Set(prefix+"namespace", namespaceParam(c.config.Namespaces))
+	}
+	return q
+}
+
+// queryLinks runs the links query q in the direction of wType and passes every
+// response to handle, continuing it like continueQuery.
+func (c *apiClient) queryLinks(q url.Values, wType workerType, all bool,
+	handle func(bodyBytes []byte) error, continued func(q url.Values)) error {
+	_, prefix := linksModule(wType)
+	return c.continueQuery(q, prefix, all, handle, continued)
+}
+
+// continueQuery runs the query q of the module whose parameters start with
+// prefix and passes every response to handle. The wikimedia API sometimes
+// doesn't return all results in one response, so the query is continued while
+// all is set. continued, if not nil, is called with q every time it is.
+func (c *apiClient) continueQuery(q url.Values, prefix string, all bool,
+	handle func(bodyBytes []byte) error, continued func(q url.Values)) error {
+	for {
+		bodyBytes, err := c.get(q)
+		if err != nil {
+			return err
+		}
+		if err := handle(bodyBytes); err != nil {
+			return err
+		}
+
+		continueBlock, dataType, _, err := jsonparser.Get(bodyBytes, "continue")
+		if err != nil && dataType != jsonparser.NotExist {
+			return errors.WithStack(err)
+		}
+		if len(continueBlock) == 0 || !all {
+			return nil
+		}
+		continueResult, err := jsonparser.GetString(bodyBytes, "continue", "continue")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		prefixContinue, err := jsonparser.GetString(bodyBytes, "continue", prefix+"continue")
+		if err != nil {
+			return errors.WithStack(err)
 		}
+		q.Set("continue", continueResult)
+		q.Set(prefix+"continue", prefixContinue)
+		if continued != nil {
+			continued(q)
+		}
+	}
+}
+
+// exploreLinks queries the pages linked from linkToGet if wType is
+// forwardType, or the pages which link to it if wType is backwardType, and
+// adds them to the frontier of that side.
+func (r *defaultRacer) exploreLinks(linkToGet string, wType workerType) error {
+	_, prefix := linksModule(wType)
+	q := r.linksParams(linkToGet, wType)
+	cursors := r.cursors(wType)
+	if cursor, _ := cursors.get(linkToGet); cursor != nil {
+		// continue where a snapshot left off
+		for k, v := range cursor {
+			q.Set(k, v)
+		}
+	} else if wType == forwardType && r.rand.intn(2) == 1 { // let's mix things up a little
+		q.Set("pldir", "descending")
 	}
+
+	iteratePages := r.higherOrderIteratePages(wType)
+	return r.queryLinks(q, wType, r.config.ExploreAllLinks, func(bodyBytes []byte) error {
+		_, err := jsonparser.ArrayEach(bodyBytes, iteratePages, "query", "pages")
+		if err != nil {
+			return errors.Wrap(err, string(bodyBytes))
+		}
+		return nil
+	}, func(q url.Values) {
+		cursors.set(linkToGet, cursorParams(q, prefix+"dir", "continue", prefix+"continue"))
+	})
 }
 
 func (r *defaultRacer) giveUpAfterTime(timer *time.Timer) {
@@ -331,8 +346,92 @@ func (r *defaultRacer) giveUpAfterTime(timer *time.Timer) {
 	case _ = <-r.done:
 		return
 	case _ = <-timer.C:
-		r.closeOnce.Do(func() {
-			close(r.done) // kill all goroutines
-		})
+		r.Stop()
+	}
+}
+
+// neighbors are the pages linked from or to a page.
+type neighbors struct {
+	titles []string
+	// true if the page does not exist
+	missing bool
+}
+
+// fetchNeighbors returns every page linked from title if wType is
+// forwardType, or every page which links to title if wType is backwardType.
+// Unlike the workers, it always follows continuations.
+func (c *apiClient) fetchNeighbors(title string, wType workerType) (neighbors, error) {
+	var result neighbors
+	prop, _ := linksModule(wType)
+	err := c.queryLinks(c.linksParams(title, wType), wType, true, func(bodyBytes []byte) error {
+		_, err := jsonparser.ArrayEach(bodyBytes, func(page []byte, dataType jsonparser.ValueType, offset int, err error) {
+			// the error here would just imply a missing key, it can be ignored
+			if missing, _ := jsonparser.GetBoolean(page, "missing"); missing {
+				result.missing = true
+				return
+			}
+			jsonparser.ArrayEach(page, func(link []byte, dataType jsonparser.ValueType, offset int, err error) {
+				if neighbor, err := jsonparser.GetString(link, "title"); err == nil && neighbor != title {
+					result.titles = append(result.titles, neighbor)
+				}
+			}, prop)
+		}, "query", "pages")
+		if err != nil {
+			return errors.Wrap(err, string(bodyBytes))
+		}
+		return nil
+	}, nil)
+	return result, err
+}
+
+// fetchAllNeighbors calls fetchNeighbors for every page concurrently, using
+// as many goroutines as config has workers of type wType. It stops starting
+// new queries once done is closed, so the result may be incomplete.
+func (c *apiClient) fetchAllNeighbors(pages []string, wType workerType, done <-chan struct{}) (map[string]neighbors, error) {
+	numWorkers := c.config.NumForwardLinksRoutines
+	if wType == backwardType {
+		numWorkers = c.config.NumBackwardLinksRoutines
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	results := make(map[string]neighbors)
+	toFetch := make(chan string)
+	var lock sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range toFetch {
+				result, err := c.fetchNeighbors(page, wType)
+				lock.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				results[page] = result
+				lock.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, page := range pages {
+		lock.Lock()
+		failed := firstErr != nil
+		lock.Unlock()
+		if failed {
+			break
+		}
+		select {
+		case <-done:
+			break feed
+		case toFetch <- page:
+		}
 	}
+	close(toFetch)
+	wg.Wait()
+	return results, firstErr
 }
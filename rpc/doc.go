@@ -0,0 +1,6 @@
+// Package rpc contains the gRPC definition of the WikiRacer service. The
+// service itself is implemented in the web package so that it shares its
+// cache and configuration with the HTTP server.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative wikiracer.proto
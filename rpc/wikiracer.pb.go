@@ -0,0 +1,701 @@
+// The WikiRacer service runs Wikipedia races over gRPC. It shares its cache
+// and configuration with the HTTP server in the web package.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: wikiracer.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// RaceConfig mirrors the parameters of the /race endpoint. Unset fields use
+// the server defaults and values above the server maximums are capped.
+type RaceConfig struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// a duration understood by time.ParseDuration, e.g. "30s"
+	TimeLimit       string `protobuf:"bytes,1,opt,name=time_limit,json=timeLimit,proto3" json:"time_limit,omitempty"`
+	ForwardWorkers  int32  `protobuf:"varint,2,opt,name=forward_workers,json=forwardWorkers,proto3" json:"forward_workers,omitempty"`
+	BackwardWorkers int32  `protobuf:"varint,3,opt,name=backward_workers,json=backwardWorkers,proto3" json:"backward_workers,omitempty"`
+	// the namespaces to explore; empty means the server default
+	Namespaces []int32 `protobuf:"varint,4,rep,packed,name=namespaces,proto3" json:"namespaces,omitempty"`
+	// explore every namespace, overriding namespaces
+	AllNamespaces bool  `protobuf:"varint,5,opt,name=all_namespaces,json=allNamespaces,proto3" json:"all_namespaces,omitempty"`
+	AllLinks      bool  `protobuf:"varint,6,opt,name=all_links,json=allLinks,proto3" json:"all_links,omitempty"`
+	Seed          int64 `protobuf:"varint,7,opt,name=seed,proto3" json:"seed,omitempty"`
+	Deterministic bool  `protobuf:"varint,8,opt,name=deterministic,proto3" json:"deterministic,omitempty"`
+}
+
+func (x *RaceConfig) Reset() {
+	*x = RaceConfig{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wikiracer_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RaceConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RaceConfig) ProtoMessage() {}
+
+func (x *RaceConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_wikiracer_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RaceConfig.ProtoReflect.Descriptor instead.
+func (*RaceConfig) Descriptor() ([]byte, []int) {
+	return file_wikiracer_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RaceConfig) GetTimeLimit() string {
+	if x != nil {
+		return x.TimeLimit
+	}
+	return ""
+}
+
+func (x *RaceConfig) GetForwardWorkers() int32 {
+	if x != nil {
+		return x.ForwardWorkers
+	}
+	return 0
+}
+
+func (x *RaceConfig) GetBackwardWorkers() int32 {
+	if x != nil {
+		return x.BackwardWorkers
+	}
+	return 0
+}
+
+func (x *RaceConfig) GetNamespaces() []int32 {
+	if x != nil {
+		return x.Namespaces
+	}
+	return nil
+}
+
+func (x *RaceConfig) GetAllNamespaces() bool {
+	if x != nil {
+		return x.AllNamespaces
+	}
+	return false
+}
+
+func (x *RaceConfig) GetAllLinks() bool {
+	if x != nil {
+		return x.AllLinks
+	}
+	return false
+}
+
+func (x *RaceConfig) GetSeed() int64 {
+	if x != nil {
+		return x.Seed
+	}
+	return 0
+}
+
+func (x *RaceConfig) GetDeterministic() bool {
+	if x != nil {
+		return x.Deterministic
+	}
+	return false
+}
+
+type RaceRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	StartTitle string `protobuf:"bytes,1,opt,name=start_title,json=startTitle,proto3" json:"start_title,omitempty"`
+	EndTitle   string `protobuf:"bytes,2,opt,name=end_title,json=endTitle,proto3" json:"end_title,omitempty"`
+	// ignore paths cached by previous races
+	NoCache bool        `protobuf:"varint,3,opt,name=no_cache,json=noCache,proto3" json:"no_cache,omitempty"`
+	Config  *RaceConfig `protobuf:"bytes,4,opt,name=config,proto3" json:"config,omitempty"`
+}
+
+func (x *RaceRequest) Reset() {
+	*x = RaceRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wikiracer_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RaceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RaceRequest) ProtoMessage() {}
+
+func (x *RaceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wikiracer_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RaceRequest.ProtoReflect.Descriptor instead.
+func (*RaceRequest) Descriptor() ([]byte, []int) {
+	return file_wikiracer_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RaceRequest) GetStartTitle() string {
+	if x != nil {
+		return x.StartTitle
+	}
+	return ""
+}
+
+func (x *RaceRequest) GetEndTitle() string {
+	if x != nil {
+		return x.EndTitle
+	}
+	return ""
+}
+
+func (x *RaceRequest) GetNoCache() bool {
+	if x != nil {
+		return x.NoCache
+	}
+	return false
+}
+
+func (x *RaceRequest) GetConfig() *RaceConfig {
+	if x != nil {
+		return x.Config
+	}
+	return nil
+}
+
+type RaceResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// empty if no path was found within the time limit
+	Path      []string `protobuf:"bytes,1,rep,name=path,proto3" json:"path,omitempty"`
+	TimeTaken string   `protobuf:"bytes,2,opt,name=time_taken,json=timeTaken,proto3" json:"time_taken,omitempty"`
+	Message   string   `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	// the effective configuration of the race
+	Config *RaceConfig `protobuf:"bytes,4,opt,name=config,proto3" json:"config,omitempty"`
+	// identifies the race if it was run rather than served from the cache
+	Id string `protobuf:"bytes,5,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (x *RaceResponse) Reset() {
+	*x = RaceResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wikiracer_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RaceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RaceResponse) ProtoMessage() {}
+
+func (x *RaceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_wikiracer_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RaceResponse.ProtoReflect.Descriptor instead.
+func (*RaceResponse) Descriptor() ([]byte, []int) {
+	return file_wikiracer_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RaceResponse) GetPath() []string {
+	if x != nil {
+		return x.Path
+	}
+	return nil
+}
+
+func (x *RaceResponse) GetTimeTaken() string {
+	if x != nil {
+		return x.TimeTaken
+	}
+	return ""
+}
+
+func (x *RaceResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *RaceResponse) GetConfig() *RaceConfig {
+	if x != nil {
+		return x.Config
+	}
+	return nil
+}
+
+func (x *RaceResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type RaceProgressUpdate struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// pages reached exploring forward from the start page
+	ForwardPages int64 `protobuf:"varint,1,opt,name=forward_pages,json=forwardPages,proto3" json:"forward_pages,omitempty"`
+	// pages reached exploring backward from the end page
+	BackwardPages int64  `protobuf:"varint,2,opt,name=backward_pages,json=backwardPages,proto3" json:"backward_pages,omitempty"`
+	Elapsed       string `protobuf:"bytes,3,opt,name=elapsed,proto3" json:"elapsed,omitempty"`
+	// set on the last message only
+	Result *RaceResponse `protobuf:"bytes,4,opt,name=result,proto3" json:"result,omitempty"`
+}
+
+func (x *RaceProgressUpdate) Reset() {
+	*x = RaceProgressUpdate{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wikiracer_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RaceProgressUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RaceProgressUpdate) ProtoMessage() {}
+
+func (x *RaceProgressUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_wikiracer_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RaceProgressUpdate.ProtoReflect.Descriptor instead.
+func (*RaceProgressUpdate) Descriptor() ([]byte, []int) {
+	return file_wikiracer_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RaceProgressUpdate) GetForwardPages() int64 {
+	if x != nil {
+		return x.ForwardPages
+	}
+	return 0
+}
+
+func (x *RaceProgressUpdate) GetBackwardPages() int64 {
+	if x != nil {
+		return x.BackwardPages
+	}
+	return 0
+}
+
+func (x *RaceProgressUpdate) GetElapsed() string {
+	if x != nil {
+		return x.Elapsed
+	}
+	return ""
+}
+
+func (x *RaceProgressUpdate) GetResult() *RaceResponse {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+type VerifyPathRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Path []string `protobuf:"bytes,1,rep,name=path,proto3" json:"path,omitempty"`
+	// the namespaces links may point into; empty means every namespace
+	Namespaces []int32 `protobuf:"varint,2,rep,packed,name=namespaces,proto3" json:"namespaces,omitempty"`
+}
+
+func (x *VerifyPathRequest) Reset() {
+	*x = VerifyPathRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wikiracer_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VerifyPathRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyPathRequest) ProtoMessage() {}
+
+func (x *VerifyPathRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wikiracer_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyPathRequest.ProtoReflect.Descriptor instead.
+func (*VerifyPathRequest) Descriptor() ([]byte, []int) {
+	return file_wikiracer_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *VerifyPathRequest) GetPath() []string {
+	if x != nil {
+		return x.Path
+	}
+	return nil
+}
+
+func (x *VerifyPathRequest) GetNamespaces() []int32 {
+	if x != nil {
+		return x.Namespaces
+	}
+	return nil
+}
+
+type VerifyPathResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Valid bool `protobuf:"varint,1,opt,name=valid,proto3" json:"valid,omitempty"`
+	// if the path is invalid, path[broken_hop] does not link to
+	// path[broken_hop + 1]
+	BrokenHop int32  `protobuf:"varint,2,opt,name=broken_hop,json=brokenHop,proto3" json:"broken_hop,omitempty"`
+	Message   string `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (x *VerifyPathResponse) Reset() {
+	*x = VerifyPathResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wikiracer_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VerifyPathResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyPathResponse) ProtoMessage() {}
+
+func (x *VerifyPathResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_wikiracer_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyPathResponse.ProtoReflect.Descriptor instead.
+func (*VerifyPathResponse) Descriptor() ([]byte, []int) {
+	return file_wikiracer_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *VerifyPathResponse) GetValid() bool {
+	if x != nil {
+		return x.Valid
+	}
+	return false
+}
+
+func (x *VerifyPathResponse) GetBrokenHop() int32 {
+	if x != nil {
+		return x.BrokenHop
+	}
+	return 0
+}
+
+func (x *VerifyPathResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+var File_wikiracer_proto protoreflect.FileDescriptor
+
+var file_wikiracer_proto_rawDesc = []byte{
+	0x0a, 0x0f, 0x77, 0x69, 0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x09, 0x77, 0x69, 0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72, 0x22, 0x9d, 0x02, 0x0a,
+	0x0a, 0x52, 0x61, 0x63, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x1d, 0x0a, 0x0a, 0x74,
+	0x69, 0x6d, 0x65, 0x5f, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x09, 0x74, 0x69, 0x6d, 0x65, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x12, 0x27, 0x0a, 0x0f, 0x66, 0x6f,
+	0x72, 0x77, 0x61, 0x72, 0x64, 0x5f, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x73, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x0e, 0x66, 0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x57, 0x6f, 0x72, 0x6b,
+	0x65, 0x72, 0x73, 0x12, 0x29, 0x0a, 0x10, 0x62, 0x61, 0x63, 0x6b, 0x77, 0x61, 0x72, 0x64, 0x5f,
+	0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0f, 0x62,
+	0x61, 0x63, 0x6b, 0x77, 0x61, 0x72, 0x64, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x73, 0x12, 0x1e,
+	0x0a, 0x0a, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x18, 0x04, 0x20, 0x03,
+	0x28, 0x05, 0x52, 0x0a, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x12, 0x25,
+	0x0a, 0x0e, 0x61, 0x6c, 0x6c, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0d, 0x61, 0x6c, 0x6c, 0x4e, 0x61, 0x6d, 0x65, 0x73,
+	0x70, 0x61, 0x63, 0x65, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x61, 0x6c, 0x6c, 0x5f, 0x6c, 0x69, 0x6e,
+	0x6b, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x61, 0x6c, 0x6c, 0x4c, 0x69, 0x6e,
+	0x6b, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x65, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x04, 0x73, 0x65, 0x65, 0x64, 0x12, 0x24, 0x0a, 0x0d, 0x64, 0x65, 0x74, 0x65, 0x72, 0x6d,
+	0x69, 0x6e, 0x69, 0x73, 0x74, 0x69, 0x63, 0x18, 0x08, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0d, 0x64,
+	0x65, 0x74, 0x65, 0x72, 0x6d, 0x69, 0x6e, 0x69, 0x73, 0x74, 0x69, 0x63, 0x22, 0x95, 0x01, 0x0a,
+	0x0b, 0x52, 0x61, 0x63, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1f, 0x0a, 0x0b,
+	0x73, 0x74, 0x61, 0x72, 0x74, 0x5f, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x73, 0x74, 0x61, 0x72, 0x74, 0x54, 0x69, 0x74, 0x6c, 0x65, 0x12, 0x1b, 0x0a,
+	0x09, 0x65, 0x6e, 0x64, 0x5f, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x65, 0x6e, 0x64, 0x54, 0x69, 0x74, 0x6c, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x6e, 0x6f,
+	0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x6e, 0x6f,
+	0x43, 0x61, 0x63, 0x68, 0x65, 0x12, 0x2d, 0x0a, 0x06, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x77, 0x69, 0x6b, 0x69, 0x72, 0x61, 0x63, 0x65,
+	0x72, 0x2e, 0x52, 0x61, 0x63, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x06, 0x63, 0x6f,
+	0x6e, 0x66, 0x69, 0x67, 0x22, 0x9a, 0x01, 0x0a, 0x0c, 0x52, 0x61, 0x63, 0x65, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x09, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x12, 0x1d, 0x0a, 0x0a, 0x74, 0x69, 0x6d,
+	0x65, 0x5f, 0x74, 0x61, 0x6b, 0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x74,
+	0x69, 0x6d, 0x65, 0x54, 0x61, 0x6b, 0x65, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x12, 0x2d, 0x0a, 0x06, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x15, 0x2e, 0x77, 0x69, 0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72, 0x2e, 0x52,
+	0x61, 0x63, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x06, 0x63, 0x6f, 0x6e, 0x66, 0x69,
+	0x67, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69,
+	0x64, 0x22, 0xab, 0x01, 0x0a, 0x12, 0x52, 0x61, 0x63, 0x65, 0x50, 0x72, 0x6f, 0x67, 0x72, 0x65,
+	0x73, 0x73, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x66, 0x6f, 0x72, 0x77,
+	0x61, 0x72, 0x64, 0x5f, 0x70, 0x61, 0x67, 0x65, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0c, 0x66, 0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x50, 0x61, 0x67, 0x65, 0x73, 0x12, 0x25, 0x0a,
+	0x0e, 0x62, 0x61, 0x63, 0x6b, 0x77, 0x61, 0x72, 0x64, 0x5f, 0x70, 0x61, 0x67, 0x65, 0x73, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0d, 0x62, 0x61, 0x63, 0x6b, 0x77, 0x61, 0x72, 0x64, 0x50,
+	0x61, 0x67, 0x65, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x65, 0x6c, 0x61, 0x70, 0x73, 0x65, 0x64, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x65, 0x6c, 0x61, 0x70, 0x73, 0x65, 0x64, 0x12, 0x2f,
+	0x0a, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17,
+	0x2e, 0x77, 0x69, 0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72, 0x2e, 0x52, 0x61, 0x63, 0x65, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x52, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x22,
+	0x47, 0x0a, 0x11, 0x56, 0x65, 0x72, 0x69, 0x66, 0x79, 0x50, 0x61, 0x74, 0x68, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x09, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x12, 0x1e, 0x0a, 0x0a, 0x6e, 0x61, 0x6d, 0x65,
+	0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x05, 0x52, 0x0a, 0x6e, 0x61,
+	0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x22, 0x63, 0x0a, 0x12, 0x56, 0x65, 0x72, 0x69,
+	0x66, 0x79, 0x50, 0x61, 0x74, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14,
+	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x76,
+	0x61, 0x6c, 0x69, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x72, 0x6f, 0x6b, 0x65, 0x6e, 0x5f, 0x68,
+	0x6f, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x62, 0x72, 0x6f, 0x6b, 0x65, 0x6e,
+	0x48, 0x6f, 0x70, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x32, 0xd8, 0x01,
+	0x0a, 0x09, 0x57, 0x69, 0x6b, 0x69, 0x52, 0x61, 0x63, 0x65, 0x72, 0x12, 0x37, 0x0a, 0x04, 0x52,
+	0x61, 0x63, 0x65, 0x12, 0x16, 0x2e, 0x77, 0x69, 0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72, 0x2e,
+	0x52, 0x61, 0x63, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x77, 0x69,
+	0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72, 0x2e, 0x52, 0x61, 0x63, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x47, 0x0a, 0x0c, 0x52, 0x61, 0x63, 0x65, 0x50, 0x72, 0x6f, 0x67,
+	0x72, 0x65, 0x73, 0x73, 0x12, 0x16, 0x2e, 0x77, 0x69, 0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72,
+	0x2e, 0x52, 0x61, 0x63, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1d, 0x2e, 0x77,
+	0x69, 0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72, 0x2e, 0x52, 0x61, 0x63, 0x65, 0x50, 0x72, 0x6f,
+	0x67, 0x72, 0x65, 0x73, 0x73, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x30, 0x01, 0x12, 0x49, 0x0a,
+	0x0a, 0x56, 0x65, 0x72, 0x69, 0x66, 0x79, 0x50, 0x61, 0x74, 0x68, 0x12, 0x1c, 0x2e, 0x77, 0x69,
+	0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72, 0x2e, 0x56, 0x65, 0x72, 0x69, 0x66, 0x79, 0x50, 0x61,
+	0x74, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1d, 0x2e, 0x77, 0x69, 0x6b, 0x69,
+	0x72, 0x61, 0x63, 0x65, 0x72, 0x2e, 0x56, 0x65, 0x72, 0x69, 0x66, 0x79, 0x50, 0x61, 0x74, 0x68,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x25, 0x5a, 0x23, 0x67, 0x69, 0x74, 0x68,
+	0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x73, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0x62, 0x65,
+	0x6e, 0x2f, 0x77, 0x69, 0x6b, 0x69, 0x72, 0x61, 0x63, 0x65, 0x72, 0x2f, 0x72, 0x70, 0x63, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_wikiracer_proto_rawDescOnce sync.Once
+	file_wikiracer_proto_rawDescData = file_wikiracer_proto_rawDesc
+)
+
+func file_wikiracer_proto_rawDescGZIP() []byte {
+	file_wikiracer_proto_rawDescOnce.Do(func() {
+		file_wikiracer_proto_rawDescData = protoimpl.X.CompressGZIP(file_wikiracer_proto_rawDescData)
+	})
+	return file_wikiracer_proto_rawDescData
+}
+
+var file_wikiracer_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_wikiracer_proto_goTypes = []any{
+	(*RaceConfig)(nil),         // 0: wikiracer.RaceConfig
+	(*RaceRequest)(nil),        // 1: wikiracer.RaceRequest
+	(*RaceResponse)(nil),       // 2: wikiracer.RaceResponse
+	(*RaceProgressUpdate)(nil), // 3: wikiracer.RaceProgressUpdate
+	(*VerifyPathRequest)(nil),  // 4: wikiracer.VerifyPathRequest
+	(*VerifyPathResponse)(nil), // 5: wikiracer.VerifyPathResponse
+}
+var file_wikiracer_proto_depIdxs = []int32{
+	0, // 0: wikiracer.RaceRequest.config:type_name -> wikiracer.RaceConfig
+	0, // 1: wikiracer.RaceResponse.config:type_name -> wikiracer.RaceConfig
+	2, // 2: wikiracer.RaceProgressUpdate.result:type_name -> wikiracer.RaceResponse
+	1, // 3: wikiracer.WikiRacer.Race:input_type -> wikiracer.RaceRequest
+	1, // 4: wikiracer.WikiRacer.RaceProgress:input_type -> wikiracer.RaceRequest
+	4, // 5: wikiracer.WikiRacer.VerifyPath:input_type -> wikiracer.VerifyPathRequest
+	2, // 6: wikiracer.WikiRacer.Race:output_type -> wikiracer.RaceResponse
+	3, // 7: wikiracer.WikiRacer.RaceProgress:output_type -> wikiracer.RaceProgressUpdate
+	5, // 8: wikiracer.WikiRacer.VerifyPath:output_type -> wikiracer.VerifyPathResponse
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_wikiracer_proto_init() }
+func file_wikiracer_proto_init() {
+	if File_wikiracer_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_wikiracer_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*RaceConfig); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wikiracer_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*RaceRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wikiracer_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*RaceResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wikiracer_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*RaceProgressUpdate); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wikiracer_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*VerifyPathRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wikiracer_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*VerifyPathResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_wikiracer_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_wikiracer_proto_goTypes,
+		DependencyIndexes: file_wikiracer_proto_depIdxs,
+		MessageInfos:      file_wikiracer_proto_msgTypes,
+	}.Build()
+	File_wikiracer_proto = out.File
+	file_wikiracer_proto_rawDesc = nil
+	file_wikiracer_proto_goTypes = nil
+	file_wikiracer_proto_depIdxs = nil
+}
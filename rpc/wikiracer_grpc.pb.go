@@ -0,0 +1,222 @@
+// The WikiRacer service runs Wikipedia races over gRPC. It shares its cache
+// and configuration with the HTTP server in the web package.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: wikiracer.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	WikiRacer_Race_FullMethodName         = "/wikiracer.WikiRacer/Race"
+	WikiRacer_RaceProgress_FullMethodName = "/wikiracer.WikiRacer/RaceProgress"
+	WikiRacer_VerifyPath_FullMethodName   = "/wikiracer.WikiRacer/VerifyPath"
+)
+
+// WikiRacerClient is the client API for WikiRacer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WikiRacerClient interface {
+	// Race finds a path from start_title to end_title.
+	Race(ctx context.Context, in *RaceRequest, opts ...grpc.CallOption) (*RaceResponse, error)
+	// RaceProgress runs a race and streams how far it has got until it
+	// finishes. The last message contains the result.
+	RaceProgress(ctx context.Context, in *RaceRequest, opts ...grpc.CallOption) (WikiRacer_RaceProgressClient, error)
+	// VerifyPath checks that every page in a path links to the next one.
+	VerifyPath(ctx context.Context, in *VerifyPathRequest, opts ...grpc.CallOption) (*VerifyPathResponse, error)
+}
+
+type wikiRacerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWikiRacerClient(cc grpc.ClientConnInterface) WikiRacerClient {
+	return &wikiRacerClient{cc}
+}
+
+func (c *wikiRacerClient) Race(ctx context.Context, in *RaceRequest, opts ...grpc.CallOption) (*RaceResponse, error) {
+	out := new(RaceResponse)
+	err := c.cc.Invoke(ctx, WikiRacer_Race_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wikiRacerClient) RaceProgress(ctx context.Context, in *RaceRequest, opts ...grpc.CallOption) (WikiRacer_RaceProgressClient, error) {
+	stream, err := c.cc.NewStream(ctx, &WikiRacer_ServiceDesc.Streams[0], WikiRacer_RaceProgress_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &wikiRacerRaceProgressClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WikiRacer_RaceProgressClient interface {
+	Recv() (*RaceProgressUpdate, error)
+	grpc.ClientStream
+}
+
+type wikiRacerRaceProgressClient struct {
+	grpc.ClientStream
+}
+
+func (x *wikiRacerRaceProgressClient) Recv() (*RaceProgressUpdate, error) {
+	m := new(RaceProgressUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *wikiRacerClient) VerifyPath(ctx context.Context, in *VerifyPathRequest, opts ...grpc.CallOption) (*VerifyPathResponse, error) {
+	out := new(VerifyPathResponse)
+	err := c.cc.Invoke(ctx, WikiRacer_VerifyPath_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WikiRacerServer is the server API for WikiRacer service.
+// All implementations must embed UnimplementedWikiRacerServer
+// for forward compatibility
+type WikiRacerServer interface {
+	// Race finds a path from start_title to end_title.
+	Race(context.Context, *RaceRequest) (*RaceResponse, error)
+	// RaceProgress runs a race and streams how far it has got until it
+	// finishes. The last message contains the result.
+	RaceProgress(*RaceRequest, WikiRacer_RaceProgressServer) error
+	// VerifyPath checks that every page in a path links to the next one.
+	VerifyPath(context.Context, *VerifyPathRequest) (*VerifyPathResponse, error)
+	mustEmbedUnimplementedWikiRacerServer()
+}
+
+// UnimplementedWikiRacerServer must be embedded to have forward compatible implementations.
+type UnimplementedWikiRacerServer struct {
+}
+
+func (UnimplementedWikiRacerServer) Race(context.Context, *RaceRequest) (*RaceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Race not implemented")
+}
+func (UnimplementedWikiRacerServer) RaceProgress(*RaceRequest, WikiRacer_RaceProgressServer) error {
+	return status.Errorf(codes.Unimplemented, "method RaceProgress not implemented")
+}
+func (UnimplementedWikiRacerServer) VerifyPath(context.Context, *VerifyPathRequest) (*VerifyPathResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyPath not implemented")
+}
+func (UnimplementedWikiRacerServer) mustEmbedUnimplementedWikiRacerServer() {}
+
+// UnsafeWikiRacerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WikiRacerServer will
+// result in compilation errors.
+type UnsafeWikiRacerServer interface {
+	mustEmbedUnimplementedWikiRacerServer()
+}
+
+func RegisterWikiRacerServer(s grpc.ServiceRegistrar, srv WikiRacerServer) {
+	s.RegisterService(&WikiRacer_ServiceDesc, srv)
+}
+
+func _WikiRacer_Race_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RaceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WikiRacerServer).Race(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WikiRacer_Race_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WikiRacerServer).Race(ctx, req.(*RaceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WikiRacer_RaceProgress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RaceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WikiRacerServer).RaceProgress(m, &wikiRacerRaceProgressServer{stream})
+}
+
+type WikiRacer_RaceProgressServer interface {
+	Send(*RaceProgressUpdate) error
+	grpc.ServerStream
+}
+
+type wikiRacerRaceProgressServer struct {
+	grpc.ServerStream
+}
+
+func (x *wikiRacerRaceProgressServer) Send(m *RaceProgressUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _WikiRacer_VerifyPath_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyPathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WikiRacerServer).VerifyPath(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WikiRacer_VerifyPath_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WikiRacerServer).VerifyPath(ctx, req.(*VerifyPathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// WikiRacer_ServiceDesc is the grpc.ServiceDesc for WikiRacer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var WikiRacer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "wikiracer.WikiRacer",
+	HandlerType: (*WikiRacerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Race",
+			Handler:    _WikiRacer_Race_Handler,
+		},
+		{
+			MethodName: "VerifyPath",
+			Handler:    _WikiRacer_VerifyPath_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RaceProgress",
+			Handler:       _WikiRacer_RaceProgress_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "wikiracer.proto",
+}
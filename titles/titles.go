@@ -0,0 +1,211 @@
+// Package titles is an offline index of page titles, such as the
+// all-titles-in-ns0 dumps of Wikipedia, which suggests titles without
+// querying the MediaWiki API.
+package titles
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// the most titles starting with a query which are compared to find the
+// shortest ones
+const maxPrefixMatches = 10000
+
+// An Index holds page titles sorted by their keys.
+type Index struct {
+	titles []string
+	// the key of every title, in the same order
+	keys []string
+}
+
+// key folds the case and underscores of a title so that lookups forgive them.
+func key(title string) string {
+	return strings.ToLower(strings.Replace(title, "_", " ", -1))
+}
+
+// New returns an index of titles.
+func New(titles []string) *Index {
+	ix := &Index{titles: make([]string, 0, len(titles))}
+	seen := make(map[string]bool, len(titles))
+	for _, title := range titles {
+		title = strings.TrimSpace(strings.Replace(title, "_", " ", -1))
+		if title != "" && !seen[title] {
+			seen[title] = true
+			ix.titles = append(ix.titles, title)
+		}
+	}
+	sort.Slice(ix.titles, func(i, j int) bool {
+		ki, kj := key(ix.titles[i]), key(ix.titles[j])
+		if ki != kj {
+			return ki < kj
+		}
+		return ix.titles[i] < ix.titles[j]
+	})
+	ix.keys = make([]string, len(ix.titles))
+	for i, title := range ix.titles {
+		ix.keys[i] = key(title)
+	}
+	return ix
+}
+
+// Load reads an index from a file with one title per line, in which spaces
+// may be written as underscores like in the dumps. A page_title header line is
+// skipped.
+func Load(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	var titles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "page_title" {
+			titles = append(titles, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", path)
+	}
+	return New(titles), nil
+}
+
+// match is a title suggested for a query.
+type match struct {
+	title string
+	// the number of typos between the query and the title
+	distance int
+}
+
+// Len returns the number of titles in the index.
+func (ix *Index) Len() int {
+	return len(ix.titles)
+}
+
+// Suggest returns up to n titles close to query. Titles which start with the
+// query come first, shortest first, followed by titles within a couple of
+// typos of it. Typos in the first letter aren't forgiven, which keeps the
+// search to a small part of the index.
+func (ix *Index) Suggest(query string, n int) []string {
+	q := key(strings.TrimSpace(query))
+	if q == "" || n <= 0 {
+		return nil
+	}
+
+	var matches []match
+	seen := make(map[string]bool)
+
+	// titles which start with the query are next to each other
+	for i := sort.SearchStrings(ix.keys, q); i < len(ix.keys) && strings.HasPrefix(ix.keys[i], q); i++ {
+		if len(matches) == maxPrefixMatches {
+			break
+		}
+		matches = append(matches, match{title: ix.titles[i], distance: 0})
+		seen[ix.titles[i]] = true
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return len(matches[i].title) < len(matches[j].title)
+	})
+	if len(matches) < n {
+		matches = append(matches, ix.fuzzy(q, seen)...)
+	}
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+	suggestions := make([]string, len(matches))
+	for i, m := range matches {
+		suggestions[i] = m.title
+	}
+	return suggestions
+}
+
+// fuzzy returns the titles within a couple of typos of q, the closest first.
+// Titles in seen are left out.
+func (ix *Index) fuzzy(q string, seen map[string]bool) []match {
+	// the fuzzy matches share the first letter of the query
+	first, size := utf8.DecodeRuneInString(q)
+	start := sort.SearchStrings(ix.keys, q[:size])
+	end := sort.SearchStrings(ix.keys, string(first+1))
+	qLen := utf8.RuneCountInString(q)
+	maxDistance := 2
+	if qLen <= 4 {
+		maxDistance = 1
+	}
+
+	var matches []match
+	for i := start; i < end; i++ {
+		if seen[ix.titles[i]] {
+			continue
+		}
+		// compare the query to the start of the title, so that both
+		// misspelled titles and misspelled prefixes match
+		if d := prefixDistance(q, ix.keys[i], qLen+maxDistance); d <= maxDistance {
+			matches = append(matches, match{title: ix.titles[i], distance: d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return len(matches[i].title) < len(matches[j].title)
+	})
+	return matches
+}
+
+// Distance returns the number of letters which must be inserted, deleted or
+// replaced to turn a into b.
+func Distance(a string, b string) int {
+	row := distances([]rune(a), []rune(b))
+	return row[len(row)-1]
+}
+
+// prefixDistance returns the Distance from q to the closest of the first
+// maxLen letters of title.
+func prefixDistance(q string, title string, maxLen int) int {
+	rb := []rune(title)
+	if len(rb) > maxLen {
+		rb = rb[:maxLen]
+	}
+	row := distances([]rune(q), rb)
+	d := row[0]
+	for _, other := range row[1:] {
+		if other < d {
+			d = other
+		}
+	}
+	return d
+}
+
+// distances returns the Distance from ra to each prefix of rb, shortest first.
+func distances(ra []rune, rb []rune) []int {
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous
+}
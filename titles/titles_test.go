@@ -0,0 +1,81 @@
+package titles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	ix := New([]string{"Kevin_Bacon", "Kevin Bacon (disambiguation)", "Kevin Costner", "Keanu Reeves", "Footloose", "kevin bacon", "Kevin Bacon"})
+	if ix.Len() != 6 {
+		t.Errorf("underscores should be read as spaces and duplicates dropped, got %d titles", ix.Len())
+	}
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"kevin b", []string{"Kevin Bacon", "kevin bacon", "Kevin Bacon (disambiguation)"}},
+		{"Kevin_Bacon", []string{"Kevin Bacon", "kevin bacon", "Kevin Bacon (disambiguation)"}},
+		// a typo in a prefix
+		{"Kevn Bac", []string{"Kevin Bacon", "kevin bacon", "Kevin Bacon (disambiguation)"}},
+		{"Footlose", []string{"Footloose"}},
+		// a letter left out of a short query
+		{"Kvin", []string{"Kevin Bacon", "kevin bacon", "Kevin Costner"}},
+		// typos in the first letter aren't forgiven
+		{"Gootloose", []string{}},
+		{"", nil},
+	}
+	for _, c := range cases {
+		if got := ix.Suggest(c.query, 3); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Suggest(%q) returned %q instead of %q", c.query, got, c.expected)
+		}
+	}
+	if got := ix.Suggest("Ke", 10); len(got) != 5 || got[0] != "Kevin Bacon" || got[4] != "Kevin Bacon (disambiguation)" {
+		t.Errorf("prefix matches should come shortest first, got %q", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "titles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "all-titles-in-ns0")
+	if err := ioutil.WriteFile(path, []byte("page_title\nKevin_Bacon\nPhilosophy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ix.Suggest("phil", 10); !reflect.DeepEqual(got, []string{"Philosophy"}) || ix.Len() != 2 {
+		t.Errorf("unexpected index of %d titles: %q", ix.Len(), got)
+	}
+	if _, err := Load(filepath.Join(dir, "missing")); err == nil {
+		t.Error("loading a missing file should fail")
+	}
+}
+
+func TestDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"bacon", "", 5},
+		{"kevin", "kevn", 1},
+		{"kitten", "sitting", 3},
+		{"café", "cafe", 1},
+	}
+	for _, c := range cases {
+		if d := Distance(c.a, c.b); d != c.distance {
+			t.Errorf("Distance(%q, %q) = %d, want %d", c.a, c.b, d, c.distance)
+		}
+	}
+}
@@ -0,0 +1,597 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/sandlerben/wikiracer/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// keys are the API keys clients must send once LoadKeys has been called; nil
+// means the API is open
+var keys *keyStore
+
+// the key which may manage the API keys at /admin/keys; "" disables it
+var adminKey string
+
+func init() {
+	adminKey = os.Getenv("WIKIRACER_ADMIN_KEY")
+}
+
+// the routes whose requests count as races toward the limits of a key
+var racingRoutes = map[string]bool{
+	"race":            true,
+	"batch":           true,
+	"distance":        true,
+	"neighborhood":    true,
+	"challengeToday":  true,
+	"challengeRandom": true,
+	"challengeDate":   true,
+	"createGame":      true,
+	"resume":          true,
+}
+
+// the gRPC methods whose calls count as races toward the limits of a key
+var racingMethods = map[string]bool{
+	rpc.WikiRacer_Race_FullMethodName:         true,
+	rpc.WikiRacer_RaceProgress_FullMethodName: true,
+}
+
+// keyLimits restrict how much a key may use the API. Zero means no limit.
+type keyLimits struct {
+	// requests of any kind per minute
+	RatePerMinute int `json:"rate_per_minute"`
+	// races running at once
+	MaxConcurrentRaces int `json:"max_concurrent_races"`
+	// races per day, in UTC
+	DailyRaces int `json:"daily_races"`
+}
+
+// apiKey is a client of the API.
+type apiKey struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	// the SHA-256 of the key, so that the file doesn't give the keys away
+	Hash string `json:"hash"`
+	keyLimits
+}
+
+// keyUsage is how much a key has used the API since the server started.
+type keyUsage struct {
+	// the requests the key may make right away, refilled continuously
+	tokens   float64
+	refilled time.Time
+	// the races running
+	running int
+	// the races started on day
+	day   string
+	races int
+}
+
+// quotaError is returned when a request would exceed a limit of its key.
+type quotaError struct {
+	message string
+	// how long until the request would be allowed, if known
+	retryAfter time.Duration
+}
+
+func (e *quotaError) Error() string {
+	return e.message
+}
+
+// keyStore keeps the API keys in a JSON file and tracks their usage in
+// memory.
+type keyStore struct {
+	sync.Mutex
+	path string
+	// keys by hash
+	keys  map[string]*apiKey
+	usage map[string]*keyUsage
+}
+
+// hashKey returns the hash by which key is stored.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadKeys requires an API key for every request except /health, with the keys
+// kept in the JSON file at path. The file is created when the first key is
+// added at /admin/keys. It must be called before the server starts.
+func LoadKeys(path string) error {
+	store := &keyStore{path: path, keys: make(map[string]*apiKey), usage: make(map[string]*keyUsage)}
+	value, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	if err == nil {
+		var list []*apiKey
+		if err := json.Unmarshal(value, &list); err != nil {
+			return errors.Wrapf(err, "could not read the keys in %s", path)
+		}
+		for _, k := range list {
+			store.keys[k.Hash] = k
+		}
+	}
+	keys = store
+	return nil
+}
+
+// save writes every key to the file. s must be locked.
+func (s *keyStore) save() error {
+	list := make([]*apiKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	value, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// write to a temporary file first so that a crash never loses every key
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, value, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp, s.path))
+}
+
+// authenticate returns a copy of the key whose secret is key, or nil.
+func (s *keyStore) authenticate(key string) *apiKey {
+	if key == "" {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	k, ok := s.keys[hashKey(key)]
+	if !ok {
+		return nil
+	}
+	copied := *k
+	return &copied
+}
+
+// usageOf returns the usage of the key with id, resetting the daily count
+// when the day changes. s must be locked.
+func (s *keyStore) usageOf(id string, now time.Time) *keyUsage {
+	u, ok := s.usage[id]
+	if !ok {
+		u = new(keyUsage)
+		s.usage[id] = u
+	}
+	if day := now.UTC().Format("2006-01-02"); u.day != day {
+		u.day = day
+		u.races = 0
+	}
+	return u
+}
+
+// admit counts a request against the limits of k, and a race too if racing.
+// Nothing is counted if the request isn't admitted. finish must be called
+// once an admitted race is over.
+func (s *keyStore) admit(k *apiKey, racing bool) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	u := s.usageOf(k.ID, now)
+
+	if rate := float64(k.RatePerMinute); rate > 0 {
+		if u.refilled.IsZero() {
+			u.tokens = rate
+		} else {
+			u.tokens = math.Min(rate, u.tokens+now.Sub(u.refilled).Minutes()*rate)
+		}
+		u.refilled = now
+		if u.tokens < 1 {
+			wait := time.Duration((1 - u.tokens) / rate * float64(time.Minute))
+			return &quotaError{message: fmt.Sprintf("this key may make %d requests per minute", k.RatePerMinute), retryAfter: wait}
+		}
+	}
+	if racing {
+		if k.MaxConcurrentRaces > 0 && u.running >= k.MaxConcurrentRaces {
+			return &quotaError{message: fmt.Sprintf("this key may run %d races at once", k.MaxConcurrentRaces)}
+		}
+		if k.DailyRaces > 0 && u.races >= k.DailyRaces {
+			return &quotaError{message: fmt.Sprintf("this key may run %d races per day", k.DailyRaces), retryAfter: untilTomorrow(now)}
+		}
+		u.running++
+		u.races++
+	}
+	if k.RatePerMinute > 0 {
+		u.tokens--
+	}
+	return nil
+}
+
+// finish ends a race admitted for the key with id.
+func (s *keyStore) finish(id string) {
+	s.Lock()
+	s.usageOf(id, time.Now()).running--
+	s.Unlock()
+}
+
+// charge counts n more races against the daily quota of k.
+func (s *keyStore) charge(k *apiKey, n int) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	u := s.usageOf(k.ID, now)
+	if k.DailyRaces > 0 && u.races+n > k.DailyRaces {
+		return &quotaError{message: fmt.Sprintf("this key may run %d races per day, and has %d left", k.DailyRaces, k.DailyRaces-u.races), retryAfter: untilTomorrow(now)}
+	}
+	u.races += n
+	return nil
+}
+
+// untilTomorrow returns the time from now until the next day in UTC.
+func untilTomorrow(now time.Time) time.Duration {
+	now = now.UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// requestKey returns the API key r was sent with. WebSocket clients, which
+// can't set headers in browsers, may pass the key as api_key instead.
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		return r.URL.Query().Get("api_key")
+	}
+	return ""
+}
+
+// writeQuotaError writes the response to a request which exceeded a limit of
+// its key.
+func writeQuotaError(w http.ResponseWriter, err *quotaError) {
+	if err.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.retryAfter.Seconds()))))
+	}
+	writeErrorOutput(w, http.StatusTooManyRequests, "quota_exceeded", err.message)
+}
+
+// the type of the context key under which authenticate stores the key of a
+// request
+type keyContextKey struct{}
+
+// raceSlotKey is the context key of the raceSlot of a racing request.
+type raceSlotKey struct{}
+
+// raceSlot is one of the races the key of a racing request may run at once. It
+// is released when the request has been answered unless the handler keeps it.
+type raceSlot struct {
+	id   string
+	kept bool
+}
+
+// keepRaceSlot keeps the slot of r for a race which continues after the
+// response, like the bot race of a game. The returned function releases it and
+// must be called once that race finishes.
+func keepRaceSlot(r *http.Request) func() {
+	slot, ok := r.Context().Value(raceSlotKey{}).(*raceSlot)
+	if !ok {
+		return func() {}
+	}
+	slot.kept = true
+	return func() { keys.finish(slot.id) }
+}
+
+// admitRace counts a race which isn't started by a racing request, like the
+// start of a room over its WebSocket, against the limits of k. The returned
+// function must be called once the race finishes.
+func admitRace(k *apiKey) (func(), error) {
+	if keys == nil || k == nil {
+		return func() {}, nil
+	}
+	if err := keys.admit(k, true); err != nil {
+		return nil, err
+	}
+	return func() { keys.finish(k.ID) }, nil
+}
+
+// authenticate returns a handler which rejects requests to router without a
+// valid API key once LoadKeys has been called, and enforces the limits of
+// every key.
+func authenticate(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if keys == nil || r.URL.Path == "/health" || r.URL.Path == "/openapi.json" || r.URL.Path == "/" ||
+			strings.HasPrefix(r.URL.Path, "/ui/") || strings.HasPrefix(r.URL.Path, "/admin/") ||
+			strings.HasPrefix(r.URL.Path, "/cluster/") {
+			// the UI sends the key it is given with its own requests, and the
+			// admin and cluster endpoints check the admin and node keys
+			// themselves
+			router.ServeHTTP(w, r)
+			return
+		}
+		k := keys.authenticate(requestKey(r))
+		if k == nil {
+			writeErrorOutput(w, http.StatusUnauthorized, "unauthorized", "a valid API key is required")
+			return
+		}
+		racing := false
+		var match mux.RouteMatch
+		if m, ok := router.(*mux.Router); ok && m.Match(r, &match) && match.Route != nil {
+			racing = racingRoutes[match.Route.GetName()]
+		}
+		if err := keys.admit(k, racing); err != nil {
+			writeQuotaError(w, err.(*quotaError))
+			return
+		}
+		ctx := context.WithValue(r.Context(), keyContextKey{}, k)
+		if racing {
+			slot := &raceSlot{id: k.ID}
+			ctx = context.WithValue(ctx, raceSlotKey{}, slot)
+			defer func() {
+				if !slot.kept {
+					keys.finish(k.ID)
+				}
+			}()
+		}
+		router.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// grpcKey returns the API key a gRPC call was sent with, in its authorization
+// metadata as "Bearer <key>" or in its x-api-key metadata.
+func grpcKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if strings.HasPrefix(auth, "Bearer ") {
+			return strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// authenticateGRPC rejects calls of method without a valid API key once
+// LoadKeys has been called, and enforces the limits of every key like
+// authenticate does. The returned function must be called once the call
+// returns.
+func authenticateGRPC(ctx context.Context, method string) (func(), error) {
+	if keys == nil {
+		return func() {}, nil
+	}
+	k := keys.authenticate(grpcKey(ctx))
+	if k == nil {
+		return nil, status.Error(codes.Unauthenticated, "a valid API key is required")
+	}
+	racing := racingMethods[method]
+	if err := keys.admit(k, racing); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if racing {
+		return func() { keys.finish(k.ID) }, nil
+	}
+	return func() {}, nil
+}
+
+// authenticateUnary is the gRPC interceptor which calls authenticateGRPC.
+func authenticateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	finish, err := authenticateGRPC(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	defer finish()
+	return handler(ctx, req)
+}
+
+// authenticateStream is the gRPC stream interceptor which calls
+// authenticateGRPC.
+func authenticateStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	finish, err := authenticateGRPC(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	defer finish()
+	return handler(srv, stream)
+}
+
+// RequireAdminKey returns a handler which only lets the admin use handler once
+// LoadKeys has been called, e.g. for the pprof endpoints. It is disabled if
+// there is no admin key.
+func RequireAdminKey(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if keys == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		requireAdmin(handler.ServeHTTP)(w, r)
+	})
+}
+
+// chargeRaces counts n more races against the daily quota of the key r was
+// sent with, for requests which run more than one race. It returns false
+// after writing the error response if the quota doesn't allow them.
+func chargeRaces(w http.ResponseWriter, r *http.Request, n int) bool {
+	k, ok := r.Context().Value(keyContextKey{}).(*apiKey)
+	if !ok || n <= 0 {
+		return true
+	}
+	if err := keys.charge(k, n); err != nil {
+		writeQuotaError(w, err.(*quotaError))
+		return false
+	}
+	return true
+}
+
+// keyOutput describes a key to the admin.
+type keyOutput struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	keyLimits
+	// the secret, which is only returned when the key is created
+	Key          string `json:"key,omitempty"`
+	RunningRaces int    `json:"running_races"`
+	RacesToday   int    `json:"races_today"`
+}
+
+// output describes k. s must be locked.
+func (s *keyStore) output(k *apiKey) keyOutput {
+	u := s.usageOf(k.ID, time.Now())
+	return keyOutput{ID: k.ID, Name: k.Name, Created: k.Created, keyLimits: k.keyLimits, RunningRaces: u.running, RacesToday: u.races}
+}
+
+// find returns the key with id. s must be locked.
+func (s *keyStore) find(id string) *apiKey {
+	for _, k := range s.keys {
+		if k.ID == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// keyInput is the body of requests which create or change a key.
+type keyInput struct {
+	Name string `json:"name"`
+	keyLimits
+}
+
+// parseKeyInput reads the body of r.
+func parseKeyInput(r *http.Request) (keyInput, error) {
+	var input keyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return input, invalidInput("the body must be a JSON object with name, rate_per_minute, max_concurrent_races and daily_races")
+	}
+	if input.RatePerMinute < 0 || input.MaxConcurrentRaces < 0 || input.DailyRaces < 0 {
+		return input, invalidInput("limits must be 0, for no limit, or positive")
+	}
+	return input, nil
+}
+
+// requireAdmin returns handler if it's called with the admin key. The admin
+// endpoints don't exist unless both keys and the admin key are configured.
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if keys == nil || adminKey == "" {
+			http.NotFound(w, r)
+			return
+		}
+		// compare hashes, which have the same length, in constant time
+		sent, expected := sha256.Sum256([]byte(requestKey(r))), sha256.Sum256([]byte(adminKey))
+		if subtle.ConstantTimeCompare(sent[:], expected[:]) != 1 {
+			writeErrorOutput(w, http.StatusUnauthorized, "unauthorized", "the admin key is required")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// writeKeys writes value as the JSON response of an admin endpoint.
+func writeKeys(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Error(err)
+	}
+}
+
+// listKeysHandler lists every key with its limits and usage.
+func listKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys.Lock()
+	outputs := []keyOutput{}
+	for _, k := range keys.keys {
+		outputs = append(outputs, keys.output(k))
+	}
+	keys.Unlock()
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Created.Before(outputs[j].Created) })
+	writeKeys(w, http.StatusOK, outputs)
+}
+
+// createKeyHandler creates a key. The response is the only time the secret is
+// shown.
+func createKeyHandler(w http.ResponseWriter, r *http.Request) {
+	input, err := parseKeyInput(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	secret := newRaceID() + newRaceID() + newRaceID() + newRaceID()
+	k := &apiKey{ID: newRaceID(), Name: input.Name, Created: time.Now().UTC(), Hash: hashKey(secret), keyLimits: input.keyLimits}
+	keys.Lock()
+	defer keys.Unlock()
+	keys.keys[k.Hash] = k
+	if err := keys.save(); err != nil {
+		delete(keys.keys, k.Hash)
+		writeError(w, err)
+		return
+	}
+	output := keys.output(k)
+	output.Key = secret
+	writeKeys(w, http.StatusCreated, output)
+}
+
+// updateKeyHandler changes the name and limits of a key. Races already
+// running are not affected.
+func updateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	input, err := parseKeyInput(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	keys.Lock()
+	defer keys.Unlock()
+	k := keys.find(mux.Vars(r)["id"])
+	if k == nil {
+		writeErrorOutput(w, http.StatusNotFound, "not_found", "there is no such key")
+		return
+	}
+	previous := *k
+	if input.Name != "" {
+		k.Name = input.Name
+	}
+	k.keyLimits = input.keyLimits
+	if err := keys.save(); err != nil {
+		*k = previous
+		writeError(w, err)
+		return
+	}
+	writeKeys(w, http.StatusOK, keys.output(k))
+}
+
+// deleteKeyHandler revokes a key at once.
+func deleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keys.Lock()
+	defer keys.Unlock()
+	k := keys.find(mux.Vars(r)["id"])
+	if k == nil {
+		writeErrorOutput(w, http.StatusNotFound, "not_found", "there is no such key")
+		return
+	}
+	delete(keys.keys, k.Hash)
+	if err := keys.save(); err != nil {
+		keys.keys[k.Hash] = k
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,259 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// the most pairs a single batch may contain
+var maxBatchSize int
+
+// the largest body which may be posted to /batch
+var maxBatchBytes int64
+
+// the most pairs of a batch raced at the same time
+var batchConcurrency int
+
+func init() {
+	var err error
+	maxBatchSize = 10000
+	if maxBatchSizeString, ok := os.LookupEnv("WIKIRACER_MAX_BATCH_SIZE"); ok {
+		if maxBatchSize, err = strconv.Atoi(maxBatchSizeString); err != nil {
+			log.Panic(err)
+		}
+	}
+	maxBatchBytes = 8 << 20
+	if maxBytesString, ok := os.LookupEnv("WIKIRACER_MAX_BATCH_BYTES"); ok {
+		if maxBatchBytes, err = strconv.ParseInt(maxBytesString, 10, 64); err != nil {
+			log.Panic(err)
+		}
+	}
+	batchConcurrency = 10
+	if concurrencyString, ok := os.LookupEnv("WIKIRACER_BATCH_CONCURRENCY"); ok {
+		if batchConcurrency, err = strconv.Atoi(concurrencyString); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// racePair is one start and end page in a batch.
+type racePair struct {
+	StartTitle string `json:"starttitle"`
+	EndTitle   string `json:"endtitle"`
+}
+
+// batchResult is one line of a batch response.
+type batchResult struct {
+	// the position of the pair in the request
+	Index      int      `json:"index"`
+	StartTitle string   `json:"starttitle"`
+	EndTitle   string   `json:"endtitle"`
+	Path       []string `json:"path,omitempty"`
+	TimeTaken  string   `json:"time_taken,omitempty"`
+	ID         string   `json:"id,omitempty"`
+	Message    string   `json:"message,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	// how far the race got if it didn't find a path
+	Partial *race.Partial `json:"partial,omitempty"`
+}
+
+// parsePairs reads pairs either as a JSON array or as newline delimited JSON.
+func parsePairs(r io.Reader) ([]racePair, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var pairs []racePair
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &pairs); err != nil {
+			return nil, errors.Wrap(err, "could not parse JSON array of pairs")
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		lineNumber := 0
+		for scanner.Scan() {
+			lineNumber++
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var pair racePair
+			if err := json.Unmarshal(line, &pair); err != nil {
+				return nil, errors.Wrapf(err, "could not parse line %d", lineNumber)
+			}
+			pairs = append(pairs, pair)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if len(pairs) > maxBatchSize {
+		return nil, errors.Errorf("a batch may contain at most %d pairs", maxBatchSize)
+	}
+	return pairs, nil
+}
+
+// runBatch races every pair and calls emit with each result as soon as it is
+// ready. Identical pairs are only raced once, at most batchConcurrency at a
+// time, and every race goes through runRace so that it is served from
+// requestCache when possible and shares the global worker budget. Once ctx is
+// done the running races are canceled and no more pairs are raced. emit is
+// never called concurrently.
+func runBatch(ctx context.Context, newRacer func(a, b string, c race.Config) race.Racer, pairs []racePair,
+	config race.Config, noCache bool, emit func(batchResult)) {
+	indices := make(map[racePair][]int)
+	var unique []racePair
+	for i, pair := range pairs {
+		if _, ok := indices[pair]; !ok {
+			unique = append(unique, pair)
+		}
+		indices[pair] = append(indices[pair], i)
+	}
+
+	toRace := make(chan racePair)
+	results := make(chan batchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < batchConcurrency && i < len(unique); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pair := range toRace {
+				results <- racePairResult(ctx, newRacer, pair, config, noCache)
+			}
+		}()
+	}
+	go func() {
+	feed:
+		for _, pair := range unique {
+			if ctx.Err() != nil {
+				break
+			}
+			select {
+			case toRace <- pair:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(toRace)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		for _, i := range indices[racePair{StartTitle: result.StartTitle, EndTitle: result.EndTitle}] {
+			result.Index = i
+			emit(result)
+		}
+	}
+}
+
+// racePairResult runs the race for one pair of a batch.
+func racePairResult(ctx context.Context, newRacer func(a, b string, c race.Config) race.Racer, pair racePair,
+	config race.Config, noCache bool) batchResult {
+	result := batchResult{StartTitle: pair.StartTitle, EndTitle: pair.EndTitle}
+	if pair.StartTitle == "" || pair.EndTitle == "" {
+		result.Error = "Must pass start and end arguments."
+		return result
+	} else if pair.StartTitle == pair.EndTitle {
+		result.Error = "starttitle cannot equal endtitle"
+		return result
+	}
+
+	raced, err := runRace(newRacer, pair.StartTitle, pair.EndTitle, config, noCache, false, false, cancelWhenDone(ctx))
+	if err != nil {
+		log.Errorf("%+v", err)
+		// like writeError, only the message of a race.Error reaches the client
+		result.Error = race.AsError(err).Message
+		return result
+	}
+	result.ID = raced.id
+	result.TimeTaken = raced.elapsed.String()
+	result.Path = raced.path
+	result.Partial = raced.partial
+	if raced.stopped {
+		result.Path = []string{}
+		result.Message = stoppedMessage
+	} else if raced.path == nil {
+		result.Path = []string{}
+		result.Message = "no path found within " + config.TimeLimit.String()
+	}
+	return result
+}
+
+// batchHandler returns a handler which races many pairs and streams the
+// results back as newline delimited JSON in the order they finish. The race
+// parameters of /race may be passed in the query string and apply to every
+// pair.
+func batchHandler(newRacer func(a, b string, c race.Config) race.Racer) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, err := parseConfig(r.URL.Query())
+		if err != nil {
+			writeError(w, invalidInput(err.Error()))
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+		if err != nil {
+			writeError(w, invalidInput(fmt.Sprintf("the body must be a batch of at most %d bytes", maxBatchBytes)))
+			return
+		}
+		pairs, err := parsePairs(bytes.NewReader(body))
+		if err != nil {
+			writeError(w, invalidInput(err.Error()))
+			return
+		}
+		// the request itself was counted as one race
+		if !chargeRaces(w, r, len(pairs)-1) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		runBatch(r.Context(), newRacer, pairs, config, r.URL.Query().Get("nocache") == "1", func(result batchResult) {
+			if err := enc.Encode(result); err != nil {
+				log.Error(err)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		})
+	}
+}
+
+// RunBatch reads pairs of pages from in, as a JSON array or as newline
+// delimited JSON objects with starttitle and endtitle, races them with the
+// default configuration and writes the results to out as newline delimited
+// JSON.
+func RunBatch(in io.Reader, out io.Writer) error {
+	pairs, err := parsePairs(in)
+	if err != nil {
+		return err
+	}
+	config, err := parseConfig(nil)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(out)
+	runBatch(context.Background(), race.NewRacer, pairs, config, false, func(result batchResult) {
+		if encodeErr := enc.Encode(result); encodeErr != nil && err == nil {
+			err = errors.WithStack(encodeErr)
+		}
+	})
+	return err
+}
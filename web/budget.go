@@ -0,0 +1,71 @@
+package web
+
+import (
+	"os"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// budget limits the number of worker goroutines used by all running races
+var budget *workerBudget
+
+func init() {
+	size := 300
+	if sizeString, ok := os.LookupEnv("WIKIRACER_WORKER_BUDGET"); ok {
+		var err error
+		if size, err = strconv.Atoi(sizeString); err != nil {
+			log.Panic(err)
+		}
+	}
+	budget = newWorkerBudget(size)
+}
+
+// workerBudget is a counting semaphore of worker goroutines. Races acquire
+// their workers before starting and wait while the budget is used up.
+type workerBudget struct {
+	sync.Mutex
+	cond      *sync.Cond
+	size      int
+	available int
+}
+
+func newWorkerBudget(size int) *workerBudget {
+	b := &workerBudget{size: size, available: size}
+	b.cond = sync.NewCond(b)
+	return b
+}
+
+// acquire(n) blocks until n workers are available and takes them. Requests for
+// more workers than the whole budget are capped at the budget. It returns the
+// number of workers taken, which must be passed to release.
+func (b *workerBudget) acquire(n int) int {
+	if n > b.size {
+		n = b.size
+	}
+	b.Lock()
+	for b.available < n {
+		b.cond.Wait()
+	}
+	b.available -= n
+	b.Unlock()
+	return n
+}
+
+// release(n) returns n workers to the budget
+func (b *workerBudget) release(n int) {
+	b.Lock()
+	b.available += n
+	b.Unlock()
+	b.cond.Broadcast()
+}
+
+// workersNeeded returns the number of worker goroutines a race will use.
+func workersNeeded(config race.Config) int {
+	if config.Deterministic {
+		return 1
+	}
+	return config.NumForwardLinksRoutines + config.NumBackwardLinksRoutines
+}
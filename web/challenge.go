@@ -0,0 +1,240 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// the number of pairs of pages tried before giving up on a challenge
+var challengeAttempts int
+
+// the fewest hops a daily challenge may take
+var dailyMinHops int
+
+func init() {
+	var err error
+	challengeAttempts = 5
+	if challengeAttemptsString, ok := os.LookupEnv("WIKIRACER_CHALLENGE_ATTEMPTS"); ok {
+		if challengeAttempts, err = strconv.Atoi(challengeAttemptsString); err != nil {
+			log.Panic(err)
+		}
+	}
+	dailyMinHops = 3
+	if dailyMinHopsString, ok := os.LookupEnv("WIKIRACER_DAILY_MIN_HOPS"); ok {
+		if dailyMinHops, err = strconv.Atoi(dailyMinHopsString); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// A challenge is a race which has been solved, so its optimal number of hops
+// is known.
+type challenge struct {
+	Date       string `json:"date,omitempty"`
+	StartTitle string `json:"starttitle"`
+	EndTitle   string `json:"endtitle"`
+	Hops       int    `json:"hops"`
+}
+
+// challenger generates challenges. The functions it uses to pick and solve
+// races can be replaced to enable mock testing.
+type challenger struct {
+	randomPages      func(n int, c race.Config) ([]string, error)
+	seededPage       func(seed int64, c race.Config) (string, error)
+	shortestDistance func(start, end string, c race.Config) (race.Distance, error)
+
+	// guards daily and generating
+	sync.Mutex
+	// mapping of today's date to its daily challenge; other dates aren't kept,
+	// so the map never grows past one entry
+	daily map[string]challenge
+	// mapping of dates whose daily challenge is being generated to a channel
+	// which is closed once it is, so each one is only generated once at a time
+	generating map[string]chan struct{}
+}
+
+var challenges = &challenger{
+	randomPages:      race.RandomPages,
+	seededPage:       race.SeededPage,
+	shortestDistance: race.ShortestDistance,
+	daily:            make(map[string]challenge),
+	generating:       make(map[string]chan struct{}),
+}
+
+// solve returns the challenge from start to end if its optimal number of hops
+// is proven and at least minHops.
+func (c *challenger) solve(start string, end string, minHops int, config race.Config) (challenge, bool, error) {
+	if start == end {
+		return challenge{}, false, nil
+	}
+	workers := budget.acquire(workersNeeded(config))
+	distance, err := c.shortestDistance(start, end, config)
+	budget.release(workers)
+	if err != nil {
+		return challenge{}, false, err
+	}
+	if !distance.Exact || distance.Hops < minHops {
+		return challenge{}, false, nil
+	}
+	return challenge{StartTitle: start, EndTitle: end, Hops: distance.Hops}, true, nil
+}
+
+// random returns a challenge between random pages which takes at least
+// minHops.
+func (c *challenger) random(minHops int, config race.Config) (challenge, error) {
+	for attempt := 0; attempt < challengeAttempts; attempt++ {
+		pages, err := c.randomPages(2, config)
+		if err != nil {
+			return challenge{}, err
+		}
+		ch, ok, err := c.solve(pages[0], pages[1], minHops, config)
+		if err != nil || ok {
+			return ch, err
+		}
+	}
+	return challenge{}, noChallengeError(fmt.Sprintf("no race of at least %d hops found in %d attempts", minHops, challengeAttempts))
+}
+
+// dailyChallenge returns the challenge for date. The pages are chosen with
+// race.SeededPage using seeds derived from date, so every server generates the
+// same challenge for a date as long as the wiki doesn't change. Only today's
+// challenge is kept once generated.
+func (c *challenger) dailyChallenge(date string, config race.Config) (challenge, error) {
+	for {
+		c.Lock()
+		if ch, ok := c.daily[date]; ok {
+			c.Unlock()
+			return ch, nil
+		}
+		if generated, ok := c.generating[date]; ok {
+			// wait for the other request, then try again in case it failed
+			c.Unlock()
+			<-generated
+			continue
+		}
+		generated := make(chan struct{})
+		c.generating[date] = generated
+		c.Unlock()
+
+		// the lock isn't held while solving, so other dates aren't held up
+		ch, err := c.generateDaily(date, config)
+
+		c.Lock()
+		if err == nil && date == today() {
+			// the challenges of earlier days are no longer asked for often
+			c.daily = map[string]challenge{date: ch}
+		}
+		delete(c.generating, date)
+		close(generated)
+		c.Unlock()
+		return ch, err
+	}
+}
+
+// generateDaily picks and solves the challenge for date.
+func (c *challenger) generateDaily(date string, config race.Config) (challenge, error) {
+	h := fnv.New64a()
+	io.WriteString(h, date)
+	seeds := rand.New(rand.NewSource(int64(h.Sum64())))
+	for attempt := 0; attempt < challengeAttempts; attempt++ {
+		start, err := c.seededPage(seeds.Int63(), config)
+		if err != nil {
+			return challenge{}, err
+		}
+		end, err := c.seededPage(seeds.Int63(), config)
+		if err != nil {
+			return challenge{}, err
+		}
+		ch, ok, err := c.solve(start, end, dailyMinHops, config)
+		if err != nil {
+			return challenge{}, err
+		}
+		if ok {
+			ch.Date = date
+			return ch, nil
+		}
+	}
+	return challenge{}, noChallengeError(fmt.Sprintf("no daily challenge of at least %d hops found in %d attempts", dailyMinHops, challengeAttempts))
+}
+
+// today returns the date of today in UTC, the way daily challenges are keyed.
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// noChallengeError is returned when none of the races tried makes a challenge.
+type noChallengeError string
+
+func (e noChallengeError) Error() string {
+	return string(e)
+}
+
+// writeChallenge writes a challenge, or the error which prevented generating
+// one.
+func writeChallenge(w http.ResponseWriter, ch challenge, err error) {
+	if e, ok := err.(noChallengeError); ok {
+		writeErrorOutput(w, http.StatusServiceUnavailable, "no_challenge", e.Error())
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+	jsonOutput, err := json.MarshalIndent(ch, "", "    ")
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Write(jsonOutput)
+}
+
+// challengeDateHandler returns a handler for the daily challenge of the date
+// in the path, or of today (in UTC) if there is none.
+func challengeDateHandler(c *challenger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		date, ok := mux.Vars(r)["date"]
+		if !ok {
+			date = today()
+		} else if _, err := time.Parse("2006-01-02", date); err != nil {
+			writeError(w, invalidInput(fmt.Sprintf("date must look like 2006-01-02, got %q", date)))
+			return
+		}
+		config, err := parseConfig(nil)
+		if err != nil {
+			log.Panic(err)
+		}
+		ch, err := c.dailyChallenge(date, config)
+		writeChallenge(w, ch, err)
+	}
+}
+
+// challengeRandomHandler returns a handler for random challenges.
+func challengeRandomHandler(c *challenger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		minHops := 1
+		if minHopsString := r.URL.Query().Get("minhops"); minHopsString != "" {
+			var err error
+			if minHops, err = strconv.Atoi(minHopsString); err != nil || minHops <= 0 {
+				writeError(w, invalidInput(fmt.Sprintf("minhops must be a positive integer, got %q", minHopsString)))
+				return
+			}
+		}
+		config, err := parseConfig(r.URL.Query())
+		if err != nil {
+			writeError(w, invalidInput(err.Error()))
+			return
+		}
+		ch, err := c.random(minHops, config)
+		writeChallenge(w, ch, err)
+	}
+}
@@ -0,0 +1,168 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// hub coordinates the races run with distributed=1, whose pages are explored
+// by the nodes leasing from this server
+var hub = race.NewHub()
+
+// the keys nodes must send to /cluster/...; none disables distributed races
+var nodeKeys []string
+
+func init() {
+	for _, key := range strings.Split(os.Getenv("WIKIRACER_NODE_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			nodeKeys = append(nodeKeys, key)
+		}
+	}
+}
+
+// requireNodeKey returns a handler which only lets nodes with one of nodeKeys
+// call handler, since what they report becomes part of races.
+func requireNodeKey(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(nodeKeys) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		// compare hashes, which have the same length, in constant time
+		sent := sha256.Sum256([]byte(requestKey(r)))
+		for _, key := range nodeKeys {
+			expected := sha256.Sum256([]byte(key))
+			if subtle.ConstantTimeCompare(sent[:], expected[:]) == 1 {
+				handler(w, r)
+				return
+			}
+		}
+		writeErrorOutput(w, http.StatusUnauthorized, "unauthorized", "a node key is required")
+	}
+}
+
+// leaseHandler hands out up to max pages of a distributed race to a node. It
+// responds with 204 No Content if there is no work.
+func leaseHandler(w http.ResponseWriter, r *http.Request) {
+	n := 1
+	if max := r.URL.Query().Get("max"); max != "" {
+		var err error
+		if n, err = strconv.Atoi(max); err != nil || n <= 0 {
+			writeError(w, invalidInput(fmt.Sprintf("max must be a positive integer, got %q", max)))
+			return
+		}
+	}
+	assignment, err := hub.Lease(n)
+	if err != nil {
+		writeError(w, err)
+		return
+	} else if assignment.Race == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(assignment); err != nil {
+		log.Error(err)
+	}
+}
+
+// reportHandler receives what a node found exploring the pages it leased.
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	var results []race.Result
+	if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+		writeError(w, invalidInput("the body must be a JSON array of results"))
+		return
+	}
+	if err := hub.Report(mux.Vars(r)["id"], results); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remoteCluster is the race.Cluster of the wikiracer server at url, which is
+// sent key if it requires API keys.
+type remoteCluster struct {
+	url    string
+	key    string
+	client *http.Client
+}
+
+// post sends body to the coordinator's endpoint at path.
+func (c remoteCluster) post(path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	resp, err := c.client.Do(req)
+	return resp, errors.WithStack(err)
+}
+
+func (c remoteCluster) Lease(n int) (race.Assignment, error) {
+	var assignment race.Assignment
+	resp, err := c.post(fmt.Sprintf("/cluster/lease?max=%d", n), nil)
+	if err != nil {
+		return assignment, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return assignment, nil
+	case http.StatusOK:
+		return assignment, errors.WithStack(json.NewDecoder(resp.Body).Decode(&assignment))
+	}
+	return assignment, errors.Errorf("the coordinator responded to a lease with status %d", resp.StatusCode)
+}
+
+func (c remoteCluster) Report(id string, results []race.Result) error {
+	body, err := json.Marshal(results)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := c.post(fmt.Sprintf("/cluster/races/%s/report", id), body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return errors.Errorf("the coordinator responded to a report with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// nodeLinks explores pages for a node. The coordinator's API URL is ignored so
+// that a coordinator can't make nodes query arbitrary hosts.
+func nodeLinks(task race.Task, config race.Config) (race.Result, error) {
+	config.APIURL = race.DefaultConfig().APIURL
+	return race.APILinks(task, config)
+}
+
+// RunNode explores the pages of the distributed races of the wikiracer server
+// at coordinatorURL with workers goroutines until ctx is done. key is the API
+// key to send to the coordinator, if it requires one.
+func RunNode(ctx context.Context, coordinatorURL string, key string, workers int) {
+	cluster := remoteCluster{
+		url:    strings.TrimSuffix(coordinatorURL, "/"),
+		key:    key,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	race.RunNode(ctx, cluster, nodeLinks, workers)
+}
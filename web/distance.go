@@ -0,0 +1,95 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// the most titles a distance matrix may contain
+var maxDistanceTitles int
+
+func init() {
+	maxDistanceTitles = 10
+	if maxDistanceTitlesString, ok := os.LookupEnv("WIKIRACER_MAX_DISTANCE_TITLES"); ok {
+		var err error
+		if maxDistanceTitles, err = strconv.Atoi(maxDistanceTitlesString); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// distanceOutput is one entry of the distance matrix in a response.
+type distanceOutput struct {
+	// nil if no path is known
+	Hops  *int `json:"hops"`
+	Exact bool `json:"exact"`
+}
+
+// distanceHandler returns a handler for the distance endpoint which computes
+// matrices with distances. It is parameterized in this way to enable mock
+// testing.
+func distanceHandler(distances func(titles []string, c race.Config) (*race.DistanceMatrix, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var titles []string
+		seen := make(map[string]bool)
+		for _, title := range strings.Split(r.URL.Query().Get("titles"), "|") {
+			if title != "" && !seen[title] {
+				seen[title] = true
+				titles = append(titles, title)
+			}
+		}
+		if len(titles) < 2 {
+			writeError(w, invalidInput("Must pass at least two titles separated by |."))
+			return
+		} else if len(titles) > maxDistanceTitles {
+			writeError(w, invalidInput(fmt.Sprintf("Must pass at most %d titles.", maxDistanceTitles)))
+			return
+		}
+		config, err := parseConfig(r.URL.Query())
+		if err != nil {
+			writeError(w, invalidInput(err.Error()))
+			return
+		}
+
+		workers := budget.acquire(workersNeeded(config))
+		start := time.Now()
+		matrix, err := distances(titles, config)
+		elapsed := time.Since(start)
+		budget.release(workers)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		rows := make([][]distanceOutput, len(matrix.Distances))
+		for i, row := range matrix.Distances {
+			rows[i] = make([]distanceOutput, len(row))
+			for j, d := range row {
+				rows[i][j].Exact = d.Exact
+				if d.Hops >= 0 {
+					hops := d.Hops
+					rows[i][j].Hops = &hops
+				}
+			}
+		}
+		output := map[string]interface{}{
+			"titles":     matrix.Titles,
+			"distances":  rows,
+			"time_taken": elapsed.String(),
+			"config":     configOutput(config),
+		}
+		jsonOutput, err := json.MarshalIndent(output, "", "    ")
+		if err != nil {
+			log.Panic(err)
+		}
+		w.Write(jsonOutput)
+	}
+}
@@ -0,0 +1,108 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// statusClientClosedRequest is the non-standard status nginx uses for requests
+// whose client went away before the response was ready.
+const statusClientClosedRequest = 499
+
+// errorStatuses maps the kinds of race errors to HTTP status codes. Other
+// errors are 500 Internal Server Error.
+var errorStatuses = map[race.ErrorKind]int{
+	race.PageNotFound:        http.StatusNotFound,
+	race.UpstreamUnavailable: http.StatusBadGateway,
+	race.RateLimited:         http.StatusTooManyRequests,
+	race.Cancelled:           statusClientClosedRequest,
+	race.InvalidInput:        http.StatusBadRequest,
+	race.ShuttingDown:        http.StatusServiceUnavailable,
+}
+
+// errorOutput is the JSON body of every error response.
+type errorOutput struct {
+	Error struct {
+		Kind    string `json:"kind"`
+		Message string `json:"message"`
+		// close matches for the titles which don't exist
+		Suggestions map[string][]string `json:"suggestions,omitempty"`
+	} `json:"error"`
+}
+
+// newErrorOutput describes e to the client.
+func newErrorOutput(e *race.Error) *errorOutput {
+	output := &errorOutput{}
+	output.Error.Kind = e.Kind.String()
+	output.Error.Message = e.Message
+	output.Error.Suggestions = e.Suggestions
+	return output
+}
+
+// invalidInput returns an InvalidInput error with message.
+func invalidInput(message string) error {
+	return &race.Error{Kind: race.InvalidInput, Message: message}
+}
+
+// writeError writes err with the status code of its kind. Only the message of
+// a race.Error reaches the client; the details of the error, including its
+// stack trace, are logged instead.
+func writeError(w http.ResponseWriter, err error) {
+	e := race.AsError(err)
+	status, ok := errorStatuses[e.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	if status >= http.StatusInternalServerError {
+		log.Errorf("%+v", err)
+	} else {
+		log.Infof("%+v", err)
+	}
+
+	writeErrorBody(w, status, newErrorOutput(e))
+}
+
+// writeErrorOutput writes an error response of kind with status.
+func writeErrorOutput(w http.ResponseWriter, status int, kind string, message string) {
+	output := &errorOutput{}
+	output.Error.Kind = kind
+	output.Error.Message = message
+	writeErrorBody(w, status, output)
+}
+
+// writeErrorBody writes output with status.
+func writeErrorBody(w http.ResponseWriter, status int, output *errorOutput) {
+	jsonOutput, err := json.MarshalIndent(output, "", "    ")
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonOutput)
+}
+
+// cancelOnDisconnect returns an observer for runRace which cancels the race if
+// r's client goes away before it finishes.
+func cancelOnDisconnect(r *http.Request) func(racer race.Racer, done <-chan struct{}) {
+	return cancelWhenDone(r.Context())
+}
+
+// cancelWhenDone returns an observer for runRace which cancels the race if ctx
+// is done before it finishes, e.g. because a gRPC client went away.
+func cancelWhenDone(ctx context.Context) func(racer race.Racer, done <-chan struct{}) {
+	return func(racer race.Racer, done <-chan struct{}) {
+		canceler, ok := racer.(race.Canceler)
+		if !ok {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			canceler.Cancel()
+		case <-done:
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sandlerben/wikiracer/game"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// games hosts the game sessions served by the default routes
+var games = &gameServer{newRacer: race.NewRacer, linksTo: race.LinksTo}
+
+func init() {
+	size := 1000
+	if sizeString, ok := os.LookupEnv("WIKIRACER_GAME_STORE_SIZE"); ok {
+		var err error
+		if size, err = strconv.Atoi(sizeString); err != nil {
+			log.Panic(err)
+		}
+	}
+	games.store = game.NewMemoryStore(size)
+}
+
+// SetGameStore replaces the in-memory store of game sessions. It must be
+// called before the server starts.
+func SetGameStore(store game.Store) {
+	games.store = store
+}
+
+// gameServer serves game sessions. The racer and link checks it uses are
+// parameterized to enable mock testing.
+type gameServer struct {
+	store    game.Store
+	newRacer func(a, b string, c race.Config) race.Racer
+	linksTo  func(from, to string, c race.Config) (bool, error)
+}
+
+// writeSession writes the state of a game.
+func writeSession(w http.ResponseWriter, s *game.Session) {
+	output := map[string]interface{}{
+		"game":    s,
+		"current": s.Current(),
+		"path":    s.Path(),
+		"elapsed": s.Elapsed().String(),
+	}
+	if s.Finished {
+		output["score"] = s.Score()
+	}
+	jsonOutput, err := json.MarshalIndent(output, "", "    ")
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Write(jsonOutput)
+}
+
+// writeStoreError writes an error returned by the session store.
+func writeStoreError(w http.ResponseWriter, err error) {
+	if err == game.ErrNotFound {
+		writeErrorOutput(w, http.StatusNotFound, "not_found", err.Error())
+		return
+	}
+	writeError(w, err)
+}
+
+// createGameHandler starts a game from starttitle to endtitle. The racer
+// starts racing at the same time so that its path is ready for comparison
+// once the player finishes. The race parameters of /race may be passed too.
+func (g *gameServer) createGameHandler(w http.ResponseWriter, r *http.Request) {
+	startTitle := r.URL.Query().Get("starttitle")
+	endTitle := r.URL.Query().Get("endtitle")
+	if startTitle == "" || endTitle == "" {
+		writeError(w, invalidInput("Must pass start and end arguments."))
+		return
+	} else if startTitle == endTitle {
+		writeError(w, invalidInput("starttitle cannot equal endtitle"))
+		return
+	}
+	config, err := parseConfig(r.URL.Query())
+	if err != nil {
+		writeError(w, invalidInput(err.Error()))
+		return
+	}
+
+	s := &game.Session{
+		ID:         newRaceID(),
+		StartTitle: startTitle,
+		EndTitle:   endTitle,
+		Namespaces: config.Namespaces,
+		StartedAt:  time.Now(),
+		Clicks:     []game.Click{},
+	}
+	if err := g.store.Create(s); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// the race counts toward the limits of the key until it finishes
+	finish := keepRaceSlot(r)
+	go func() {
+		defer finish()
+		result, err := runRace(g.newRacer, startTitle, endTitle, config, false, false, false, nil)
+		if err != nil {
+			log.Errorf("%+v", err)
+		}
+		_, updateErr := g.store.Update(s.ID, func(s *game.Session) error {
+			s.BotDone = true
+			s.BotPath = result.path
+			if err != nil {
+				// like writeError, only the message of a race.Error reaches the player
+				s.BotError = race.AsError(err).Message
+			}
+			return nil
+		})
+		if updateErr != nil && updateErr != game.ErrNotFound {
+			log.Errorf("%+v", updateErr)
+		}
+	}()
+
+	w.Header().Set("Location", "/games/"+s.ID)
+	w.WriteHeader(http.StatusCreated)
+	writeSession(w, s)
+}
+
+// getGameHandler returns the state of a game.
+func (g *gameServer) getGameHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := g.store.Get(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeSession(w, s)
+}
+
+// clickHandler moves the player to title if it is linked from the page the
+// player is on.
+func (g *gameServer) clickHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		writeError(w, invalidInput("Must pass a title argument."))
+		return
+	}
+	s, err := g.store.Get(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if s.Finished {
+		writeErrorOutput(w, http.StatusConflict, "conflict", "the game is already finished")
+		return
+	}
+
+	// check the link without holding the session, since it takes a request
+	from := s.Current()
+	config := race.DefaultConfig()
+	config.Namespaces = s.Namespaces
+	ok, err := g.linksTo(from, title, config)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	at := time.Now()
+	invalid := false
+	s, err = g.store.Update(id, func(s *game.Session) error {
+		if s.Current() != from {
+			return errMovedOn
+		}
+		if !ok {
+			invalid = true
+			s.InvalidClicks++
+			return nil
+		}
+		return s.Click(title, at)
+	})
+	if err == errMovedOn {
+		writeErrorOutput(w, http.StatusConflict, "conflict", err.Error())
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if invalid {
+		writeError(w, invalidInput(fmt.Sprintf("%s does not link to %s", from, title)))
+		return
+	}
+	writeSession(w, s)
+}
+
+// errMovedOn is returned when another click was recorded while a click was
+// being checked.
+var errMovedOn = errors.New("the player moved to another page while the click was being checked")
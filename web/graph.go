@@ -0,0 +1,117 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// the most nodes a graph dump may contain
+var maxGraphNodes int
+
+// the number of graphs kept in memory before the oldest is evicted
+var graphStoreSize int
+
+func init() {
+	var err error
+	maxGraphNodes = 10000
+	if maxGraphNodesString, ok := os.LookupEnv("WIKIRACER_MAX_GRAPH_NODES"); ok {
+		if maxGraphNodes, err = strconv.Atoi(maxGraphNodesString); err != nil {
+			log.Panic(err)
+		}
+	}
+	graphStoreSize = 100
+	if graphStoreSizeString, ok := os.LookupEnv("WIKIRACER_GRAPH_STORE_SIZE"); ok {
+		if graphStoreSize, err = strconv.Atoi(graphStoreSizeString); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// graphStore keeps the graphs of the most recent races which asked for them.
+type graphStore struct {
+	sync.Mutex
+	graphs map[string]*race.Graph
+	// ids in insertion order, used to evict the oldest graph
+	order []string
+}
+
+var graphs = graphStore{graphs: make(map[string]*race.Graph)}
+
+// put(id, g) stores g, evicting the oldest graph if the store is full
+func (s *graphStore) put(id string, g *race.Graph) {
+	s.Lock()
+	defer s.Unlock()
+	s.graphs[id] = g
+	s.order = append(s.order, id)
+	for len(s.order) > graphStoreSize {
+		delete(s.graphs, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// get(id) returns the graph stored for id
+func (s *graphStore) get(id string) (*race.Graph, bool) {
+	s.Lock()
+	defer s.Unlock()
+	g, ok := s.graphs[id]
+	return g, ok
+}
+
+// newRaceID returns a random identifier for a race.
+func newRaceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// graphHandler writes the graph explored by a race as GraphML, DOT or JSON
+// lines. The race must have been run with graph=1.
+func graphHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	g, ok := graphs.get(id)
+	if !ok {
+		writeErrorOutput(w, http.StatusNotFound, "not_found", "no graph was recorded for this race")
+		return
+	}
+
+	maxNodes := maxGraphNodes
+	if maxNodesString := r.URL.Query().Get("maxnodes"); maxNodesString != "" {
+		var err error
+		if maxNodes, err = strconv.Atoi(maxNodesString); err != nil || maxNodes <= 0 {
+			writeError(w, invalidInput("maxnodes must be a positive integer"))
+			return
+		}
+	}
+	if maxNodes < maxGraphNodes {
+		g = g.Truncate(maxNodes)
+	}
+
+	var err error
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = g.WriteJSONLines(w)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		err = g.WriteDOT(w)
+	case "graphml":
+		w.Header().Set("Content-Type", "application/graphml+xml")
+		err = g.WriteGraphML(w)
+	default:
+		writeError(w, invalidInput("format must be one of jsonl, dot or graphml"))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,227 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/race"
+	"github.com/sandlerben/wikiracer/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// how often RaceProgress and /race?stream=1 report progress
+var progressInterval = 500 * time.Millisecond
+
+// grpcServer implements the WikiRacer gRPC service on top of the same racer,
+// cache and configuration as the HTTP server.
+type grpcServer struct {
+	rpc.UnimplementedWikiRacerServer
+	newRacer   func(a, b string, c race.Config) race.Racer
+	verifyPath func(path []string, c race.Config) (int, error)
+}
+
+// NewGRPCServer returns a grpc.Server with the WikiRacer service registered.
+// Once LoadKeys has been called, calls need an API key like HTTP requests do.
+func NewGRPCServer() *grpc.Server {
+	s := grpc.NewServer(grpcServerOptions()...)
+	rpc.RegisterWikiRacerServer(s, &grpcServer{newRacer: race.NewRacer, verifyPath: race.VerifyPath})
+	return s
+}
+
+// grpcServerOptions are the options of the gRPC server, which authenticate
+// calls.
+func grpcServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{grpc.UnaryInterceptor(authenticateUnary), grpc.StreamInterceptor(authenticateStream)}
+}
+
+// configFromProto builds the race.Config for a gRPC request. It goes through
+// parseConfig so that requests are validated and capped exactly like /race.
+func configFromProto(c *rpc.RaceConfig) (race.Config, error) {
+	query := url.Values{}
+	if c != nil {
+		if c.TimeLimit != "" {
+			query.Set("timelimit", c.TimeLimit)
+		}
+		if c.ForwardWorkers != 0 {
+			query.Set("forwardworkers", strconv.Itoa(int(c.ForwardWorkers)))
+		}
+		if c.BackwardWorkers != 0 {
+			query.Set("backwardworkers", strconv.Itoa(int(c.BackwardWorkers)))
+		}
+		if c.AllNamespaces {
+			query.Set("namespaces", "*")
+		} else if len(c.Namespaces) > 0 {
+			namespaces := make([]string, len(c.Namespaces))
+			for i, ns := range c.Namespaces {
+				namespaces[i] = strconv.Itoa(int(ns))
+			}
+			query.Set("namespaces", strings.Join(namespaces, "|"))
+		}
+		if c.AllLinks {
+			query.Set("alllinks", "true")
+		}
+		if c.Seed != 0 {
+			query.Set("seed", strconv.FormatInt(c.Seed, 10))
+		}
+		if c.Deterministic {
+			query.Set("deterministic", "true")
+		}
+	}
+	return parseConfig(query)
+}
+
+// configToProto describes the effective configuration of a race.
+func configToProto(config race.Config) *rpc.RaceConfig {
+	c := &rpc.RaceConfig{
+		TimeLimit:       config.TimeLimit.String(),
+		ForwardWorkers:  int32(config.NumForwardLinksRoutines),
+		BackwardWorkers: int32(config.NumBackwardLinksRoutines),
+		AllNamespaces:   len(config.Namespaces) == 0,
+		AllLinks:        config.ExploreAllLinks,
+		Seed:            config.Seed,
+		Deterministic:   config.Deterministic,
+	}
+	for _, ns := range config.Namespaces {
+		c.Namespaces = append(c.Namespaces, int32(ns))
+	}
+	return c
+}
+
+// validateRaceRequest checks a gRPC race request and returns its config.
+func validateRaceRequest(req *rpc.RaceRequest) (race.Config, error) {
+	if req.StartTitle == "" || req.EndTitle == "" {
+		return race.Config{}, status.Error(codes.InvalidArgument, "must pass start and end titles")
+	} else if req.StartTitle == req.EndTitle {
+		return race.Config{}, status.Error(codes.InvalidArgument, "start title cannot equal end title")
+	}
+	config, err := configFromProto(req.Config)
+	if err != nil {
+		return config, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return config, nil
+}
+
+// raceResponse converts the result of runRace to its gRPC form.
+func raceResponse(result raceResult) *rpc.RaceResponse {
+	resp := &rpc.RaceResponse{
+		Path:      result.path,
+		TimeTaken: result.elapsed.String(),
+		Config:    configToProto(result.config),
+		Id:        result.id,
+	}
+	if result.stopped {
+		resp.Message = stoppedMessage
+	} else if result.path == nil {
+		resp.Message = fmt.Sprintf("no path found within %s", result.config.TimeLimit)
+	}
+	return resp
+}
+
+// Race finds a path between two pages.
+func (s *grpcServer) Race(ctx context.Context, req *rpc.RaceRequest) (*rpc.RaceResponse, error) {
+	config, err := validateRaceRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	result, err := runRace(s.newRacer, req.StartTitle, req.EndTitle, config, req.NoCache, false, false, cancelWhenDone(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return raceResponse(result), nil
+}
+
+// RaceProgress runs a race and streams its progress until it finishes.
+func (s *grpcServer) RaceProgress(req *rpc.RaceRequest, stream rpc.WikiRacer_RaceProgressServer) error {
+	config, err := validateRaceRequest(req)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	cancel := cancelWhenDone(stream.Context())
+	observe := func(racer race.Racer, done <-chan struct{}) {
+		go cancel(racer, done)
+		progresser, ok := racer.(race.Progresser)
+		if !ok {
+			return
+		}
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				progress := progresser.Progress()
+				err := stream.Send(&rpc.RaceProgressUpdate{
+					ForwardPages:  int64(progress.ForwardPages),
+					BackwardPages: int64(progress.BackwardPages),
+					Elapsed:       time.Since(start).String(),
+				})
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	result, err := runRace(s.newRacer, req.StartTitle, req.EndTitle, config, req.NoCache, false, false, observe)
+	if err != nil {
+		return grpcError(err)
+	}
+	return stream.Send(&rpc.RaceProgressUpdate{
+		Elapsed: time.Since(start).String(),
+		Result:  raceResponse(result),
+	})
+}
+
+// VerifyPath checks that each page in a path links to the next one.
+func (s *grpcServer) VerifyPath(ctx context.Context, req *rpc.VerifyPathRequest) (*rpc.VerifyPathResponse, error) {
+	config := race.DefaultConfig()
+	config.Namespaces = nil
+	for _, ns := range req.Namespaces {
+		config.Namespaces = append(config.Namespaces, int(ns))
+	}
+
+	brokenHop, err := s.verifyPath(req.Path, config)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if brokenHop >= 0 {
+		return &rpc.VerifyPathResponse{
+			BrokenHop: int32(brokenHop),
+			Message:   fmt.Sprintf("%s does not link to %s", req.Path[brokenHop], req.Path[brokenHop+1]),
+		}, nil
+	}
+	return &rpc.VerifyPathResponse{Valid: true, BrokenHop: -1}, nil
+}
+
+// grpcCodes maps the kinds of race errors to gRPC codes, like errorStatuses.
+var grpcCodes = map[race.ErrorKind]codes.Code{
+	race.PageNotFound:        codes.NotFound,
+	race.UpstreamUnavailable: codes.Unavailable,
+	race.RateLimited:         codes.ResourceExhausted,
+	race.Cancelled:           codes.Canceled,
+	race.InvalidInput:        codes.InvalidArgument,
+	race.ShuttingDown:        codes.Unavailable,
+}
+
+// grpcError converts a race error to a gRPC status, logging its details.
+func grpcError(err error) error {
+	e := race.AsError(err)
+	code, ok := grpcCodes[e.Kind]
+	if !ok {
+		code = codes.Internal
+		log.Errorf("%+v", err)
+	} else {
+		log.Infof("%+v", err)
+	}
+	return status.Error(code, e.Message)
+}
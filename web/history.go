@@ -0,0 +1,196 @@
+package web
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/history"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// raceHistory records every race which is run; nil disables history
+var raceHistory *history.Store
+
+// the most records /history and /leaderboard return
+var maxHistoryLimit int
+
+func init() {
+	maxHistoryLimit = 100
+	if limitString, ok := os.LookupEnv("WIKIRACER_MAX_HISTORY_LIMIT"); ok {
+		var err error
+		if maxHistoryLimit, err = strconv.Atoi(limitString); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// SetHistory records races in store and warms requestCache with the shortest
+// path found so far for every pair in it, for races exploring the same
+// namespaces. It must be called before the server starts.
+func SetHistory(store *history.Store) error {
+	raceHistory = store
+	requestCacheLock.Lock()
+	defer requestCacheLock.Unlock()
+	return store.Pairs(func(stats history.PairStats) error {
+		if stats.ShortestPath != nil {
+			requestCache[newRequestInfo(stats.StartTitle, stats.EndTitle, stats.ShortestPathNamespaces)] = stats.ShortestPath
+		}
+		return nil
+	})
+}
+
+// recordRace adds a race which was run to raceHistory, if it is enabled.
+func recordRace(id string, startTitle string, endTitle string, config race.Config,
+	racer race.Racer, path []string, err error, elapsed time.Duration) {
+	if raceHistory == nil {
+		return
+	}
+	record := history.Record{
+		ID:         id,
+		StartTitle: startTitle,
+		EndTitle:   endTitle,
+		Config: history.Config{
+			TimeLimit:       config.TimeLimit,
+			ForwardWorkers:  config.NumForwardLinksRoutines,
+			BackwardWorkers: config.NumBackwardLinksRoutines,
+			AllLinks:        config.ExploreAllLinks,
+			Namespaces:      config.Namespaces,
+			Seed:            config.Seed,
+			Deterministic:   config.Deterministic,
+		},
+		FinishedAt: time.Now(),
+		Outcome:    history.Found,
+		Path:       path,
+		Elapsed:    elapsed,
+	}
+	for _, edge := range config.Edges {
+		record.Config.Edges = append(record.Config.Edges, string(edge))
+	}
+	if err != nil {
+		record.Outcome = history.Failed
+		// like error responses, history leaves out internal details
+		record.Error = race.AsError(err).Message
+	} else if path == nil {
+		record.Outcome = history.Timeout
+	}
+	if progresser, ok := racer.(race.Progresser); ok {
+		progress := progresser.Progress()
+		record.ForwardPages = progress.ForwardPages
+		record.BackwardPages = progress.BackwardPages
+	}
+	if err := raceHistory.Put(record); err != nil {
+		log.Errorf("%+v", err)
+	}
+}
+
+// parseLimit reads the limit parameter, which defaults to and is capped at
+// maxHistoryLimit.
+func parseLimit(query url.Values) (int, error) {
+	limit := maxHistoryLimit
+	if limitString := query.Get("limit"); limitString != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitString); err != nil || limit <= 0 {
+			return 0, fmt.Errorf("limit must be a positive integer, got %q", limitString)
+		}
+		if limit > maxHistoryLimit {
+			limit = maxHistoryLimit
+		}
+	}
+	return limit, nil
+}
+
+// writeHistoryDisabled responds to history requests when history is disabled.
+func writeHistoryDisabled(w http.ResponseWriter) {
+	writeErrorOutput(w, http.StatusNotFound, "not_found", "race history is disabled")
+}
+
+// historyHandler returns past races, newest first. They may be filtered by
+// starttitle, endtitle, outcome and the RFC 3339 times since and until. Pass
+// the returned next cursor as before to get the next page.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if raceHistory == nil {
+		writeHistoryDisabled(w)
+		return
+	}
+	query := r.URL.Query()
+	filter := history.Filter{
+		StartTitle: query.Get("starttitle"),
+		EndTitle:   query.Get("endtitle"),
+		Outcome:    query.Get("outcome"),
+		Before:     query.Get("before"),
+	}
+	var err error
+	if filter.Limit, err = parseLimit(query); err != nil {
+		writeError(w, invalidInput(err.Error()))
+		return
+	}
+	if _, err := hex.DecodeString(filter.Before); err != nil {
+		writeError(w, invalidInput(fmt.Sprintf("before must be a next cursor from /history, got %q", filter.Before)))
+		return
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				writeError(w, invalidInput(fmt.Sprintf("%s must be an RFC 3339 time, got %q", param, value)))
+				return
+			}
+		}
+	}
+
+	records, next, err := raceHistory.Query(filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if records == nil {
+		records = []history.Record{}
+	}
+	jsonOutput, err := json.MarshalIndent(map[string]interface{}{
+		"races": records,
+		"next":  next,
+	}, "", "    ")
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Write(jsonOutput)
+}
+
+// leaderboardHandler returns the hardest pairs raced so far and the pairs with
+// the longest shortest paths.
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if raceHistory == nil {
+		writeHistoryDisabled(w)
+		return
+	}
+	limit, err := parseLimit(r.URL.Query())
+	if err != nil {
+		writeError(w, invalidInput(err.Error()))
+		return
+	}
+	hardest, longest, err := raceHistory.Leaderboard(limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if hardest == nil {
+		hardest = []history.PairStats{}
+	}
+	if longest == nil {
+		longest = []history.PairStats{}
+	}
+	jsonOutput, err := json.MarshalIndent(map[string]interface{}{
+		"hardest": hardest,
+		"longest": longest,
+	}, "", "    ")
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Write(jsonOutput)
+}
@@ -0,0 +1,166 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// the deepest and largest neighborhoods a client may ask for
+var maxNeighborhoodDepth int
+var maxNeighborhoodNodes int
+
+func init() {
+	var err error
+	maxNeighborhoodDepth = 3
+	if maxNeighborhoodDepthString, ok := os.LookupEnv("WIKIRACER_MAX_NEIGHBORHOOD_DEPTH"); ok {
+		if maxNeighborhoodDepth, err = strconv.Atoi(maxNeighborhoodDepthString); err != nil {
+			log.Panic(err)
+		}
+	}
+	maxNeighborhoodNodes = 10000
+	if maxNeighborhoodNodesString, ok := os.LookupEnv("WIKIRACER_MAX_NEIGHBORHOOD_NODES"); ok {
+		if maxNeighborhoodNodes, err = strconv.Atoi(maxNeighborhoodNodesString); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// neighborhoodLevel is the pages at one distance from the title.
+type neighborhoodLevel struct {
+	Distance int      `json:"distance"`
+	Pages    []string `json:"pages"`
+}
+
+// neighborhoodHandler returns a handler for the neighborhood endpoint which
+// uses the supplied neighborhood function. It is parameterized in this way to
+// enable mock testing.
+func neighborhoodHandler(neighborhood func(title string, depth int, in bool, maxNodes int, c race.Config,
+	visit func(distance int, pages []string) bool) (bool, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		title := query.Get("title")
+		if title == "" {
+			writeError(w, invalidInput("Must pass a title argument."))
+			return
+		}
+		depth := 1
+		if depthString := query.Get("depth"); depthString != "" {
+			var err error
+			if depth, err = strconv.Atoi(depthString); err != nil || depth < 0 {
+				writeError(w, invalidInput(fmt.Sprintf("depth must be a non-negative integer, got %q", depthString)))
+				return
+			}
+		}
+		if depth > maxNeighborhoodDepth {
+			depth = maxNeighborhoodDepth
+		}
+		direction := query.Get("direction")
+		if direction == "" {
+			direction = "out"
+		} else if direction != "out" && direction != "in" {
+			writeError(w, invalidInput(fmt.Sprintf("direction must be out or in, got %q", direction)))
+			return
+		}
+		maxNodes := maxNeighborhoodNodes
+		if maxNodesString := query.Get("maxnodes"); maxNodesString != "" {
+			var err error
+			if maxNodes, err = strconv.Atoi(maxNodesString); err != nil || maxNodes <= 0 {
+				writeError(w, invalidInput(fmt.Sprintf("maxnodes must be a positive integer, got %q", maxNodesString)))
+				return
+			}
+		}
+		if maxNodes > maxNeighborhoodNodes {
+			maxNodes = maxNeighborhoodNodes
+		}
+		config, err := parseConfig(query)
+		if err != nil {
+			writeError(w, invalidInput(err.Error()))
+			return
+		}
+
+		workers := budget.acquire(workersNeeded(config))
+		defer budget.release(workers)
+		start := time.Now()
+
+		if query.Get("stream") == "1" {
+			streamNeighborhood(w, r, neighborhood, title, depth, direction == "in", maxNodes, config)
+			return
+		}
+
+		levels := []neighborhoodLevel{}
+		total := 0
+		truncated, err := neighborhood(title, depth, direction == "in", maxNodes, config, func(distance int, pages []string) bool {
+			levels = append(levels, neighborhoodLevel{Distance: distance, Pages: pages})
+			total += len(pages)
+			return true
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		output := map[string]interface{}{
+			"title":      title,
+			"direction":  direction,
+			"depth":      depth,
+			"levels":     levels,
+			"total":      total,
+			"truncated":  truncated,
+			"time_taken": time.Since(start).String(),
+			"config":     configOutput(config),
+		}
+		jsonOutput, err := json.MarshalIndent(output, "", "    ")
+		if err != nil {
+			log.Panic(err)
+		}
+		w.Write(jsonOutput)
+	}
+}
+
+// streamNeighborhood writes each level of a neighborhood as a line of JSON as
+// soon as it has been explored, followed by a summary line. It stops exploring
+// if the client goes away.
+func streamNeighborhood(w http.ResponseWriter, r *http.Request, neighborhood func(title string, depth int, in bool,
+	maxNodes int, c race.Config, visit func(distance int, pages []string) bool) (bool, error),
+	title string, depth int, in bool, maxNodes int, config race.Config) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	start := time.Now()
+	total := 0
+
+	truncated, err := neighborhood(title, depth, in, maxNodes, config, func(distance int, pages []string) bool {
+		total += len(pages)
+		if err := enc.Encode(neighborhoodLevel{Distance: distance, Pages: pages}); err != nil {
+			log.Error(err)
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return r.Context().Err() == nil
+	})
+
+	summary := map[string]interface{}{
+		"total":      total,
+		"truncated":  truncated,
+		"time_taken": time.Since(start).String(),
+	}
+	if err != nil {
+		e := race.AsError(err)
+		if status, ok := errorStatuses[e.Kind]; !ok || status >= http.StatusInternalServerError {
+			log.Errorf("%+v", err)
+		}
+		summary["error"] = e.Message
+	}
+	if err := enc.Encode(summary); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,18 @@
+package web
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes every route in routes. TestOpenAPI checks that it
+// stays in sync with them.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIHandler serves the OpenAPI specification of the API.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
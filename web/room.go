@@ -0,0 +1,587 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// the longest a room may race before it is finished
+var roomTimeLimit time.Duration
+
+// the number of rooms kept before the oldest is closed
+var roomStoreSize int
+
+func init() {
+	var err error
+	roomTimeLimit = 10 * time.Minute
+	if roomTimeLimitString, ok := os.LookupEnv("WIKIRACER_ROOM_TIME_LIMIT"); ok {
+		if roomTimeLimit, err = time.ParseDuration(roomTimeLimitString); err != nil {
+			log.Panic(err)
+		}
+	}
+	roomStoreSize = 100
+	if roomStoreSizeString, ok := os.LookupEnv("WIKIRACER_ROOM_STORE_SIZE"); ok {
+		if roomStoreSize, err = strconv.Atoi(roomStoreSizeString); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// the states of a room
+const (
+	roomWaiting  = "waiting"
+	roomRacing   = "racing"
+	roomFinished = "finished"
+)
+
+// the name the racer is shown with on leaderboards
+const botName = "wikiracer"
+
+var upgrader = websocket.Upgrader{}
+
+// roomServer hosts multiplayer rooms. The racer and link checks it uses are
+// parameterized to enable mock testing.
+type roomServer struct {
+	sync.Mutex
+	rooms map[string]*room
+	// ids in creation order, used to close the oldest room
+	order []string
+
+	newRacer func(a, b string, c race.Config) race.Racer
+	linksTo  func(from, to string, c race.Config) (bool, error)
+}
+
+// rooms hosts the rooms served by the default routes
+var rooms = newRoomServer(race.NewRacer, race.LinksTo)
+
+func newRoomServer(newRacer func(a, b string, c race.Config) race.Racer,
+	linksTo func(from, to string, c race.Config) (bool, error)) *roomServer {
+	return &roomServer{rooms: make(map[string]*room), newRacer: newRacer, linksTo: linksTo}
+}
+
+// A room is a race between several players, who all get the same start and
+// end page, and the racer, whose path is revealed once the room finishes.
+type room struct {
+	sync.Mutex
+	server     *roomServer
+	id         string
+	startTitle string
+	endTitle   string
+	config     race.Config
+	state      string
+	// the first player to join, who may start the race
+	host       string
+	startedAt  time.Time
+	finishedAt time.Time
+	// players by name, and their names in the order they joined
+	players     map[string]*roomPlayer
+	playerOrder []string
+	// finishes the room once roomTimeLimit has passed
+	timer *time.Timer
+	// done once the room is closed, which cancels its racer
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	botDone    bool
+	botPath    []string
+	botError   string
+	botElapsed time.Duration
+
+	conns map[*roomConn]bool
+}
+
+// roomPlayer is the progress of one player in a room.
+type roomPlayer struct {
+	name string
+	// proves that a reconnecting client is the same player
+	token      string
+	path       []string
+	finished   bool
+	finishedAt time.Time
+	// the number of open connections of this player
+	connections int
+}
+
+// roomConn is one WebSocket connection to a room. Messages are written by a
+// single goroutine which reads them from send.
+type roomConn struct {
+	ws     *websocket.Conn
+	send   chan []byte
+	player string
+}
+
+// clientMessage is a message sent by a player.
+type clientMessage struct {
+	// start or click
+	Type  string `json:"type"`
+	Title string `json:"title,omitempty"`
+}
+
+// playerOutput describes a player in a room.
+type playerOutput struct {
+	Name      string   `json:"name"`
+	Path      []string `json:"path"`
+	Clicks    int      `json:"clicks"`
+	Finished  bool     `json:"finished"`
+	TimeTaken string   `json:"time_taken,omitempty"`
+	Connected bool     `json:"connected"`
+}
+
+// leaderboardEntry is one line of a room's leaderboard.
+type leaderboardEntry struct {
+	Rank      int    `json:"rank"`
+	Name      string `json:"name"`
+	Clicks    int    `json:"clicks"`
+	TimeTaken string `json:"time_taken"`
+	Bot       bool   `json:"bot,omitempty"`
+
+	elapsed time.Duration
+}
+
+// roomOutput describes a room.
+type roomOutput struct {
+	ID          string             `json:"id"`
+	StartTitle  string             `json:"starttitle"`
+	EndTitle    string             `json:"endtitle"`
+	State       string             `json:"state"`
+	Host        string             `json:"host"`
+	Players     []playerOutput     `json:"players"`
+	Leaderboard []leaderboardEntry `json:"leaderboard"`
+	// only set once the room is finished and the racer is done
+	BotPath  []string `json:"bot_path,omitempty"`
+	BotError string   `json:"bot_error,omitempty"`
+}
+
+// serverMessage is a message sent to players.
+type serverMessage struct {
+	// welcome, state or error
+	Type    string      `json:"type"`
+	Token   string      `json:"token,omitempty"`
+	Room    *roomOutput `json:"room,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// put(r) stores r, closing the oldest room if the server is full
+func (s *roomServer) put(r *room) {
+	s.Lock()
+	s.rooms[r.id] = r
+	s.order = append(s.order, r.id)
+	var evicted []*room
+	for len(s.order) > roomStoreSize {
+		evicted = append(evicted, s.rooms[s.order[0]])
+		delete(s.rooms, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.Unlock()
+
+	for _, old := range evicted {
+		old.close()
+	}
+}
+
+// get(id) returns the room with id
+func (s *roomServer) get(id string) (*room, bool) {
+	s.Lock()
+	defer s.Unlock()
+	r, ok := s.rooms[id]
+	return r, ok
+}
+
+// createRoomHandler creates a room racing from starttitle to endtitle. The race
+// parameters of /race may be passed too and apply to the racer.
+func (s *roomServer) createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	startTitle := r.URL.Query().Get("starttitle")
+	endTitle := r.URL.Query().Get("endtitle")
+	if startTitle == "" || endTitle == "" {
+		writeError(w, invalidInput("Must pass start and end arguments."))
+		return
+	} else if startTitle == endTitle {
+		writeError(w, invalidInput("starttitle cannot equal endtitle"))
+		return
+	}
+	config, err := parseConfig(r.URL.Query())
+	if err != nil {
+		writeError(w, invalidInput(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rm := &room{
+		server:     s,
+		id:         newRaceID(),
+		startTitle: startTitle,
+		endTitle:   endTitle,
+		config:     config,
+		state:      roomWaiting,
+		players:    make(map[string]*roomPlayer),
+		conns:      make(map[*roomConn]bool),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	s.put(rm)
+
+	rm.Lock()
+	output := rm.output()
+	rm.Unlock()
+	w.Header().Set("Location", "/rooms/"+rm.id)
+	w.WriteHeader(http.StatusCreated)
+	writeRoom(w, output)
+}
+
+// getRoomHandler returns the state of a room.
+func (s *roomServer) getRoomHandler(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.get(mux.Vars(r)["id"])
+	if !ok {
+		writeErrorOutput(w, http.StatusNotFound, "not_found", "room not found")
+		return
+	}
+	rm.Lock()
+	output := rm.output()
+	rm.Unlock()
+	writeRoom(w, output)
+}
+
+func writeRoom(w http.ResponseWriter, output *roomOutput) {
+	jsonOutput, err := json.MarshalIndent(output, "", "    ")
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Write(jsonOutput)
+}
+
+// roomSocketHandler connects a player to a room over WebSocket. Players join
+// with a name and get a token in a welcome message; passing the token again
+// reconnects to the same player.
+func (s *roomServer) roomSocketHandler(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.get(mux.Vars(r)["id"])
+	if !ok {
+		writeErrorOutput(w, http.StatusNotFound, "not_found", "room not found")
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" || name == botName {
+		writeError(w, invalidInput("Must pass a name argument."))
+		return
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written the error
+		log.Error(err)
+		return
+	}
+	conn := &roomConn{ws: ws, send: make(chan []byte, 64), player: name}
+	go conn.writeLoop()
+
+	token, err := rm.join(conn, r.URL.Query().Get("token"))
+	if err != nil {
+		conn.sendMessage(serverMessage{Type: "error", Message: err.Error()})
+		close(conn.send)
+		return
+	}
+	rm.sendTo(conn, serverMessage{Type: "welcome", Token: token})
+	rm.broadcast()
+
+	defer func() {
+		rm.leave(conn)
+		rm.broadcast()
+	}()
+	for {
+		var msg clientMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		var err error
+		switch msg.Type {
+		case "start":
+			// the key the socket was opened with, if any, runs the race
+			k, _ := r.Context().Value(keyContextKey{}).(*apiKey)
+			err = rm.start(name, k)
+		case "click":
+			err = rm.click(name, msg.Title)
+		default:
+			err = errors.Errorf("unknown message type %q", msg.Type)
+		}
+		if err != nil {
+			rm.sendTo(conn, serverMessage{Type: "error", Message: err.Error()})
+		}
+	}
+}
+
+// writeLoop writes messages to the connection until send is closed.
+func (c *roomConn) writeLoop() {
+	defer c.ws.Close()
+	for msg := range c.send {
+		if err := c.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+			// drain send so that senders never block
+			for range c.send {
+			}
+			return
+		}
+	}
+	c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+// sendMessage queues msg for the connection. Messages to clients which are too
+// slow to keep up are dropped.
+func (c *roomConn) sendMessage(msg serverMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Panic(err)
+	}
+	select {
+	case c.send <- b:
+	default:
+		log.Warnf("dropped a message to %s", c.player)
+	}
+}
+
+// join adds conn to the room as a new player, or reconnects it to an existing
+// player if token matches. It returns the player's token.
+func (rm *room) join(conn *roomConn, token string) (string, error) {
+	rm.Lock()
+	defer rm.Unlock()
+	if rm.conns == nil {
+		return "", errors.New("the room is closed")
+	}
+
+	player, ok := rm.players[conn.player]
+	if ok && player.token != token {
+		return "", errors.Errorf("the name %s is taken", conn.player)
+	} else if !ok {
+		if rm.state != roomWaiting {
+			return "", errors.New("the race has already started")
+		}
+		player = &roomPlayer{name: conn.player, token: newRaceID(), path: []string{rm.startTitle}}
+		rm.players[conn.player] = player
+		rm.playerOrder = append(rm.playerOrder, conn.player)
+		if rm.host == "" {
+			rm.host = conn.player
+		}
+	}
+	player.connections++
+	rm.conns[conn] = true
+	return player.token, nil
+}
+
+// leave disconnects conn from the room. The player stays in the room and may
+// reconnect.
+func (rm *room) leave(conn *roomConn) {
+	rm.Lock()
+	defer rm.Unlock()
+	if !rm.conns[conn] {
+		return
+	}
+	delete(rm.conns, conn)
+	close(conn.send)
+	if player, ok := rm.players[conn.player]; ok {
+		player.connections--
+	}
+}
+
+// close disconnects everyone from the room and cancels its racer.
+func (rm *room) close() {
+	rm.Lock()
+	defer rm.Unlock()
+	rm.cancel()
+	if rm.timer != nil {
+		rm.timer.Stop()
+	}
+	for conn := range rm.conns {
+		close(conn.send)
+	}
+	rm.conns = nil
+}
+
+// start starts the race if name is the host. The racer starts racing at the
+// same time, and counts toward the limits of k until it finishes.
+func (rm *room) start(name string, k *apiKey) error {
+	rm.Lock()
+	if name != rm.host {
+		rm.Unlock()
+		return errors.New("only the host can start the race")
+	} else if rm.state != roomWaiting {
+		rm.Unlock()
+		return errors.New("the race has already started")
+	}
+	finish, err := admitRace(k)
+	if err != nil {
+		rm.Unlock()
+		return err
+	}
+	rm.state = roomRacing
+	rm.startedAt = time.Now()
+	rm.timer = time.AfterFunc(roomTimeLimit, func() {
+		rm.Lock()
+		rm.finish()
+		rm.Unlock()
+		rm.broadcast()
+	})
+	rm.Unlock()
+
+	go func() {
+		defer finish()
+		result, err := runRace(rm.server.newRacer, rm.startTitle, rm.endTitle, rm.config, false, false, false, cancelWhenDone(rm.ctx))
+		rm.Lock()
+		rm.botDone = true
+		rm.botPath = result.path
+		rm.botElapsed = result.elapsed
+		if err != nil {
+			log.Errorf("%+v", err)
+			// like writeError, only the message of a race.Error reaches the players
+			rm.botError = race.AsError(err).Message
+		}
+		rm.Unlock()
+		rm.broadcast()
+	}()
+
+	rm.broadcast()
+	return nil
+}
+
+// click moves name to title if it is linked from the page they are on. The
+// room finishes once every player has reached the end page.
+func (rm *room) click(name string, title string) error {
+	rm.Lock()
+	player := rm.players[name]
+	if rm.state != roomRacing {
+		rm.Unlock()
+		return errors.New("the room is not racing")
+	} else if player.finished {
+		rm.Unlock()
+		return errors.New("you have already finished")
+	}
+	from := player.path[len(player.path)-1]
+	rm.Unlock()
+
+	// check the link without holding the room, since it takes a request
+	config := race.DefaultConfig()
+	config.Namespaces = rm.config.Namespaces
+	ok, err := rm.server.linksTo(from, title, config)
+	if err != nil {
+		log.Errorf("%+v", err)
+		return errors.New("could not check the link")
+	}
+	if !ok {
+		return errors.Errorf("%s does not link to %s", from, title)
+	}
+
+	rm.Lock()
+	if rm.state != roomRacing || player.path[len(player.path)-1] != from {
+		rm.Unlock()
+		return errors.New("you moved to another page while the click was being checked")
+	}
+	player.path = append(player.path, title)
+	if title == rm.endTitle {
+		player.finished = true
+		player.finishedAt = time.Now()
+		allFinished := true
+		for _, p := range rm.players {
+			allFinished = allFinished && p.finished
+		}
+		if allFinished {
+			rm.finish()
+		}
+	}
+	rm.Unlock()
+	rm.broadcast()
+	return nil
+}
+
+// finish ends the race. The caller must hold the lock.
+func (rm *room) finish() {
+	if rm.state == roomFinished {
+		return
+	}
+	rm.state = roomFinished
+	rm.finishedAt = time.Now()
+	if rm.timer != nil {
+		rm.timer.Stop()
+	}
+}
+
+// sendTo sends msg to conn unless it has left the room.
+func (rm *room) sendTo(conn *roomConn, msg serverMessage) {
+	rm.Lock()
+	defer rm.Unlock()
+	if rm.conns[conn] {
+		conn.sendMessage(msg)
+	}
+}
+
+// broadcast sends the state of the room to every connection.
+func (rm *room) broadcast() {
+	rm.Lock()
+	defer rm.Unlock()
+	msg := serverMessage{Type: "state", Room: rm.output()}
+	for conn := range rm.conns {
+		conn.sendMessage(msg)
+	}
+}
+
+// output describes the room. The caller must hold the lock.
+func (rm *room) output() *roomOutput {
+	output := &roomOutput{
+		ID:          rm.id,
+		StartTitle:  rm.startTitle,
+		EndTitle:    rm.endTitle,
+		State:       rm.state,
+		Host:        rm.host,
+		Players:     []playerOutput{},
+		Leaderboard: []leaderboardEntry{},
+	}
+	for _, name := range rm.playerOrder {
+		p := rm.players[name]
+		po := playerOutput{
+			Name:      p.name,
+			Path:      append([]string(nil), p.path...),
+			Clicks:    len(p.path) - 1,
+			Finished:  p.finished,
+			Connected: p.connections > 0,
+		}
+		if p.finished {
+			elapsed := p.finishedAt.Sub(rm.startedAt)
+			po.TimeTaken = elapsed.String()
+			output.Leaderboard = append(output.Leaderboard, leaderboardEntry{
+				Name: p.name, Clicks: po.Clicks, TimeTaken: po.TimeTaken, elapsed: elapsed,
+			})
+		}
+		output.Players = append(output.Players, po)
+	}
+
+	if rm.state == roomFinished && rm.botDone {
+		output.BotPath = rm.botPath
+		output.BotError = rm.botError
+		if rm.botPath != nil {
+			output.Leaderboard = append(output.Leaderboard, leaderboardEntry{
+				Name: botName, Clicks: len(rm.botPath) - 1, TimeTaken: rm.botElapsed.String(),
+				Bot: true, elapsed: rm.botElapsed,
+			})
+		}
+	}
+
+	// fewer clicks rank higher, and ties go to whoever was faster
+	sort.SliceStable(output.Leaderboard, func(i, j int) bool {
+		a, b := output.Leaderboard[i], output.Leaderboard[j]
+		if a.Clicks != b.Clicks {
+			return a.Clicks < b.Clicks
+		}
+		return a.elapsed < b.elapsed
+	})
+	for i := range output.Leaderboard {
+		output.Leaderboard[i].Rank = i + 1
+	}
+	return output
+}
@@ -0,0 +1,144 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/sandlerben/wikiracer/race"
+	"google.golang.org/grpc"
+)
+
+// stoppedMessage explains the result of a race which was stopped by Shutdown.
+const stoppedMessage = "the race was stopped because the server is shutting down"
+
+// how long Shutdown waits for responses once the races in flight have been
+// stopped, before closing the connections which are left
+var stopGracePeriod = 5 * time.Second
+
+// inFlight holds the races which are running, so that Shutdown can stop them
+var inFlight = newRaceSet()
+
+// raceSet is the set of running races. Once it is closed, no more races may
+// start.
+type raceSet struct {
+	sync.Mutex
+	// maps every running racer to whether it was stopped
+	racers  map[race.Racer]bool
+	closed  bool
+	running sync.WaitGroup
+}
+
+func newRaceSet() *raceSet {
+	return &raceSet{racers: make(map[race.Racer]bool)}
+}
+
+// add(racer) adds a racer which is about to run. It returns false if the set
+// is closed, in which case the race must not run.
+func (s *raceSet) add(racer race.Racer) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return false
+	}
+	s.racers[racer] = false
+	s.running.Add(1)
+	return true
+}
+
+// stopped(racer) returns true if racer was stopped by stopAll.
+func (s *raceSet) stopped(racer race.Racer) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.racers[racer]
+}
+
+// remove(racer) removes a racer which has finished and been recorded.
+func (s *raceSet) remove(racer race.Racer) {
+	s.Lock()
+	delete(s.racers, racer)
+	s.Unlock()
+	s.running.Done()
+}
+
+// close prevents new races from starting.
+func (s *raceSet) close() {
+	s.Lock()
+	s.closed = true
+	s.Unlock()
+}
+
+// stopAll stops every running race which is a race.Stopper.
+func (s *raceSet) stopAll() {
+	s.Lock()
+	defer s.Unlock()
+	for racer := range s.racers {
+		if stopper, ok := racer.(race.Stopper); ok {
+			stopper.Stop()
+			s.racers[racer] = true
+		}
+	}
+}
+
+// wait blocks until every race has been removed.
+func (s *raceSet) wait() {
+	s.running.Wait()
+}
+
+// Shutdown gracefully shuts down server and grpcServer, either of which may be
+// nil. New races are refused at once. The races in flight have until ctx is
+// done to finish; then they are stopped and respond with the results they have
+// so far. Every race is recorded in the history before Shutdown returns, so
+// the history may be closed afterwards.
+func Shutdown(ctx context.Context, server *http.Server, grpcServer *grpc.Server) error {
+	inFlight.close()
+
+	// cancelled once the connections which are left must be closed
+	forceCtx, force := context.WithCancel(context.Background())
+	defer force()
+	var servers sync.WaitGroup
+	var httpErr error
+	if server != nil {
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			if httpErr = server.Shutdown(forceCtx); httpErr != nil {
+				server.Close()
+			}
+		}()
+	}
+	if grpcServer != nil {
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			grpcServer.GracefulStop()
+		}()
+	}
+	drained := make(chan struct{})
+	go func() {
+		servers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Info("the drain timeout expired, stopping the races in flight")
+		inFlight.stopAll()
+		select {
+		case <-drained:
+		case <-time.After(stopGracePeriod):
+			force()
+			if grpcServer != nil {
+				grpcServer.Stop()
+			}
+		}
+	}
+	<-drained
+	// handlers of closed connections may still be recording their races
+	inFlight.wait()
+	return errors.WithStack(httpErr)
+}
@@ -0,0 +1,216 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// snapshots keeps the search state of races run with checkpoint=1 which
+// didn't find a path
+var snapshots snapshotStore
+
+// the largest snapshot which may be posted to /races/{id}/resume
+var maxSnapshotBytes int64
+
+func init() {
+	maxSnapshotBytes = 64 << 20
+	if maxString, ok := os.LookupEnv("WIKIRACER_MAX_SNAPSHOT_BYTES"); ok {
+		var err error
+		if maxSnapshotBytes, err = strconv.ParseInt(maxString, 10, 64); err != nil {
+			log.Panic(err)
+		}
+	}
+	size := 20
+	if sizeString, ok := os.LookupEnv("WIKIRACER_SNAPSHOT_STORE_SIZE"); ok {
+		var err error
+		if size, err = strconv.Atoi(sizeString); err != nil {
+			log.Panic(err)
+		}
+	}
+	snapshots = newMemorySnapshotStore(size)
+	if dir := os.Getenv("WIKIRACER_SNAPSHOT_DIR"); dir != "" {
+		snapshots = dirSnapshotStore(dir)
+	}
+}
+
+// snapshotStore keeps snapshots by race id.
+type snapshotStore interface {
+	put(id string, s *race.Snapshot) error
+	// get(id) returns nil if there is no snapshot for id
+	get(id string) (*race.Snapshot, error)
+}
+
+// memorySnapshotStore keeps the most recent snapshots in memory.
+type memorySnapshotStore struct {
+	sync.Mutex
+	size      int
+	snapshots map[string]*race.Snapshot
+	// ids in insertion order, used to evict the oldest snapshot
+	order []string
+}
+
+func newMemorySnapshotStore(size int) *memorySnapshotStore {
+	return &memorySnapshotStore{size: size, snapshots: make(map[string]*race.Snapshot)}
+}
+
+func (s *memorySnapshotStore) put(id string, snapshot *race.Snapshot) error {
+	s.Lock()
+	defer s.Unlock()
+	s.snapshots[id] = snapshot
+	s.order = append(s.order, id)
+	for len(s.order) > s.size {
+		delete(s.snapshots, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil
+}
+
+func (s *memorySnapshotStore) get(id string) (*race.Snapshot, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.snapshots[id], nil
+}
+
+// dirSnapshotStore keeps every snapshot as a JSON file in a directory, which
+// may be shared by several servers.
+type dirSnapshotStore string
+
+func (d dirSnapshotStore) path(id string) string {
+	return filepath.Join(string(d), id+".json")
+}
+
+func (d dirSnapshotStore) put(id string, s *race.Snapshot) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	value, err := json.Marshal(s)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// write to a temporary file first so that readers never see half a
+	// snapshot
+	tmp := d.path(id) + ".tmp"
+	if err := ioutil.WriteFile(tmp, value, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp, d.path(id)))
+}
+
+func (d dirSnapshotStore) get(id string) (*race.Snapshot, error) {
+	value, err := ioutil.ReadFile(d.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var s race.Snapshot
+	if err := json.Unmarshal(value, &s); err != nil {
+		return nil, errors.Wrapf(err, "could not read snapshot %s", id)
+	}
+	return &s, nil
+}
+
+// saveSnapshot stores the search state of racer under id, if it can be saved.
+func saveSnapshot(id string, racer race.Racer) bool {
+	snapshotter, ok := racer.(race.Snapshotter)
+	if !ok {
+		return false
+	}
+	if err := snapshots.put(id, snapshotter.Snapshot()); err != nil {
+		log.Errorf("%+v", err)
+		return false
+	}
+	return true
+}
+
+// snapshotHandler returns the snapshot of a race run with checkpoint=1, which
+// another server can resume.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := snapshots.get(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	} else if snapshot == nil {
+		writeErrorOutput(w, http.StatusNotFound, "not_found", "no snapshot was saved for this race")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		log.Error(err)
+	}
+}
+
+// resumeHandler returns a handler which continues a race from its snapshot
+// with a new time limit, and optionally new numbers of workers. The snapshot is
+// read from the request body if there is one, so that races can move between
+// servers, and from the snapshot store otherwise. The handler is
+// parameterized by resume to enable mock testing.
+func resumeHandler(resume func(*race.Snapshot, race.Config) (race.Racer, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		var snapshot *race.Snapshot
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotBytes))
+		if err != nil {
+			writeError(w, invalidInput(fmt.Sprintf("the body must be a snapshot of at most %d bytes", maxSnapshotBytes)))
+			return
+		}
+		if len(body) > 0 {
+			snapshot = new(race.Snapshot)
+			if err := json.Unmarshal(body, snapshot); err != nil {
+				writeError(w, invalidInput("the body must be a snapshot from /races/{id}/snapshot"))
+				return
+			}
+		} else if snapshot, err = snapshots.get(id); err != nil {
+			writeError(w, err)
+			return
+		} else if snapshot == nil {
+			writeErrorOutput(w, http.StatusNotFound, "not_found", "no snapshot was saved for this race")
+			return
+		}
+
+		query := r.URL.Query()
+		parsed, err := parseConfig(query)
+		if err != nil {
+			writeError(w, invalidInput(err.Error()))
+			return
+		}
+		config := snapshot.Config
+		// snapshots from other servers must not choose which wiki is queried
+		config.APIURL = race.DefaultConfig().APIURL
+		config.TimeLimit = parsed.TimeLimit
+		if n := config.NumForwardLinksRoutines; query.Get("forwardworkers") != "" || n <= 0 || n > maxWorkers {
+			config.NumForwardLinksRoutines = parsed.NumForwardLinksRoutines
+		}
+		if n := config.NumBackwardLinksRoutines; query.Get("backwardworkers") != "" || n <= 0 || n > maxWorkers {
+			config.NumBackwardLinksRoutines = parsed.NumBackwardLinksRoutines
+		}
+		racer, err := resume(snapshot, config)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		newRacer := func(a, b string, c race.Config) race.Racer {
+			return racer
+		}
+		result, err := runRace(newRacer, snapshot.StartTitle, snapshot.EndTitle, config, true,
+			query.Get("graph") == "1", query.Get("checkpoint") == "1", cancelOnDisconnect(r))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		result.resumedFrom = id
+		writeRaceResult(w, r, result)
+	}
+}
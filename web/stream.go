@@ -0,0 +1,90 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// raceEvent is a line of the response to /race?stream=1.
+type raceEvent struct {
+	// progress, result or error
+	Type          string `json:"type"`
+	ForwardPages  int    `json:"forward_pages,omitempty"`
+	BackwardPages int    `json:"backward_pages,omitempty"`
+	Elapsed       string `json:"elapsed,omitempty"`
+	// the same as the response to /race without stream=1
+	Result map[string]interface{} `json:"result,omitempty"`
+	// set if the race failed
+	*errorOutput
+}
+
+// streamRace runs a race for /race?stream=1. It writes the number of pages
+// reached from each side as a line of JSON every progressInterval, followed by
+// a line with the result or the error. The race is cancelled if the client
+// goes away.
+func streamRace(w http.ResponseWriter, r *http.Request, newRacer func(a, b string, c race.Config) race.Racer,
+	startTitle string, endTitle string, config race.Config, noCache bool, keepGraph bool, checkpoint bool) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	write := func(event raceEvent) {
+		if err := enc.Encode(event); err != nil {
+			log.Error(err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	// the headers tell the client the race has started
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	start := time.Now()
+	observe := func(racer race.Racer, done <-chan struct{}) {
+		progresser, _ := racer.(race.Progresser)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-r.Context().Done():
+				if canceler, ok := racer.(race.Canceler); ok {
+					canceler.Cancel()
+				}
+				return
+			case <-ticker.C:
+				if progresser == nil {
+					continue
+				}
+				progress := progresser.Progress()
+				write(raceEvent{
+					Type:          "progress",
+					ForwardPages:  progress.ForwardPages,
+					BackwardPages: progress.BackwardPages,
+					Elapsed:       time.Since(start).String(),
+				})
+			}
+		}
+	}
+
+	result, err := runRace(newRacer, startTitle, endTitle, config, noCache, keepGraph, checkpoint, observe)
+	if err != nil {
+		e := race.AsError(err)
+		if status, ok := errorStatuses[e.Kind]; !ok || status >= http.StatusInternalServerError {
+			log.Errorf("%+v", err)
+		} else {
+			log.Infof("%+v", err)
+		}
+		write(raceEvent{Type: "error", errorOutput: newErrorOutput(e)})
+		return
+	}
+	write(raceEvent{Type: "result", Result: raceOutput(r, result)})
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/race"
+	"github.com/sandlerben/wikiracer/titles"
+)
+
+// titleIndex suggests articles offline once LoadTitleIndex has been called.
+// Otherwise suggestions come from the MediaWiki API.
+var titleIndex *titles.Index
+
+// the most suggestions a client may ask for, and how many are proposed for a
+// page which doesn't exist
+const maxSuggestions = 50
+const resolveSuggestions = 5
+
+// LoadTitleIndex loads the titles of the articles of the wiki from path, with
+// one title per line like the all-titles-in-ns0 dumps of Wikipedia, to
+// suggest titles from.
+func LoadTitleIndex(path string) error {
+	ix, err := titles.Load(path)
+	if err != nil {
+		return err
+	}
+	titleIndex = ix
+	log.Infof("Loaded %d titles from %s", ix.Len(), path)
+	return nil
+}
+
+// articlesOnly returns true if config only explores articles, which are the
+// only pages in titleIndex.
+func articlesOnly(config race.Config) bool {
+	return len(config.Namespaces) == 1 && config.Namespaces[0] == 0
+}
+
+// suggestHandler returns a handler for the suggest endpoint which falls back
+// to suggest if there is no titleIndex or other namespaces are asked for. It
+// is parameterized by suggest to enable mock testing.
+func suggestHandler(suggest func(query string, n int, c race.Config) ([]string, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		q := strings.TrimSpace(query.Get("q"))
+		if q == "" {
+			writeError(w, invalidInput("Must pass a q argument."))
+			return
+		}
+		limit := 10
+		if limitString := query.Get("limit"); limitString != "" {
+			var err error
+			if limit, err = strconv.Atoi(limitString); err != nil || limit <= 0 {
+				writeError(w, invalidInput(fmt.Sprintf("limit must be a positive integer, got %q", limitString)))
+				return
+			}
+		}
+		if limit > maxSuggestions {
+			limit = maxSuggestions
+		}
+		config, err := parseConfig(query)
+		if err != nil {
+			writeError(w, invalidInput(err.Error()))
+			return
+		}
+
+		var suggestions []string
+		source := "index"
+		if titleIndex != nil && articlesOnly(config) {
+			suggestions = titleIndex.Suggest(q, limit)
+		} else {
+			source = "api"
+			if suggestions, err = suggest(q, limit, config); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		if suggestions == nil {
+			suggestions = []string{}
+		}
+		jsonOutput, err := json.MarshalIndent(map[string]interface{}{
+			"query":       q,
+			"suggestions": suggestions,
+			"source":      source,
+		}, "", "    ")
+		if err != nil {
+			log.Panic(err)
+		}
+		w.Write(jsonOutput)
+	}
+}
+
+// resolveTitles checks that the start and end pages of a race exist before it
+// starts, so that a mistyped title fails at once rather than after the
+// workers spin up. The PageNotFound error for missing pages proposes close
+// matches.
+func resolveTitles(resolver race.Resolver, config race.Config) error {
+	missing, err := resolver.MissingTitles()
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	suggestions := make(map[string][]string, len(missing))
+	for _, title := range missing {
+		var matches []string
+		if titleIndex != nil && articlesOnly(config) {
+			matches = titleIndex.Suggest(title, resolveSuggestions)
+		} else if matches, err = resolver.Suggest(title, resolveSuggestions); err != nil {
+			// the error is more useful without suggestions than not at all
+			log.Errorf("%+v", err)
+		}
+		if matches == nil {
+			matches = []string{}
+		}
+		suggestions[title] = matches
+	}
+
+	message := fmt.Sprintf("the page %s does not exist", missing[0])
+	if len(missing) == 2 {
+		message = fmt.Sprintf("the pages %s and %s do not exist", missing[0], missing[1])
+	}
+	if matches := suggestions[missing[0]]; len(missing) == 1 && len(matches) > 0 {
+		message += fmt.Sprintf("; did you mean %s?", matches[0])
+	}
+	return &race.Error{Kind: race.PageNotFound, Message: message, Suggestions: suggestions}
+}
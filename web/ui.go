@@ -0,0 +1,51 @@
+package web
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// uiFiles is the single-page UI served at /ui/.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiIndex is the page of the UI, which is told which wiki to link pages to.
+var uiIndex = template.Must(template.ParseFS(uiFiles, "ui/index.html"))
+
+// uiHandler serves the UI. Paths which aren't files serve the page itself.
+func uiHandler() func(http.ResponseWriter, *http.Request) {
+	assets, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		log.Panic(err)
+	}
+	fileServer := http.StripPrefix("/ui/", http.FileServer(http.FS(assets)))
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/ui/")
+		if name != "" && name != "index.html" {
+			if _, err := fs.Stat(assets, name); err == nil {
+				fileServer.ServeHTTP(w, r)
+				return
+			}
+		}
+		var page bytes.Buffer
+		err := uiIndex.Execute(&page, map[string]string{"APIURL": race.DefaultConfig().APIURL})
+		if err != nil {
+			log.Panic(err)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page.Bytes())
+	}
+}
+
+// homeHandler sends browsers to the UI.
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/ui/", http.StatusFound)
+}
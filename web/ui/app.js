@@ -0,0 +1,415 @@
+// The wikiracer UI. Races run over /race?stream=1, which reports progress as
+// they go, and the pages they explored are drawn from /races/{id}/graph.
+(function () {
+  "use strict";
+
+  // the MediaWiki API titles are looked up in
+  const apiURL = document.body.dataset.apiUrl;
+  // the most pages to draw
+  const maxGraphNodes = 300;
+
+  const form = document.getElementById("race-form");
+  const startInput = document.getElementById("start");
+  const endInput = document.getElementById("end");
+  const keyInput = document.getElementById("api-key");
+  const raceButton = document.getElementById("race");
+  const stopButton = document.getElementById("stop");
+  const message = document.getElementById("message");
+  const pathList = document.getElementById("path");
+  const canvas = document.getElementById("graph-canvas");
+  const tooltip = document.getElementById("tooltip");
+
+  let controller = null;
+  let graph = null;
+
+  keyInput.value = localStorage.getItem("wikiracer-api-key") || "";
+  keyInput.addEventListener("change", function () {
+    localStorage.setItem("wikiracer-api-key", keyInput.value);
+  });
+
+  function headers() {
+    return keyInput.value ? { "X-API-Key": keyInput.value } : {};
+  }
+
+  function pageURL(title) {
+    return apiURL.replace(/api\.php$/, "index.php") + "?title=" + encodeURIComponent(title);
+  }
+
+  // autocomplete suggests titles close to what was typed in input, using the
+  // suggest endpoint so that typos are forgiven.
+  function autocomplete(input, list) {
+    let timer = null;
+    let latest = 0;
+    input.addEventListener("input", function () {
+      clearTimeout(timer);
+      const prefix = input.value.trim();
+      if (prefix.length < 2) {
+        return;
+      }
+      timer = setTimeout(async function () {
+        const request = ++latest;
+        const params = new URLSearchParams({ q: prefix, limit: "10" });
+        try {
+          const resp = await fetch("/suggest?" + params, { headers: headers() });
+          if (!resp.ok) {
+            return;
+          }
+          const { suggestions: titles } = await resp.json();
+          if (request !== latest) {
+            return;
+          }
+          list.replaceChildren(...titles.map(function (title) {
+            const option = document.createElement("option");
+            option.value = title;
+            return option;
+          }));
+        } catch (e) {
+          // suggestions are a convenience
+        }
+      }, 200);
+    });
+  }
+
+  // readLines calls handle with every line of JSON in body as it arrives.
+  async function readLines(body, handle) {
+    const reader = body.getReader();
+    const decoder = new TextDecoder();
+    let buffer = "";
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) {
+        break;
+      }
+      buffer += decoder.decode(value, { stream: true });
+      const lines = buffer.split("\n");
+      buffer = lines.pop();
+      lines.filter((line) => line.trim()).forEach((line) => handle(JSON.parse(line)));
+    }
+    if (buffer.trim()) {
+      handle(JSON.parse(buffer));
+    }
+  }
+
+  async function errorMessage(resp) {
+    const body = await resp.text();
+    try {
+      return JSON.parse(body).error.message;
+    } catch (e) {
+      return body || resp.statusText;
+    }
+  }
+
+  function showMessage(text, isError) {
+    message.textContent = text;
+    message.classList.toggle("error", Boolean(isError));
+    message.hidden = false;
+  }
+
+  function showProgress(event) {
+    const forward = event.forward_pages || 0;
+    const backward = event.backward_pages || 0;
+    const total = Math.max(forward + backward, 1);
+    document.getElementById("forward-pages").textContent = forward;
+    document.getElementById("backward-pages").textContent = backward;
+    document.getElementById("elapsed").textContent = event.elapsed;
+    document.getElementById("forward-bar").style.width = (100 * forward / total) + "%";
+    document.getElementById("backward-bar").style.width = (100 * backward / total) + "%";
+  }
+
+  // showPath reveals the pages of path one after the other.
+  function showPath(path, isGuess) {
+    pathList.replaceChildren(...path.map(function (title, i) {
+      const item = document.createElement("li");
+      item.style.animationDelay = (i * 250) + "ms";
+      item.classList.toggle("guess", Boolean(isGuess));
+      const link = document.createElement("a");
+      link.href = pageURL(title);
+      link.target = "_blank";
+      link.rel = "noopener";
+      link.textContent = title;
+      item.appendChild(link);
+      return item;
+    }));
+  }
+
+  function showResult(result) {
+    if (result.path.length > 0) {
+      showMessage("Found a path of " + (result.path.length - 1) + " links in " + result.time_taken +
+        (result.id ? "." : ", which was found before."));
+      showPath(result.path, false);
+    } else {
+      const guess = result.partial && result.partial.best_guess;
+      showMessage(result.message + (guess ? ". The closest pages found suggest this path:" : "."));
+      if (guess) {
+        showPath(guess, true);
+      }
+    }
+    if (result.id) {
+      drawGraph(result.id);
+    }
+  }
+
+  async function race(event) {
+    event.preventDefault();
+    if (graph) {
+      graph.stop();
+    }
+    document.getElementById("graph").hidden = true;
+    pathList.replaceChildren();
+    message.hidden = true;
+    showProgress({ elapsed: "0s" });
+    document.getElementById("progress").hidden = false;
+    raceButton.disabled = true;
+    stopButton.disabled = false;
+    controller = new AbortController();
+
+    const params = new URLSearchParams({
+      starttitle: startInput.value.trim(),
+      endtitle: endInput.value.trim(),
+      timelimit: document.getElementById("timelimit").value,
+      stream: "1",
+      graph: "1",
+    });
+    try {
+      const resp = await fetch("/race?" + params, { headers: headers(), signal: controller.signal });
+      if (!resp.ok) {
+        showMessage(await errorMessage(resp), true);
+        return;
+      }
+      await readLines(resp.body, function (event) {
+        if (event.type === "progress") {
+          showProgress(event);
+        } else if (event.type === "result") {
+          showResult(event.result);
+        } else if (event.type === "error") {
+          showMessage(event.error.message, true);
+        }
+      });
+    } catch (e) {
+      if (e.name === "AbortError") {
+        showMessage("The race was stopped.");
+      } else {
+        showMessage("The race failed: " + e.message, true);
+      }
+    } finally {
+      raceButton.disabled = false;
+      stopButton.disabled = true;
+      controller = null;
+    }
+  }
+
+  async function drawGraph(id) {
+    const resp = await fetch("/races/" + id + "/graph?format=jsonl&maxnodes=" + maxGraphNodes, { headers: headers() });
+    if (!resp.ok) {
+      return;
+    }
+    let summary = null;
+    const edges = [];
+    await readLines(resp.body, function (line) {
+      if (line.type === "race") {
+        summary = line;
+      } else {
+        edges.push(line);
+      }
+    });
+    document.getElementById("graph-note").textContent = summary.truncated ?
+      "(only the first " + maxGraphNodes + " pages are shown)" : "";
+    document.getElementById("graph").hidden = false;
+    graph = new ForceGraph(canvas, summary, edges);
+    graph.start();
+  }
+
+  // A ForceGraph lays out the pages explored by a race by pushing pages apart
+  // and pulling linked pages together, with the start page on the left and the
+  // end page on the right.
+  function ForceGraph(canvas, summary, edges) {
+    this.canvas = canvas;
+    this.context = canvas.getContext("2d");
+    this.summary = summary;
+    this.edges = edges;
+    this.nodes = new Map();
+    this.alpha = 1;
+    this.frame = null;
+
+    const width = canvas.width;
+    const height = canvas.height;
+    const onPath = new Set(summary.path);
+    const node = (title, forward) => {
+      if (!this.nodes.has(title)) {
+        this.nodes.set(title, {
+          title: title,
+          forward: forward,
+          onPath: onPath.has(title),
+          x: (forward ? 0.25 : 0.75) * width + (Math.random() - 0.5) * width * 0.3,
+          y: height / 2 + (Math.random() - 0.5) * height * 0.6,
+          vx: 0,
+          vy: 0,
+        });
+      }
+      return this.nodes.get(title);
+    };
+    node(summary.start, true);
+    node(summary.end, false);
+    summary.path.forEach(function (title, i) {
+      const n = node(title, true);
+      n.x = width * (0.1 + 0.8 * i / Math.max(summary.path.length - 1, 1));
+      n.y = height / 2;
+    });
+    edges.forEach(function (edge) {
+      edge.source = node(edge.from, edge.forward);
+      edge.target = node(edge.to, edge.forward);
+    });
+    this.list = Array.from(this.nodes.values());
+
+    canvas.onmousemove = (event) => this.hover(event);
+    canvas.onmouseleave = () => {
+      tooltip.hidden = true;
+    };
+    canvas.onclick = (event) => {
+      const n = this.nodeAt(event);
+      if (n) {
+        window.open(pageURL(n.title), "_blank", "noopener");
+      }
+    };
+  }
+
+  ForceGraph.prototype.start = function () {
+    const step = () => {
+      this.tick();
+      this.draw();
+      if (this.alpha > 0.02) {
+        this.frame = requestAnimationFrame(step);
+      }
+    };
+    this.frame = requestAnimationFrame(step);
+  };
+
+  ForceGraph.prototype.stop = function () {
+    cancelAnimationFrame(this.frame);
+  };
+
+  ForceGraph.prototype.tick = function () {
+    const nodes = this.list;
+    const width = this.canvas.width;
+    const height = this.canvas.height;
+    const alpha = this.alpha;
+
+    for (let i = 0; i < nodes.length; i++) {
+      for (let j = i + 1; j < nodes.length; j++) {
+        const a = nodes[i];
+        const b = nodes[j];
+        const dx = b.x - a.x;
+        const dy = b.y - a.y;
+        const distance2 = Math.max(dx * dx + dy * dy, 25);
+        const force = 400 * alpha / distance2;
+        a.vx -= dx * force;
+        a.vy -= dy * force;
+        b.vx += dx * force;
+        b.vy += dy * force;
+      }
+    }
+    this.edges.forEach(function (edge) {
+      const dx = edge.target.x - edge.source.x;
+      const dy = edge.target.y - edge.source.y;
+      const distance = Math.sqrt(dx * dx + dy * dy) || 1;
+      const force = (distance - 30) / distance * 0.05 * alpha;
+      edge.source.vx += dx * force;
+      edge.source.vy += dy * force;
+      edge.target.vx -= dx * force;
+      edge.target.vy -= dy * force;
+    });
+
+    const start = this.nodes.get(this.summary.start);
+    const end = this.nodes.get(this.summary.end);
+    nodes.forEach(function (n) {
+      n.vx += (width / 2 - n.x) * 0.002 * alpha;
+      n.vy += (height / 2 - n.y) * 0.004 * alpha;
+    });
+    start.vx += (width * 0.08 - start.x) * 0.1;
+    start.vy += (height / 2 - start.y) * 0.1;
+    end.vx += (width * 0.92 - end.x) * 0.1;
+    end.vy += (height / 2 - end.y) * 0.1;
+
+    nodes.forEach(function (n) {
+      n.vx *= 0.6;
+      n.vy *= 0.6;
+      n.x = Math.min(Math.max(n.x + n.vx, 5), width - 5);
+      n.y = Math.min(Math.max(n.y + n.vy, 5), height - 5);
+    });
+    this.alpha *= 0.99;
+  };
+
+  ForceGraph.prototype.draw = function () {
+    const context = this.context;
+    context.clearRect(0, 0, this.canvas.width, this.canvas.height);
+
+    this.edges.forEach(function (edge) {
+      context.beginPath();
+      context.moveTo(edge.source.x, edge.source.y);
+      context.lineTo(edge.target.x, edge.target.y);
+      if (edge.on_path) {
+        context.strokeStyle = "#c62828";
+        context.lineWidth = 2.5;
+      } else {
+        context.strokeStyle = edge.forward ? "rgba(53, 114, 176, 0.25)" : "rgba(224, 138, 30, 0.25)";
+        context.lineWidth = 1;
+      }
+      context.stroke();
+    });
+
+    this.list.forEach(function (n) {
+      context.beginPath();
+      context.arc(n.x, n.y, n.onPath ? 6 : 3, 0, 2 * Math.PI);
+      context.fillStyle = n.onPath ? "#c62828" : n.forward ? "#3572b0" : "#e08a1e";
+      context.fill();
+    });
+
+    context.fillStyle = "#222";
+    context.font = "12px sans-serif";
+    context.textAlign = "center";
+    this.list.forEach(function (n) {
+      if (n.onPath || n.title === this.summary.start || n.title === this.summary.end) {
+        context.fillText(n.title, n.x, n.y - 10);
+      }
+    }, this);
+  };
+
+  // nodeAt returns the page under the mouse, if any.
+  ForceGraph.prototype.nodeAt = function (event) {
+    const scale = this.canvas.width / this.canvas.clientWidth;
+    const x = event.offsetX * scale;
+    const y = event.offsetY * scale;
+    let closest = null;
+    let closestDistance = 64;
+    this.list.forEach(function (n) {
+      const distance = (n.x - x) * (n.x - x) + (n.y - y) * (n.y - y);
+      if (distance < closestDistance) {
+        closest = n;
+        closestDistance = distance;
+      }
+    });
+    return closest;
+  };
+
+  ForceGraph.prototype.hover = function (event) {
+    const n = this.nodeAt(event);
+    this.canvas.style.cursor = n ? "pointer" : "default";
+    if (!n) {
+      tooltip.hidden = true;
+      return;
+    }
+    tooltip.textContent = n.title;
+    tooltip.style.left = (this.canvas.offsetLeft + event.offsetX + 12) + "px";
+    tooltip.style.top = (this.canvas.offsetTop + event.offsetY + 12) + "px";
+    tooltip.hidden = false;
+  };
+
+  autocomplete(startInput, document.getElementById("start-titles"));
+  autocomplete(endInput, document.getElementById("end-titles"));
+  form.addEventListener("submit", race);
+  stopButton.addEventListener("click", function () {
+    if (controller) {
+      controller.abort();
+    }
+  });
+})();
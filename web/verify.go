@@ -0,0 +1,57 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sandlerben/wikiracer/race"
+)
+
+// verifyOutput is the response of the verify endpoint.
+type verifyOutput struct {
+	Valid bool `json:"valid"`
+	// the index of the first page which doesn't link to the next one, or -1
+	BrokenHop int    `json:"broken_hop"`
+	Message   string `json:"message,omitempty"`
+}
+
+// verifyHandler returns a handler which checks that every page of a path links
+// to the next one, like the VerifyPath gRPC method. It is parameterized by
+// verifyPath to enable mock testing.
+func verifyHandler(verifyPath func(path []string, c race.Config) (int, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		path := strings.Split(query.Get("path"), "|")
+		if len(path) < 2 {
+			writeError(w, invalidInput("path must have at least two pages separated by |"))
+			return
+		}
+		config, err := parseConfig(query)
+		if err != nil {
+			writeError(w, invalidInput(err.Error()))
+			return
+		}
+		if query.Get("namespaces") == "" {
+			// paths may go through any namespace unless told otherwise
+			config.Namespaces = nil
+		}
+
+		brokenHop, err := verifyPath(path, config)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		output := verifyOutput{Valid: brokenHop < 0, BrokenHop: brokenHop}
+		if !output.Valid {
+			output.Message = fmt.Sprintf("%s does not link to %s", path[brokenHop], path[brokenHop+1])
+		}
+		jsonOutput, err := json.MarshalIndent(output, "", "    ")
+		if err != nil {
+			log.Panic(err)
+		}
+		w.Write(jsonOutput)
+	}
+}
@@ -6,28 +6,45 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	logMiddleware "github.com/bakins/logrus-middleware"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/sandlerben/wikiracer/race"
 )
 
 var requestCache map[requestInfo][]string
-var timeLimit time.Duration
+
+// guards requestCache, which is shared by concurrent races
+var requestCacheLock sync.RWMutex
+
+// the largest time limit and number of workers a client may ask for
+var maxTimeLimit time.Duration
+var maxWorkers int
 
 func init() {
 	requestCache = make(map[requestInfo][]string)
 
-	if timeLimitString, ok := os.LookupEnv("WIKIRACER_TIME_LIMIT"); ok {
-		var err error
-		if timeLimit, err = time.ParseDuration(timeLimitString); err != nil {
+	var err error
+	maxTimeLimit = 5 * time.Minute
+	if maxTimeLimitString, ok := os.LookupEnv("WIKIRACER_MAX_TIME_LIMIT"); ok {
+		if maxTimeLimit, err = time.ParseDuration(maxTimeLimitString); err != nil {
+			log.Panic(err)
+		}
+	}
+	maxWorkers = 50
+	if maxWorkersString, ok := os.LookupEnv("WIKIRACER_MAX_WORKERS"); ok {
+		if maxWorkers, err = strconv.Atoi(maxWorkersString); err != nil {
 			log.Panic(err)
 		}
-	} else {
-		timeLimit = 1 * time.Minute
 	}
 }
 
@@ -45,6 +62,174 @@ var routes = []route{
 		"/race",
 		raceHandler(race.NewRacer),
 	},
+	route{
+		"batch",
+		"POST",
+		"/races/batch",
+		batchHandler(race.NewRacer),
+	},
+	route{
+		"verify",
+		"GET",
+		"/verify",
+		verifyHandler(race.VerifyPath),
+	},
+	route{
+		"suggest",
+		"GET",
+		"/suggest",
+		suggestHandler(race.Suggest),
+	},
+	route{
+		"distance",
+		"GET",
+		"/distance",
+		distanceHandler(race.Distances),
+	},
+	route{
+		"neighborhood",
+		"GET",
+		"/neighborhood",
+		neighborhoodHandler(race.Neighborhood),
+	},
+	route{
+		"challengeToday",
+		"GET",
+		"/challenge/today",
+		challengeDateHandler(challenges),
+	},
+	route{
+		"challengeRandom",
+		"GET",
+		"/challenge/random",
+		challengeRandomHandler(challenges),
+	},
+	route{
+		"challengeDate",
+		"GET",
+		"/challenge/{date:[0-9]{4}-[0-9]{2}-[0-9]{2}}",
+		challengeDateHandler(challenges),
+	},
+	route{
+		"createGame",
+		"POST",
+		"/games",
+		games.createGameHandler,
+	},
+	route{
+		"game",
+		"GET",
+		"/games/{id}",
+		games.getGameHandler,
+	},
+	route{
+		"click",
+		"POST",
+		"/games/{id}/clicks",
+		games.clickHandler,
+	},
+	route{
+		"createRoom",
+		"POST",
+		"/rooms",
+		rooms.createRoomHandler,
+	},
+	route{
+		"room",
+		"GET",
+		"/rooms/{id}",
+		rooms.getRoomHandler,
+	},
+	route{
+		"roomSocket",
+		"GET",
+		"/rooms/{id}/ws",
+		rooms.roomSocketHandler,
+	},
+	route{
+		"history",
+		"GET",
+		"/history",
+		historyHandler,
+	},
+	route{
+		"leaderboard",
+		"GET",
+		"/leaderboard",
+		leaderboardHandler,
+	},
+	route{
+		"graph",
+		"GET",
+		"/races/{id}/graph",
+		graphHandler,
+	},
+	route{
+		"snapshot",
+		"GET",
+		"/races/{id:[0-9a-f]+}/snapshot",
+		snapshotHandler,
+	},
+	route{
+		"resume",
+		"POST",
+		"/races/{id:[0-9a-f]+}/resume",
+		resumeHandler(race.Resume),
+	},
+	route{
+		"lease",
+		"POST",
+		"/cluster/lease",
+		requireNodeKey(leaseHandler),
+	},
+	route{
+		"report",
+		"POST",
+		"/cluster/races/{id}/report",
+		requireNodeKey(reportHandler),
+	},
+	route{
+		"listKeys",
+		"GET",
+		"/admin/keys",
+		requireAdmin(listKeysHandler),
+	},
+	route{
+		"createKey",
+		"POST",
+		"/admin/keys",
+		requireAdmin(createKeyHandler),
+	},
+	route{
+		"updateKey",
+		"PUT",
+		"/admin/keys/{id}",
+		requireAdmin(updateKeyHandler),
+	},
+	route{
+		"deleteKey",
+		"DELETE",
+		"/admin/keys/{id}",
+		requireAdmin(deleteKeyHandler),
+	},
+	route{
+		"home",
+		"GET",
+		"/",
+		homeHandler,
+	},
+	route{
+		"ui",
+		"GET",
+		"/ui/{file:.*}",
+		uiHandler(),
+	},
+	route{
+		"openapi",
+		"GET",
+		"/openapi.json",
+		openAPIHandler,
+	},
 	route{
 		"health",
 		"GET",
@@ -58,67 +243,358 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "OK :)")
 }
 
+// enrichPath describes the pages and hops of a path for /race?enrich=1. It is
+// a variable to enable mock testing.
+var enrichPath = race.EnrichPath
+
+// requestInfo identifies the paths in requestCache. A path found exploring
+// some namespaces isn't a valid answer for a race which explores others.
 type requestInfo struct {
 	startTitle string
 	endTitle   string
+	namespaces string
+}
+
+// newRequestInfo returns the requestInfo of a race with namespaces.
+func newRequestInfo(startTitle string, endTitle string, namespaces []int) requestInfo {
+	key := "*"
+	if len(namespaces) > 0 {
+		sorted := append([]int(nil), namespaces...)
+		sort.Ints(sorted)
+		parts := make([]string, len(sorted))
+		for i, ns := range sorted {
+			parts[i] = strconv.Itoa(ns)
+		}
+		key = strings.Join(parts, "|")
+	}
+	return requestInfo{startTitle: startTitle, endTitle: endTitle, namespaces: key}
+}
+
+// parseConfig builds the race.Config for a request. Parameters which are not
+// supplied fall back to race.DefaultConfig and parameters which exceed the
+// server maximums are capped.
+func parseConfig(query url.Values) (race.Config, error) {
+	config := race.DefaultConfig()
+	var err error
+
+	if timeLimit := query.Get("timelimit"); timeLimit != "" {
+		if config.TimeLimit, err = time.ParseDuration(timeLimit); err != nil || config.TimeLimit <= 0 {
+			return config, fmt.Errorf("timelimit must be a positive duration, got %q", timeLimit)
+		}
+	}
+	if forwardWorkers := query.Get("forwardworkers"); forwardWorkers != "" {
+		if config.NumForwardLinksRoutines, err = strconv.Atoi(forwardWorkers); err != nil || config.NumForwardLinksRoutines <= 0 {
+			return config, fmt.Errorf("forwardworkers must be a positive integer, got %q", forwardWorkers)
+		}
+	}
+	if backwardWorkers := query.Get("backwardworkers"); backwardWorkers != "" {
+		if config.NumBackwardLinksRoutines, err = strconv.Atoi(backwardWorkers); err != nil || config.NumBackwardLinksRoutines <= 0 {
+			return config, fmt.Errorf("backwardworkers must be a positive integer, got %q", backwardWorkers)
+		}
+	}
+	if allLinks := query.Get("alllinks"); allLinks != "" {
+		if config.ExploreAllLinks, err = strconv.ParseBool(allLinks); err != nil {
+			return config, fmt.Errorf("alllinks must be a boolean, got %q", allLinks)
+		}
+	}
+	if seed := query.Get("seed"); seed != "" {
+		if config.Seed, err = strconv.ParseInt(seed, 10, 64); err != nil || config.Seed == 0 {
+			return config, fmt.Errorf("seed must be a non-zero integer, got %q", seed)
+		}
+	} else {
+		// pick the seed here so that it can be reported back to the client
+		config.Seed = time.Now().UnixNano()
+	}
+	if deterministic := query.Get("deterministic"); deterministic != "" {
+		if config.Deterministic, err = strconv.ParseBool(deterministic); err != nil {
+			return config, fmt.Errorf("deterministic must be a boolean, got %q", deterministic)
+		}
+	}
+	if edges := query.Get("edges"); edges != "" {
+		for _, part := range strings.Split(edges, "|") {
+			kind, err := race.ParseEdgeKind(part)
+			if err != nil {
+				return config, fmt.Errorf("edges must be link, category, template or transclusion separated by |, got %q", edges)
+			}
+			config.Edges = append(config.Edges, kind)
+		}
+	}
+	if namespaces := query.Get("namespaces"); namespaces == "*" {
+		config.Namespaces = nil
+	} else if namespaces != "" {
+		config.Namespaces = nil
+		for _, part := range strings.Split(namespaces, "|") {
+			ns, err := strconv.Atoi(part)
+			if err != nil {
+				return config, fmt.Errorf("namespaces must be integers separated by |, got %q", namespaces)
+			}
+			config.Namespaces = append(config.Namespaces, ns)
+		}
+	}
+
+	if config.TimeLimit > maxTimeLimit {
+		config.TimeLimit = maxTimeLimit
+	}
+	if config.NumForwardLinksRoutines > maxWorkers {
+		config.NumForwardLinksRoutines = maxWorkers
+	}
+	if config.NumBackwardLinksRoutines > maxWorkers {
+		config.NumBackwardLinksRoutines = maxWorkers
+	}
+	return config, nil
+}
+
+// configOutput describes the effective configuration of a race so that clients
+// can reproduce it.
+func configOutput(config race.Config) map[string]interface{} {
+	namespaces := config.Namespaces
+	if namespaces == nil {
+		namespaces = []int{}
+	}
+	edges := config.Edges
+	if len(edges) == 0 {
+		edges = []race.EdgeKind{race.LinkEdge}
+	}
+	return map[string]interface{}{
+		"time_limit":       config.TimeLimit.String(),
+		"forward_workers":  config.NumForwardLinksRoutines,
+		"backward_workers": config.NumBackwardLinksRoutines,
+		"all_links":        config.ExploreAllLinks,
+		"namespaces":       namespaces,
+		"edges":            edges,
+		"seed":             config.Seed,
+		"deterministic":    config.Deterministic,
+	}
+}
+
+// raceResult is the outcome of a race, whether it was run or served from
+// requestCache.
+type raceResult struct {
+	// identifies the race; empty if the path came from requestCache
+	id   string
+	path []string
+	// the kind of edge each hop of path follows
+	edges   []race.EdgeKind
+	elapsed time.Duration
+	config  race.Config
+	// true if the race was stopped before it found a path because the server
+	// is shutting down
+	stopped bool
+	// how far the race got, if it didn't find a path and its racer is a
+	// race.Partialer
+	partial *race.Partial
+	// true if the search state of the race was saved so that it can be
+	// resumed
+	checkpointed bool
+	// the id of the race this race continues, if it was resumed
+	resumedFrom string
+}
+
+// runRace finds a path from startTitle to endTitle, using requestCache unless
+// noCache is set. Races which follow edges other than links bypass the cache,
+// which only holds paths made of links. If checkpoint is set, the search state
+// of a race which doesn't find a path is saved in snapshots. If observe is not
+// nil, it is called in a new goroutine with the racer once the race starts;
+// done is closed when the race ends and runRace waits for observe to return.
+// Both the HTTP and the gRPC servers run races through runRace.
+func runRace(newRacer func(a, b string, c race.Config) race.Racer, startTitle string, endTitle string,
+	config race.Config, noCache bool, keepGraph bool, checkpoint bool, observe func(racer race.Racer, done <-chan struct{})) (raceResult, error) {
+	result := raceResult{config: config}
+	start := time.Now()
+	currentRequestInfo := newRequestInfo(startTitle, endTitle, config.Namespaces)
+
+	noCache = noCache || !config.LinksOnly()
+	requestCacheLock.RLock()
+	path, ok := requestCache[currentRequestInfo]
+	requestCacheLock.RUnlock()
+	if !ok || noCache {
+		workers := budget.acquire(workersNeeded(config))
+		defer budget.release(workers)
+		// racers are only built once they may run, since each one allocates
+		// its frontiers up front
+		racer := newRacer(startTitle, endTitle, config)
+		if !inFlight.add(racer) {
+			return result, &race.Error{Kind: race.ShuttingDown, Message: "the server is shutting down"}
+		}
+		defer inFlight.remove(racer)
+		if resolver, ok := racer.(race.Resolver); ok {
+			if err := resolveTitles(resolver, config); err != nil {
+				return result, err
+			}
+		}
+
+		result.id = newRaceID()
+		done := make(chan struct{})
+		observed := make(chan struct{})
+		if observe != nil {
+			go func() {
+				observe(racer, done)
+				close(observed)
+			}()
+		} else {
+			close(observed)
+		}
+		var err error
+		path, err = racer.Run()
+		close(done)
+		<-observed
+		result.stopped = inFlight.stopped(racer) && path == nil && err == nil
+		recordErr := err
+		if result.stopped {
+			// the race didn't run out of time, so it isn't a timeout
+			recordErr = &race.Error{Kind: race.ShuttingDown, Message: stoppedMessage}
+		}
+		recordRace(result.id, startTitle, endTitle, config, racer, path, recordErr, time.Since(start))
+		if err != nil {
+			return result, err
+		}
+		if path != nil && config.LinksOnly() {
+			requestCacheLock.Lock()
+			requestCache[currentRequestInfo] = path
+			requestCacheLock.Unlock()
+		}
+		if edger, ok := racer.(race.Edger); ok {
+			result.edges = edger.EdgeKinds()
+		}
+		if grapher, ok := racer.(race.Grapher); ok && keepGraph {
+			graphs.put(result.id, grapher.Graph(maxGraphNodes))
+		}
+		if partialer, ok := racer.(race.Partialer); ok && path == nil {
+			result.partial = partialer.Partial()
+		}
+		if checkpoint && path == nil {
+			result.checkpointed = saveSnapshot(result.id, racer)
+		}
+	}
+
+	result.path = path
+	if path != nil && result.edges == nil {
+		// cached paths and paths found by racers which aren't Edgers are
+		// made of links
+		result.edges = make([]race.EdgeKind, len(path)-1)
+		for i := range result.edges {
+			result.edges[i] = race.LinkEdge
+		}
+	}
+	result.elapsed = time.Since(start)
+	if path == nil && !result.stopped {
+		result.elapsed = config.TimeLimit
+	}
+	return result, nil
 }
 
 // raceHandler returns a handler for the race endpoint which uses the supplied
 // race.Racer. The raceHandler is parameterized in this way to enable mock
 // testing.
-func raceHandler(newRacer func(a, b string, c time.Duration) race.Racer) func(http.ResponseWriter, *http.Request) {
+func raceHandler(newRacer func(a, b string, c race.Config) race.Racer) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		startTitle := r.URL.Query().Get("starttitle")
 		endTitle := r.URL.Query().Get("endtitle")
 		forceNoCache := r.URL.Query().Get("nocache")
+		keepGraph := r.URL.Query().Get("graph")
+		checkpoint := r.URL.Query().Get("checkpoint")
 		if startTitle == "" || endTitle == "" {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			io.WriteString(w, "Must pass start and end arguments.")
+			writeError(w, invalidInput("Must pass start and end arguments."))
 			return
 		} else if startTitle == endTitle {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			io.WriteString(w, "starttitle cannot equal endtitle")
+			writeError(w, invalidInput("starttitle cannot equal endtitle"))
 			return
 		}
-		racer := newRacer(startTitle, endTitle, timeLimit)
-		start := time.Now()
-		currentRequestInfo := requestInfo{startTitle: startTitle, endTitle: endTitle}
-
-		path, ok := requestCache[currentRequestInfo]
-		if !ok || forceNoCache == "1" {
-			var err error
-			path, err = racer.Run()
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				io.WriteString(w, "An unexpected error has occurred:\n")
-				io.WriteString(w, err.Error())
+		config, err := parseConfig(r.URL.Query())
+		if err != nil {
+			writeError(w, invalidInput(err.Error()))
+			return
+		}
+		racerFor := newRacer
+		if r.URL.Query().Get("distributed") == "1" {
+			if !config.LinksOnly() {
+				writeError(w, invalidInput("distributed races only follow links"))
+				return
+			} else if len(nodeKeys) == 0 {
+				writeError(w, invalidInput("distributed races need WIKIRACER_NODE_KEYS to be set"))
 				return
 			}
-			if path != nil {
-				requestCache[currentRequestInfo] = path
-			}
+			racerFor = hub.NewRacer
+			// the nodes explore the pages, so the race runs no workers here
+			// and takes none from the budget
+			config.NumForwardLinksRoutines = 0
+			config.NumBackwardLinksRoutines = 0
+			config.Deterministic = false
 		}
 
-		elapsed := time.Since(start)
-		var output map[string]interface{}
-		if path != nil {
-			output = map[string]interface{}{
-				"path":       path,
-				"time_taken": elapsed.String(),
-			}
-		} else {
-			output = map[string]interface{}{
-				"path":       []string{},
-				"message":    fmt.Sprintf("no path found within %s", timeLimit),
-				"time_taken": timeLimit.String(),
-			}
+		if r.URL.Query().Get("stream") == "1" {
+			streamRace(w, r, racerFor, startTitle, endTitle, config, forceNoCache == "1", keepGraph == "1", checkpoint == "1")
+			return
 		}
-		jsonOutput, err := json.MarshalIndent(output, "", "    ")
+		result, err := runRace(racerFor, startTitle, endTitle, config, forceNoCache == "1", keepGraph == "1", checkpoint == "1", cancelOnDisconnect(r))
 		if err != nil {
-			log.Panic(err)
+			writeError(w, err)
+			return
+		}
+
+		writeRaceResult(w, r, result)
+	}
+}
+
+// writeRaceResult writes the response to a race, which r may ask to enrich.
+func writeRaceResult(w http.ResponseWriter, r *http.Request, result raceResult) {
+	jsonOutput, err := json.MarshalIndent(raceOutput(r, result), "", "    ")
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Write(jsonOutput)
+}
+
+// raceOutput describes the outcome of a race, which r may ask to enrich.
+func raceOutput(r *http.Request, result raceResult) map[string]interface{} {
+	config := result.config
+	var output map[string]interface{}
+	if result.path != nil {
+		output = map[string]interface{}{
+			"path":       result.path,
+			"edges":      result.edges,
+			"time_taken": result.elapsed.String(),
+			"config":     configOutput(config),
+		}
+	} else if result.stopped {
+		output = map[string]interface{}{
+			"path":       []string{},
+			"message":    stoppedMessage,
+			"time_taken": result.elapsed.String(),
+			"config":     configOutput(config),
+		}
+	} else {
+		output = map[string]interface{}{
+			"path":       []string{},
+			"message":    fmt.Sprintf("no path found within %s", config.TimeLimit),
+			"time_taken": config.TimeLimit.String(),
+			"config":     configOutput(config),
 		}
-		w.Write(jsonOutput)
 	}
+	if result.id != "" {
+		output["id"] = result.id
+	}
+	if result.partial != nil {
+		output["partial"] = result.partial
+	}
+	if result.checkpointed {
+		output["checkpointed"] = true
+	}
+	if result.resumedFrom != "" {
+		output["resumed_from"] = result.resumedFrom
+	}
+	if result.path != nil && r.URL.Query().Get("enrich") == "1" {
+		enriched, err := enrichPath(result.path, r.URL.Query().Get("extracts") == "1", config)
+		if err != nil {
+			// the path is still worth returning
+			log.Errorf("%+v", err)
+			output["enrich_error"] = err.Error()
+		} else {
+			output["pages"] = enriched.Pages
+			output["hops"] = enriched.Hops
+		}
+	}
+	return output
 }
 
 // NewRouter creates and returns a mux.Router with default routes.
@@ -137,12 +613,22 @@ func NewRouter() *mux.Router {
 }
 
 // ApplyMiddleware wraps the router in some middleware. This middleware includes
-// logging and gzip compression.
+// logging, gzip compression and, once LoadKeys has been called, API key
+// authentication.
 func ApplyMiddleware(router http.Handler) http.Handler {
 	loggingHandler := func(h http.Handler) http.Handler {
 		m := new(logMiddleware.Middleware)
 		return m.Handler(h, "")
 	}
-	middlewareRouter := loggingHandler(router)
-	return middlewareRouter
+	authenticated := authenticate(router)
+	middlewareRouter := loggingHandler(authenticated)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the logging middleware's ResponseWriter can't be hijacked, which
+		// WebSocket connections need
+		if websocket.IsWebSocketUpgrade(r) {
+			authenticated.ServeHTTP(w, r)
+			return
+		}
+		middlewareRouter.ServeHTTP(w, r)
+	})
 }
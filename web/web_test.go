@@ -1,14 +1,41 @@
 package web
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/sandlerben/wikiracer/client"
+	"github.com/sandlerben/wikiracer/fakewiki"
+	"github.com/sandlerben/wikiracer/game"
+	"github.com/sandlerben/wikiracer/history"
 	"github.com/sandlerben/wikiracer/mocks"
 	"github.com/sandlerben/wikiracer/race"
+	"github.com/sandlerben/wikiracer/rpc"
+	"github.com/sandlerben/wikiracer/titles"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 // Note: The tests in this file were informed by (and partially copied from)
@@ -46,16 +73,16 @@ func TestRaceHandlerMissingArgsError(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	mockNewRacer := func(a, b string, c time.Duration) race.Racer {
+	mockNewRacer := func(a, b string, c race.Config) race.Racer {
 		return new(mocks.Racer)
 	}
 	handler := http.HandlerFunc(raceHandler(mockNewRacer))
 
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusUnprocessableEntity {
+	if status := rr.Code; status != http.StatusBadRequest {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusUnprocessableEntity)
+			status, http.StatusBadRequest)
 	}
 }
 
@@ -68,7 +95,7 @@ func TestRaceHandlerErrorPropogated(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	mockRacer := new(mocks.Racer)
-	newRacer := func(a, b string, c time.Duration) race.Racer {
+	newRacer := func(a, b string, c race.Config) race.Racer {
 		return mockRacer
 	}
 	handler := http.HandlerFunc(raceHandler(newRacer))
@@ -93,7 +120,7 @@ func TestRaceHandlerNothingInCache(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	mockRacer := new(mocks.Racer)
-	newRacer := func(a, b string, c time.Duration) race.Racer {
+	newRacer := func(a, b string, c race.Config) race.Racer {
 		return mockRacer
 	}
 	handler := http.HandlerFunc(raceHandler(newRacer))
@@ -118,7 +145,7 @@ func TestRaceHandlerNothingInCacheNoPathReturned(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	mockRacer := new(mocks.Racer)
-	newRacer := func(a, b string, c time.Duration) race.Racer {
+	newRacer := func(a, b string, c race.Config) race.Racer {
 		return mockRacer
 	}
 	handler := http.HandlerFunc(raceHandler(newRacer))
@@ -136,10 +163,7 @@ func TestRaceHandlerNothingInCacheNoPathReturned(t *testing.T) {
 
 func TestRaceHandlerPathInCache(t *testing.T) {
 	requestCache = make(map[requestInfo][]string)
-	info := requestInfo{
-		startTitle: "start",
-		endTitle:   "end",
-	}
+	info := newRequestInfo("start", "end", []int{0})
 	requestCache[info] = []string{"start", "middle", "end"}
 
 	req, err := http.NewRequest("GET", "/race?starttitle=start&endtitle=end", nil)
@@ -148,9 +172,9 @@ func TestRaceHandlerPathInCache(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	mockRacer := new(mocks.Racer)
-	newRacer := func(a, b string, c time.Duration) race.Racer {
-		return mockRacer
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		t.Error("no racer should be built for a cached path")
+		return new(mocks.Racer)
 	}
 	handler := http.HandlerFunc(raceHandler(newRacer))
 
@@ -160,16 +184,36 @@ func TestRaceHandlerPathInCache(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusUnprocessableEntity)
 	}
+}
 
-	mockRacer.AssertNotCalled(t, "Run")
+func TestRaceHandlerCacheNamespaces(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	// found exploring every namespace
+	requestCache[newRequestInfo("start", "end", nil)] = []string{"start", "Category:Middle", "end"}
+
+	mockRacer := new(mocks.Racer)
+	mockRacer.On("Run").Return([]string{"start", "middle", "end"}, nil)
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		return mockRacer
+	}
+	handler := http.HandlerFunc(raceHandler(newRacer))
+	for _, query := range []string{"", "&namespaces=*"} {
+		req, err := http.NewRequest("GET", "/race?starttitle=start&endtitle=end"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	// only the race which only explores articles runs
+	mockRacer.AssertNumberOfCalls(t, "Run", 1)
+	if path := requestCache[newRequestInfo("start", "end", []int{0})]; len(path) != 3 || path[1] != "middle" {
+		t.Errorf("the path through articles should be cached separately, got %v", path)
+	}
 }
 
 func TestRaceHandlerForceIgnoreCache(t *testing.T) {
 	requestCache = make(map[requestInfo][]string)
-	info := requestInfo{
-		startTitle: "start",
-		endTitle:   "end",
-	}
+	info := newRequestInfo("start", "end", []int{0})
 	requestCache[info] = []string{"start", "middle", "end"}
 
 	req, err := http.NewRequest("GET", "/race?starttitle=start&endtitle=end&nocache=1", nil)
@@ -179,7 +223,7 @@ func TestRaceHandlerForceIgnoreCache(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	mockRacer := new(mocks.Racer)
-	newRacer := func(a, b string, c time.Duration) race.Racer {
+	newRacer := func(a, b string, c race.Config) race.Racer {
 		return mockRacer
 	}
 	handler := http.HandlerFunc(raceHandler(newRacer))
@@ -194,3 +238,2242 @@ func TestRaceHandlerForceIgnoreCache(t *testing.T) {
 
 	mockRacer.AssertNumberOfCalls(t, "Run", 1)
 }
+
+func TestRaceHandlerInvalidConfigError(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	req, err := http.NewRequest("GET", "/race?starttitle=start&endtitle=end&forwardworkers=zero", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mockRacer := new(mocks.Racer)
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		return mockRacer
+	}
+	handler := http.HandlerFunc(raceHandler(newRacer))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	mockRacer.AssertNotCalled(t, "Run")
+}
+
+func TestRaceHandlerConfigCapped(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	req, err := http.NewRequest("GET", "/race?starttitle=start&endtitle=end&timelimit=10h&forwardworkers=1000&backwardworkers=2&namespaces=0|14&alllinks=true&seed=42&deterministic=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	mockRacer := new(mocks.Racer)
+	var config race.Config
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		config = c
+		return mockRacer
+	}
+	handler := http.HandlerFunc(raceHandler(newRacer))
+	mockRacer.On("Run").Return([]string{"start", "middle", "end"}, nil)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := race.Config{
+		TimeLimit:                maxTimeLimit,
+		NumForwardLinksRoutines:  maxWorkers,
+		NumBackwardLinksRoutines: 2,
+		ExploreAllLinks:          true,
+		Namespaces:               []int{0, 14},
+		Seed:                     42,
+		Deterministic:            true,
+		APIURL:                   race.DefaultConfig().APIURL,
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("racer was created with %+v instead of %+v", config, expected)
+	}
+	if !strings.Contains(rr.Body.String(), `"forward_workers": 50`) {
+		t.Errorf("response does not contain the effective configuration: %s", rr.Body.String())
+	}
+}
+
+func TestGraphHandler(t *testing.T) {
+	graphs.put("abc", &race.Graph{
+		StartTitle: "start",
+		EndTitle:   "end",
+		Path:       []string{"start", "end"},
+		Edges:      []race.Edge{{From: "start", To: "end", Forward: true}},
+	})
+
+	router := NewRouter()
+	for _, format := range []string{"jsonl", "dot", "graphml"} {
+		req, err := http.NewRequest("GET", "/races/abc/graph?format="+format, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v",
+				format, status, http.StatusOK)
+		}
+	}
+
+	req, err := http.NewRequest("GET", "/races/missing/graph", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+// fakeWikiHandler returns a race handler whose racers query wiki instead of
+// Wikipedia.
+func fakeWikiHandler(wiki *fakewiki.Server) http.Handler {
+	return ApplyMiddleware(http.HandlerFunc(raceHandler(func(a, b string, c race.Config) race.Racer {
+		c.APIURL = wiki.APIURL()
+		return race.NewRacer(a, b, c)
+	})))
+}
+
+func TestRaceIntegration(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	g, err := fakewiki.ParseGraph(strings.NewReader(`
+		Kevin Bacon -> Footloose | Philadelphia | Hollywood
+		Footloose -> Dance
+		Dance -> Ancient Greece
+		Ancient Greece -> Plato
+		Plato -> Philosophy
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewUnstartedServer(g)
+	wiki.MaxLimit = 1
+	wiki.RateLimitEvery = 3
+	wiki.Start()
+	defer wiki.Close()
+
+	server := httptest.NewServer(fakeWikiHandler(wiki))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/race?starttitle=Kevin+Bacon&endtitle=Philosophy&alllinks=1&timelimit=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var output struct {
+		Path []string `json:"path"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Kevin Bacon", "Footloose", "Dance", "Ancient Greece", "Plato", "Philosophy"}
+	if !reflect.DeepEqual(output.Path, expected) {
+		t.Errorf("race returned %v instead of %v", output.Path, expected)
+	}
+}
+
+// TestConcurrentRaces sends the same races many times at once, so that the
+// handlers share requestCache while it is being filled. It is meant for
+// `go test -race`.
+func TestConcurrentRaces(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	g, err := fakewiki.ParseGraph(strings.NewReader(`
+		a -> b | c
+		b -> d
+		c -> d
+		d -> e
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+	server := httptest.NewServer(fakeWikiHandler(wiki))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(start string) {
+			defer wg.Done()
+			resp, err := http.Get(server.URL + "/race?starttitle=" + start + "&endtitle=e")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			var output struct {
+				Path []string `json:"path"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+				t.Error(err)
+				return
+			}
+			if len(output.Path) == 0 || output.Path[0] != start || output.Path[len(output.Path)-1] != "e" {
+				t.Errorf("race from %s returned %v", start, output.Path)
+			}
+		}([]string{"a", "b", "c"}[i%3])
+	}
+	wg.Wait()
+}
+
+func TestRaceHandlerPartial(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> a | b\na -> hub\nb -> hub\nx -> end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+	server := httptest.NewServer(fakeWikiHandler(wiki))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/race?starttitle=start&endtitle=end&timelimit=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var output struct {
+		Path    []string     `json:"path"`
+		Partial race.Partial `json:"partial"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.Path) != 0 {
+		t.Errorf("there should be no path but got %v", output.Path)
+	}
+	// hub may have been reached through a or b
+	guess := output.Partial.BestGuess
+	if len(guess) != 5 || guess[0] != "start" || !reflect.DeepEqual(guess[2:], []string{"hub", "x", "end"}) {
+		t.Errorf("the best guess should go through hub and x but got %v", guess)
+	}
+}
+
+func TestCheckpointAndResume(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	defer func(s snapshotStore) { snapshots = s }(snapshots)
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshots = dirSnapshotStore(dir)
+
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> p1\np1 -> p2\np2 -> p3\np3 -> p4\np4 -> end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewUnstartedServer(g)
+	wiki.Latency = 100 * time.Millisecond
+	wiki.Start()
+	defer wiki.Close()
+
+	router := mux.NewRouter()
+	router.Handle("/race", fakeWikiHandler(wiki))
+	router.HandleFunc("/races/{id}/snapshot", snapshotHandler)
+	router.HandleFunc("/races/{id}/resume", resumeHandler(func(s *race.Snapshot, c race.Config) (race.Racer, error) {
+		c.APIURL = wiki.APIURL()
+		return race.Resume(s, c)
+	}))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	type output struct {
+		ID           string   `json:"id"`
+		Path         []string `json:"path"`
+		Checkpointed bool     `json:"checkpointed"`
+		ResumedFrom  string   `json:"resumed_from"`
+	}
+	decode := func(resp *http.Response, err error) output {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+		var o output
+		if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+
+	timedOut := decode(http.Get(server.URL + "/race?starttitle=start&endtitle=end&timelimit=150ms&checkpoint=1"))
+	if len(timedOut.Path) != 0 || !timedOut.Checkpointed {
+		t.Fatalf("the race should time out and be checkpointed, got %+v", timedOut)
+	}
+	expected := []string{"start", "p1", "p2", "p3", "p4", "end"}
+	resumed := decode(http.Post(server.URL+"/races/"+timedOut.ID+"/resume?timelimit=10s", "application/json", nil))
+	if !reflect.DeepEqual(resumed.Path, expected) || resumed.ResumedFrom != timedOut.ID {
+		t.Errorf("the resumed race should find %v, got %+v", expected, resumed)
+	}
+
+	// another server, which doesn't have the snapshot, can resume the race
+	// from the snapshot itself
+	resp, err := http.Get(server.URL + "/races/" + timedOut.ID + "/snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots = newMemorySnapshotStore(1)
+	moved := decode(http.Post(server.URL+"/races/"+timedOut.ID+"/resume?timelimit=10s", "application/json", bytes.NewReader(snapshot)))
+	if !reflect.DeepEqual(moved.Path, expected) {
+		t.Errorf("the moved race should find %v, got %+v", expected, moved)
+	}
+
+	resp, err = http.Post(server.URL+"/races/"+timedOut.ID+"/resume", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("resuming a race without a snapshot should be a 404, got %d", resp.StatusCode)
+	}
+
+	cyclic := `{"version": 1, "starttitle": "start", "endtitle": "end",
+		"path_from_start": {"start": "", "A": "B", "B": "A"}, "path_from_end": {"end": ""}}`
+	defer func(max int64) { maxSnapshotBytes = max }(maxSnapshotBytes)
+	for _, body := range []string{cyclic, string(snapshot)} {
+		// the second snapshot is valid but too large
+		resp, err = http.Post(server.URL+"/races/"+timedOut.ID+"/resume", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("resuming an invalid snapshot should be a 400, got %d", resp.StatusCode)
+		}
+		maxSnapshotBytes = int64(len(snapshot) - 1)
+	}
+}
+
+func TestRaceIntegrationMissingPage(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	wiki := fakewiki.NewServer(fakewiki.NewGraph())
+	defer wiki.Close()
+
+	server := httptest.NewServer(fakeWikiHandler(wiki))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/race?starttitle=Nowhere&endtitle=Philosophy&timelimit=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("race for a missing page returned status %d", resp.StatusCode)
+	}
+}
+
+func TestRaceSuggestions(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	g, err := fakewiki.ParseGraph(strings.NewReader("Kevin Bacon -> Footloose\nFootloose -> Philosophy\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+
+	server := httptest.NewServer(fakeWikiHandler(wiki))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/race?starttitle=Kevin+Bacn&endtitle=Philosophy&timelimit=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output errorOutput
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound || output.Error.Kind != "page_not_found" {
+		t.Errorf("race for a mistyped page returned status %d and %+v", resp.StatusCode, output)
+	}
+	if suggestions := output.Error.Suggestions["Kevin Bacn"]; len(suggestions) == 0 || suggestions[0] != "Kevin Bacon" {
+		t.Errorf("expected Kevin Bacon to be suggested, got %+v", output.Error)
+	}
+	if !strings.Contains(output.Error.Message, "did you mean Kevin Bacon?") {
+		t.Errorf("unexpected message %q", output.Error.Message)
+	}
+}
+
+func TestSuggestHandler(t *testing.T) {
+	handler := suggestHandler(func(q string, n int, c race.Config) ([]string, error) {
+		suggestions := []string{"Kevin Bacon", "Kevin Costner"}
+		if n < len(suggestions) {
+			suggestions = suggestions[:n]
+		}
+		return suggestions, nil
+	})
+	suggest := func(query string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("GET", "/suggest?"+query, nil)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		var output map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &output)
+		return rr.Code, output
+	}
+
+	if code, output := suggest("q=kevin&limit=1"); code != http.StatusOK || output["source"] != "api" ||
+		!reflect.DeepEqual(output["suggestions"], []interface{}{"Kevin Bacon"}) {
+		t.Errorf("unexpected response %d %v", code, output)
+	}
+	for _, query := range []string{"", "q=+", "q=kevin&limit=0", "q=kevin&limit=x"} {
+		if code, _ := suggest(query); code != http.StatusBadRequest {
+			t.Errorf("%q returned status %d", query, code)
+		}
+	}
+
+	titleIndex = titles.New([]string{"Kevin Bacon", "Philosophy"})
+	defer func() { titleIndex = nil }()
+	if _, output := suggest("q=phil"); output["source"] != "index" ||
+		!reflect.DeepEqual(output["suggestions"], []interface{}{"Philosophy"}) {
+		t.Errorf("unexpected response %v", output)
+	}
+	// the index only has articles
+	if _, output := suggest("q=phil&namespaces=0|14"); output["source"] != "api" {
+		t.Errorf("unexpected response %v", output)
+	}
+}
+
+// dialGRPC serves s in memory and returns a client connected to it.
+func dialGRPC(t *testing.T, s *grpcServer) (rpc.WikiRacerClient, func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpcServerOptions()...)
+	rpc.RegisterWikiRacerServer(server, s)
+	go server.Serve(lis)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rpc.NewWikiRacerClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestGRPCRace(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	mockRacer := new(mocks.Racer)
+	mockRacer.On("Run").Return([]string{"start", "middle", "end"}, nil)
+	var config race.Config
+	client, stop := dialGRPC(t, &grpcServer{newRacer: func(a, b string, c race.Config) race.Racer {
+		config = c
+		return mockRacer
+	}})
+	defer stop()
+
+	resp, err := client.Race(context.Background(), &rpc.RaceRequest{
+		StartTitle: "start",
+		EndTitle:   "end",
+		Config:     &rpc.RaceConfig{ForwardWorkers: 1000, Namespaces: []int32{0, 14}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Path, []string{"start", "middle", "end"}) {
+		t.Errorf("Race returned path %v", resp.Path)
+	}
+	if config.NumForwardLinksRoutines != maxWorkers || !reflect.DeepEqual(config.Namespaces, []int{0, 14}) {
+		t.Errorf("racer was created with %+v", config)
+	}
+	if resp.Config.ForwardWorkers != int32(maxWorkers) {
+		t.Errorf("response should contain the capped config but contains %v", resp.Config)
+	}
+
+	// the second race is served from the cache shared with the HTTP server
+	if _, ok := requestCache[newRequestInfo("start", "end", []int{14, 0})]; !ok {
+		t.Error("the path should have been cached")
+	}
+	_, err = client.Race(context.Background(), &rpc.RaceRequest{
+		StartTitle: "start",
+		EndTitle:   "end",
+		Config:     &rpc.RaceConfig{Namespaces: []int32{14, 0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockRacer.AssertNumberOfCalls(t, "Run", 1)
+
+	_, err = client.Race(context.Background(), &rpc.RaceRequest{StartTitle: "start"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Race without an end title returned %v", err)
+	}
+}
+
+func TestGRPCRaceProgress(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> middle\nmiddle -> end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewUnstartedServer(g)
+	wiki.Latency = 20 * time.Millisecond
+	wiki.Start()
+	defer wiki.Close()
+
+	progressInterval = 5 * time.Millisecond
+	client, stop := dialGRPC(t, &grpcServer{newRacer: func(a, b string, c race.Config) race.Racer {
+		c.APIURL = wiki.APIURL()
+		return race.NewRacer(a, b, c)
+	}})
+	defer stop()
+
+	stream, err := client.RaceProgress(context.Background(), &rpc.RaceRequest{StartTitle: "start", EndTitle: "end"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var updates []*rpc.RaceProgressUpdate
+	for {
+		update, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		updates = append(updates, update)
+	}
+
+	if len(updates) < 2 {
+		t.Fatalf("expected progress updates before the result but got %v", updates)
+	}
+	if updates[0].ForwardPages < 1 || updates[0].Result != nil {
+		t.Errorf("unexpected first update %v", updates[0])
+	}
+	last := updates[len(updates)-1]
+	if last.Result == nil || !reflect.DeepEqual(last.Result.Path, []string{"start", "middle", "end"}) {
+		t.Errorf("unexpected last update %v", last)
+	}
+}
+
+func TestGRPCVerifyPath(t *testing.T) {
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> middle\nmiddle -> end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+
+	client, stop := dialGRPC(t, &grpcServer{verifyPath: func(path []string, c race.Config) (int, error) {
+		c.APIURL = wiki.APIURL()
+		return race.VerifyPath(path, c)
+	}})
+	defer stop()
+
+	resp, err := client.VerifyPath(context.Background(), &rpc.VerifyPathRequest{Path: []string{"start", "middle", "end"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Valid {
+		t.Errorf("path should be valid: %v", resp)
+	}
+
+	resp, err = client.VerifyPath(context.Background(), &rpc.VerifyPathRequest{Path: []string{"start", "end"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Valid || resp.BrokenHop != 0 {
+		t.Errorf("path should be broken at the first hop: %v", resp)
+	}
+
+	// errors keep their kind
+	rateLimited, stopRateLimited := dialGRPC(t, &grpcServer{verifyPath: func(path []string, c race.Config) (int, error) {
+		return 0, &race.Error{Kind: race.RateLimited, Message: "slow down"}
+	}})
+	defer stopRateLimited()
+	_, err = rateLimited.VerifyPath(context.Background(), &rpc.VerifyPathRequest{Path: []string{"start", "end"}})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected a ResourceExhausted error, got %v", err)
+	}
+}
+
+func TestBatchHandler(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	requestCache[newRequestInfo("cached", "end", []int{0})] = []string{"cached", "end"}
+
+	body := strings.NewReader(`{"starttitle": "start", "endtitle": "end"}
+{"starttitle": "cached", "endtitle": "end"}
+{"starttitle": "start", "endtitle": "end"}
+{"starttitle": "start"}
+`)
+	req, err := http.NewRequest("POST", "/races/batch", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	var racers []*mocks.Racer
+	var racersLock sync.Mutex
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		mockRacer := new(mocks.Racer)
+		mockRacer.On("Run").Return([]string{a, "middle", b}, nil)
+		racersLock.Lock()
+		racers = append(racers, mockRacer)
+		racersLock.Unlock()
+		return mockRacer
+	}
+	handler := http.HandlerFunc(batchHandler(newRacer))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	results := make(map[int]batchResult)
+	dec := json.NewDecoder(rr.Body)
+	for dec.More() {
+		var result batchResult
+		if err := dec.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		results[result.Index] = result
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results but got %v", results)
+	}
+	if !reflect.DeepEqual(results[0].Path, []string{"start", "middle", "end"}) || !reflect.DeepEqual(results[2].Path, results[0].Path) {
+		t.Errorf("duplicate pairs should get the same path but got %v and %v", results[0].Path, results[2].Path)
+	}
+	if !reflect.DeepEqual(results[1].Path, []string{"cached", "end"}) {
+		t.Errorf("cached pair should be served from the cache but got %v", results[1].Path)
+	}
+	if results[3].Error == "" {
+		t.Errorf("pair without an end title should fail but got %v", results[3])
+	}
+
+	runs := 0
+	for _, racer := range racers {
+		for _, call := range racer.Calls {
+			if call.Method == "Run" {
+				runs++
+			}
+		}
+	}
+	if runs != 1 || len(racers) != 1 {
+		t.Errorf("expected only one racer to be built and run but %d of %d ran", runs, len(racers))
+	}
+}
+
+// sleepyRacer counts the races running at the same time.
+type sleepyRacer struct {
+	running, most *int32
+}
+
+func (s sleepyRacer) Run() ([]string, error) {
+	n := atomic.AddInt32(s.running, 1)
+	for {
+		most := atomic.LoadInt32(s.most)
+		if n <= most || atomic.CompareAndSwapInt32(s.most, most, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	atomic.AddInt32(s.running, -1)
+	return nil, nil
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	defer func(n int) { batchConcurrency = n }(batchConcurrency)
+	batchConcurrency = 2
+	var running, most int32
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		return sleepyRacer{running: &running, most: &most}
+	}
+	var pairs []racePair
+	for i := 0; i < 8; i++ {
+		pairs = append(pairs, racePair{StartTitle: strconv.Itoa(i), EndTitle: "end"})
+	}
+	results := 0
+	runBatch(context.Background(), newRacer, pairs, race.DefaultConfig(), true, func(batchResult) { results++ })
+	if results != len(pairs) || most > 2 {
+		t.Errorf("expected %d results with at most 2 races at once, got %d results and %d races at once", len(pairs), results, most)
+	}
+}
+
+func TestBatchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		return &blockingRacer{cancelled: make(chan struct{})}
+	}
+	pairs := []racePair{{StartTitle: "a", EndTitle: "b"}, {StartTitle: "c", EndTitle: "d"}}
+	results := 0
+	runBatch(ctx, newRacer, pairs, race.DefaultConfig(), true, func(batchResult) { results++ })
+	if results != 0 {
+		t.Errorf("no pairs should be raced once the client is gone, got %d results", results)
+	}
+}
+
+func TestBatchHandlerTooLarge(t *testing.T) {
+	defer func(n int64) { maxBatchBytes = n }(maxBatchBytes)
+	maxBatchBytes = 10
+	req, err := http.NewRequest("POST", "/batch", strings.NewReader(`[{"starttitle": "a", "endtitle": "b"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(batchHandler(race.NewRacer)).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestParsePairsJSONArray(t *testing.T) {
+	pairs, err := parsePairs(strings.NewReader(`[{"starttitle": "a", "endtitle": "b"}, {"starttitle": "c", "endtitle": "d"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []racePair{{StartTitle: "a", EndTitle: "b"}, {StartTitle: "c", EndTitle: "d"}}
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("parsePairs returned %v instead of %v", pairs, expected)
+	}
+}
+
+func TestWorkerBudget(t *testing.T) {
+	b := newWorkerBudget(10)
+	if n := b.acquire(25); n != 10 {
+		t.Errorf("acquire should cap requests at the budget but took %d", n)
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		b.release(b.acquire(4))
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquire should block while the budget is used up")
+	case <-time.After(10 * time.Millisecond):
+	}
+	b.release(10)
+	<-acquired
+}
+
+func TestDistanceHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/distance?titles=a|b|a&timelimit=10s", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotTitles []string
+	var gotConfig race.Config
+	distances := func(titles []string, c race.Config) (*race.DistanceMatrix, error) {
+		gotTitles, gotConfig = titles, c
+		return &race.DistanceMatrix{
+			Titles: titles,
+			Distances: [][]race.Distance{
+				{{Hops: 0, Exact: true}, {Hops: 3, Exact: false}},
+				{{Hops: -1, Exact: true}, {Hops: 0, Exact: true}},
+			},
+		}, nil
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(distanceHandler(distances))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if !reflect.DeepEqual(gotTitles, []string{"a", "b"}) {
+		t.Errorf("duplicate titles should be removed but got %v", gotTitles)
+	}
+	if gotConfig.TimeLimit != 10*time.Second {
+		t.Errorf("time limit should be 10s but is %v", gotConfig.TimeLimit)
+	}
+
+	var output struct {
+		Distances [][]distanceOutput `json:"distances"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if d := output.Distances[0][1]; d.Hops == nil || *d.Hops != 3 || d.Exact {
+		t.Errorf("distance from a to b should be at most 3 but is %+v", d)
+	}
+	if d := output.Distances[1][0]; d.Hops != nil || !d.Exact {
+		t.Errorf("b should not reach a but the distance is %+v", d)
+	}
+}
+
+func TestDistanceHandlerTooFewTitles(t *testing.T) {
+	req, err := http.NewRequest("GET", "/distance?titles=a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(distanceHandler(race.Distances))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestDistanceHandlerPageNotFound(t *testing.T) {
+	req, err := http.NewRequest("GET", "/distance?titles=a|b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(distanceHandler(func(titles []string, c race.Config) (*race.DistanceMatrix, error) {
+		return nil, &race.Error{Kind: race.PageNotFound, Message: "the page b does not exist"}
+	}))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	var output errorOutput
+	if err := json.Unmarshal(rr.Body.Bytes(), &output); err != nil || output.Error.Kind != "page_not_found" {
+		t.Errorf("expected a page_not_found error but got %s", rr.Body.String())
+	}
+}
+
+// fakeNeighborhood visits a fixed neighborhood and records its arguments.
+type fakeNeighborhood struct {
+	title    string
+	depth    int
+	in       bool
+	maxNodes int
+}
+
+func (f *fakeNeighborhood) explore(title string, depth int, in bool, maxNodes int, c race.Config,
+	visit func(distance int, pages []string) bool) (bool, error) {
+	f.title, f.depth, f.in, f.maxNodes = title, depth, in, maxNodes
+	visit(0, []string{title})
+	visit(1, []string{"a", "b"})
+	return true, nil
+}
+
+func TestNeighborhoodHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/neighborhood?title=start&depth=100&direction=in&maxnodes=5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := new(fakeNeighborhood)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(neighborhoodHandler(f.explore))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if f.title != "start" || f.depth != maxNeighborhoodDepth || !f.in || f.maxNodes != 5 {
+		t.Errorf("neighborhood was called with the wrong arguments: %+v", f)
+	}
+
+	var output struct {
+		Levels    []neighborhoodLevel `json:"levels"`
+		Total     int                 `json:"total"`
+		Truncated bool                `json:"truncated"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	expected := []neighborhoodLevel{{0, []string{"start"}}, {1, []string{"a", "b"}}}
+	if !reflect.DeepEqual(output.Levels, expected) || output.Total != 3 || !output.Truncated {
+		t.Errorf("handler returned %+v", output)
+	}
+}
+
+func TestNeighborhoodHandlerStream(t *testing.T) {
+	req, err := http.NewRequest("GET", "/neighborhood?title=start&stream=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := new(fakeNeighborhood)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(neighborhoodHandler(f.explore))
+
+	handler.ServeHTTP(rr, req)
+
+	if f.depth != 1 || f.in {
+		t.Errorf("neighborhood should default to one hop out but got %+v", f)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected two levels and a summary but got %q", rr.Body.String())
+	}
+	var level neighborhoodLevel
+	if err := json.Unmarshal([]byte(lines[1]), &level); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(level, neighborhoodLevel{1, []string{"a", "b"}}) {
+		t.Errorf("second line should be the first level but is %+v", level)
+	}
+}
+
+func TestNeighborhoodHandlerInvalidDirection(t *testing.T) {
+	req, err := http.NewRequest("GET", "/neighborhood?title=start&direction=sideways", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(neighborhoodHandler(new(fakeNeighborhood).explore))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+// fakeWikiChallenger returns a challenger which uses wiki instead of
+// Wikipedia.
+func fakeWikiChallenger(wiki *fakewiki.Server) *challenger {
+	return &challenger{
+		randomPages: func(n int, c race.Config) ([]string, error) {
+			c.APIURL = wiki.APIURL()
+			return race.RandomPages(n, c)
+		},
+		seededPage: func(seed int64, c race.Config) (string, error) {
+			c.APIURL = wiki.APIURL()
+			return race.SeededPage(seed, c)
+		},
+		shortestDistance: func(start, end string, c race.Config) (race.Distance, error) {
+			c.APIURL = wiki.APIURL()
+			c.TimeLimit = 10 * time.Second
+			return race.ShortestDistance(start, end, c)
+		},
+		daily:      make(map[string]challenge),
+		generating: make(map[string]chan struct{}),
+	}
+}
+
+// challengeGraph is a ring of pages, so every pair of pages has a path.
+func challengeGraph(t *testing.T) *fakewiki.Graph {
+	g, err := fakewiki.ParseGraph(strings.NewReader(`
+		Apple -> Banana
+		Banana -> Cherry
+		Cherry -> Date
+		Date -> Elderberry
+		Elderberry -> Fig
+		Fig -> Grape
+		Grape -> Apple
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestDailyChallenge(t *testing.T) {
+	wiki := fakewiki.NewServer(challengeGraph(t))
+	defer wiki.Close()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/challenge/{date}", challengeDateHandler(fakeWikiChallenger(wiki)))
+	otherRouter := mux.NewRouter()
+	otherRouter.HandleFunc("/challenge/{date}", challengeDateHandler(fakeWikiChallenger(wiki)))
+
+	var challenges []challenge
+	for _, r := range []*mux.Router{router, router, otherRouter} {
+		req, err := http.NewRequest("GET", "/challenge/2024-02-29", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s",
+				status, http.StatusOK, rr.Body.String())
+		}
+
+		var ch challenge
+		if err := json.Unmarshal(rr.Body.Bytes(), &ch); err != nil {
+			t.Fatal(err)
+		}
+		challenges = append(challenges, ch)
+	}
+
+	if ch := challenges[0]; ch.Date != "2024-02-29" || ch.Hops < dailyMinHops {
+		t.Errorf("invalid daily challenge %+v", ch)
+	}
+	if challenges[1] != challenges[0] || challenges[2] != challenges[0] {
+		t.Errorf("the daily challenge should be the same every time but got %+v", challenges)
+	}
+}
+
+func TestDailyChallengeConcurrent(t *testing.T) {
+	var solves int32
+	release := make(chan struct{})
+	c := &challenger{
+		seededPage: func(seed int64, c race.Config) (string, error) {
+			return strconv.FormatInt(seed, 10), nil
+		},
+		shortestDistance: func(start, end string, c race.Config) (race.Distance, error) {
+			if atomic.AddInt32(&solves, 1) == 1 {
+				// the first solve is slow
+				<-release
+			}
+			return race.Distance{Hops: dailyMinHops, Exact: true}, nil
+		},
+		daily:      make(map[string]challenge),
+		generating: make(map[string]chan struct{}),
+	}
+
+	results := make(chan challenge, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			ch, err := c.dailyChallenge(today(), race.Config{})
+			if err != nil {
+				t.Error(err)
+			}
+			results <- ch
+		}()
+	}
+	for atomic.LoadInt32(&solves) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// another date isn't held up by the slow solve
+	if _, err := c.dailyChallenge("2024-03-01", race.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	first := <-results
+	for i := 1; i < 3; i++ {
+		if ch := <-results; ch != first {
+			t.Errorf("got different daily challenges %+v and %+v", first, ch)
+		}
+	}
+	if solves != 2 {
+		t.Errorf("expected one solve per date but got %d", solves)
+	}
+}
+
+func TestDailyChallengeKeepsToday(t *testing.T) {
+	c := &challenger{
+		seededPage: func(seed int64, c race.Config) (string, error) {
+			return strconv.FormatInt(seed, 10), nil
+		},
+		shortestDistance: func(start, end string, c race.Config) (race.Distance, error) {
+			return race.Distance{Hops: dailyMinHops, Exact: true}, nil
+		},
+		daily:      map[string]challenge{"2024-02-29": {}},
+		generating: make(map[string]chan struct{}),
+	}
+	for _, date := range []string{"2024-03-01", today()} {
+		if _, err := c.dailyChallenge(date, race.Config{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := c.daily[today()]; !ok || len(c.daily) != 1 {
+		t.Errorf("only today's challenge should be kept, got %+v", c.daily)
+	}
+}
+
+func TestRandomChallenge(t *testing.T) {
+	wiki := fakewiki.NewServer(challengeGraph(t))
+	defer wiki.Close()
+
+	req, err := http.NewRequest("GET", "/challenge/random?minhops=5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := fakeWikiChallenger(wiki)
+	solved := 0
+	shortestDistance := c.shortestDistance
+	c.shortestDistance = func(start, end string, config race.Config) (race.Distance, error) {
+		solved++
+		return shortestDistance(start, end, config)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(challengeRandomHandler(c))
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code == http.StatusServiceUnavailable {
+		// every attempt picked pages which are too close together
+		if solved != challengeAttempts {
+			t.Errorf("expected %d attempts but there were %d", challengeAttempts, solved)
+		}
+		return
+	}
+	var ch challenge
+	if err := json.Unmarshal(rr.Body.Bytes(), &ch); err != nil {
+		t.Fatal(err)
+	}
+	if ch.Hops < 5 || ch.StartTitle == ch.EndTitle {
+		t.Errorf("invalid random challenge %+v", ch)
+	}
+}
+
+// newTestGameServer returns a game server whose racer always finds
+// start -> middle -> end and whose links are given by links.
+func newTestGameServer(links map[string][]string) *gameServer {
+	return &gameServer{
+		store: game.NewMemoryStore(10),
+		newRacer: func(a, b string, c race.Config) race.Racer {
+			mockRacer := new(mocks.Racer)
+			mockRacer.On("Run").Return([]string{a, "middle", b}, nil)
+			return mockRacer
+		},
+		linksTo: func(from, to string, c race.Config) (bool, error) {
+			for _, link := range links[from] {
+				if link == to {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+	}
+}
+
+// gameRouter routes the game endpoints of g.
+func gameRouter(g *gameServer) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/games", g.createGameHandler).Methods("POST")
+	router.HandleFunc("/games/{id}", g.getGameHandler).Methods("GET")
+	router.HandleFunc("/games/{id}/clicks", g.clickHandler).Methods("POST")
+	return router
+}
+
+// gameOutput is the body of a game response.
+type gameOutput struct {
+	Game    game.Session `json:"game"`
+	Current string       `json:"current"`
+	Score   *int         `json:"score"`
+}
+
+// serveGame makes a request to router and decodes the game in the response.
+func serveGame(t *testing.T, router http.Handler, method string, url string) (int, gameOutput) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var output gameOutput
+	if rr.Code == http.StatusOK || rr.Code == http.StatusCreated {
+		if err := json.Unmarshal(rr.Body.Bytes(), &output); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr.Code, output
+}
+
+func TestGame(t *testing.T) {
+	g := newTestGameServer(map[string][]string{
+		"start": {"a", "b"},
+		"a":     {"end"},
+	})
+	router := gameRouter(g)
+
+	status, output := serveGame(t, router, "POST", "/games?starttitle=start&endtitle=end")
+	if status != http.StatusCreated {
+		t.Fatalf("creating a game returned status %d", status)
+	}
+	id := output.Game.ID
+	if output.Current != "start" {
+		t.Errorf("a new game should be on the start page but is on %s", output.Current)
+	}
+
+	if status, _ = serveGame(t, router, "POST", "/games/"+id+"/clicks?title=end"); status != http.StatusBadRequest {
+		t.Errorf("clicking a page which isn't linked returned status %d", status)
+	}
+	if status, output = serveGame(t, router, "POST", "/games/"+id+"/clicks?title=a"); status != http.StatusOK || output.Current != "a" {
+		t.Errorf("clicking a linked page returned status %d and moved to %s", status, output.Current)
+	}
+	if status, output = serveGame(t, router, "POST", "/games/"+id+"/clicks?title=end"); status != http.StatusOK || !output.Game.Finished {
+		t.Errorf("clicking the end page returned status %d and did not finish the game", status)
+	}
+	if status, _ = serveGame(t, router, "POST", "/games/"+id+"/clicks?title=a"); status != http.StatusConflict {
+		t.Errorf("clicking in a finished game returned status %d", status)
+	}
+
+	// wait for the racer
+	for i := 0; i < 100 && !output.Game.BotDone; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, output = serveGame(t, router, "GET", "/games/"+id)
+	}
+	if !reflect.DeepEqual(output.Game.BotPath, []string{"start", "middle", "end"}) {
+		t.Errorf("the racer's path should be start, middle, end but is %v", output.Game.BotPath)
+	}
+	if output.Game.InvalidClicks != 1 || output.Score == nil || *output.Score == 0 {
+		t.Errorf("invalid finished game %+v with score %v", output.Game, output.Score)
+	}
+}
+
+func TestGameBotError(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	g := newTestGameServer(nil)
+	g.newRacer = func(a, b string, c race.Config) race.Racer {
+		mockRacer := new(mocks.Racer)
+		mockRacer.On("Run").Return(nil, &race.Error{Kind: race.UpstreamUnavailable, Message: "down", Err: errors.New("dial tcp: secret")})
+		return mockRacer
+	}
+	router := gameRouter(g)
+
+	_, output := serveGame(t, router, "POST", "/games?starttitle=start&endtitle=end")
+	id := output.Game.ID
+	for i := 0; i < 100 && !output.Game.BotDone; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, output = serveGame(t, router, "GET", "/games/"+id)
+	}
+	if output.Game.BotError != "down" {
+		t.Errorf("only the message of the racer's error should be shown, got %q", output.Game.BotError)
+	}
+}
+
+func TestGameNotFound(t *testing.T) {
+	router := gameRouter(newTestGameServer(nil))
+
+	if status, _ := serveGame(t, router, "GET", "/games/nope"); status != http.StatusNotFound {
+		t.Errorf("getting a game which doesn't exist returned status %d", status)
+	}
+	if status, _ := serveGame(t, router, "POST", "/games/nope/clicks?title=a"); status != http.StatusNotFound {
+		t.Errorf("clicking in a game which doesn't exist returned status %d", status)
+	}
+}
+
+// roomTestServer serves the room endpoints of a room server whose racer always
+// finds start -> middle -> end and whose links are given by links.
+func roomTestServer(links map[string][]string) *httptest.Server {
+	g := newTestGameServer(links)
+	s := newRoomServer(g.newRacer, g.linksTo)
+	router := mux.NewRouter()
+	router.HandleFunc("/rooms", s.createRoomHandler).Methods("POST")
+	router.HandleFunc("/rooms/{id}", s.getRoomHandler).Methods("GET")
+	router.HandleFunc("/rooms/{id}/ws", s.roomSocketHandler).Methods("GET")
+	return httptest.NewServer(ApplyMiddleware(router))
+}
+
+// joinRoom connects to a room over WebSocket and returns the connection and
+// the player's token.
+func joinRoom(t *testing.T, server *httptest.Server, id string, query string) (*websocket.Conn, string) {
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/rooms/" + id + "/ws?" + query
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := readRoomMessage(t, ws, func(msg serverMessage) bool { return msg.Type == "welcome" || msg.Type == "error" })
+	if msg.Type == "error" {
+		ws.Close()
+		return nil, ""
+	}
+	return ws, msg.Token
+}
+
+// readRoomMessage reads messages from ws until one matches.
+func readRoomMessage(t *testing.T, ws *websocket.Conn, match func(serverMessage) bool) serverMessage {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg serverMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if match(msg) {
+			return msg
+		}
+	}
+}
+
+func TestRoom(t *testing.T) {
+	server := roomTestServer(map[string][]string{
+		"start": {"a", "b"},
+		"a":     {"end"},
+		"b":     {"c"},
+		"c":     {"end"},
+	})
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/rooms?starttitle=start&endtitle=end", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created roomOutput
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || created.State != roomWaiting {
+		t.Fatalf("creating a room returned status %d and %+v", resp.StatusCode, created)
+	}
+
+	alice, _ := joinRoom(t, server, created.ID, "name=alice")
+	defer alice.Close()
+	bob, bobToken := joinRoom(t, server, created.ID, "name=bob")
+	if impostor, _ := joinRoom(t, server, created.ID, "name=bob"); impostor != nil {
+		t.Error("joining with a taken name and no token should fail")
+	}
+
+	// bob isn't the host
+	bob.WriteJSON(clientMessage{Type: "start"})
+	readRoomMessage(t, bob, func(msg serverMessage) bool { return msg.Type == "error" })
+	alice.WriteJSON(clientMessage{Type: "start"})
+	readRoomMessage(t, bob, func(msg serverMessage) bool { return msg.Type == "state" && msg.Room.State == roomRacing })
+
+	// bob reconnects and continues where he left off
+	bob.WriteJSON(clientMessage{Type: "click", Title: "b"})
+	readRoomMessage(t, alice, func(msg serverMessage) bool {
+		return msg.Type == "state" && len(msg.Room.Players) == 2 && msg.Room.Players[1].Clicks == 1
+	})
+	bob.Close()
+	bob, _ = joinRoom(t, server, created.ID, "name=bob&token="+bobToken)
+	if bob == nil {
+		t.Fatal("reconnecting with the token should work")
+	}
+	defer bob.Close()
+
+	alice.WriteJSON(clientMessage{Type: "click", Title: "end"})
+	readRoomMessage(t, alice, func(msg serverMessage) bool { return msg.Type == "error" })
+	alice.WriteJSON(clientMessage{Type: "click", Title: "a"})
+	alice.WriteJSON(clientMessage{Type: "click", Title: "end"})
+	bob.WriteJSON(clientMessage{Type: "click", Title: "c"})
+	bob.WriteJSON(clientMessage{Type: "click", Title: "end"})
+
+	msg := readRoomMessage(t, alice, func(msg serverMessage) bool {
+		return msg.Type == "state" && msg.Room.State == roomFinished && msg.Room.BotPath != nil
+	})
+	var names []string
+	for _, entry := range msg.Room.Leaderboard {
+		names = append(names, entry.Name)
+	}
+	if !reflect.DeepEqual(names[2:], []string{"bob"}) || len(names) != 3 {
+		t.Errorf("bob took the most clicks and should be last, but the leaderboard is %v", names)
+	}
+	if !reflect.DeepEqual(msg.Room.BotPath, []string{"start", "middle", "end"}) {
+		t.Errorf("the racer's path should be revealed but is %v", msg.Room.BotPath)
+	}
+}
+
+func TestRoomCloseCancelsRacer(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	racer := &blockingRacer{cancelled: make(chan struct{})}
+	s := newRoomServer(func(a, b string, c race.Config) race.Racer {
+		return racer
+	}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	rm := &room{server: s, startTitle: "start", endTitle: "end", config: race.DefaultConfig(),
+		state: roomWaiting, host: "alice", players: make(map[string]*roomPlayer), ctx: ctx, cancel: cancel}
+	if err := rm.start("alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	rm.close()
+	select {
+	case <-racer.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("the racer should be cancelled once its room is closed")
+	}
+}
+
+func TestHistoryHandlers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := history.Open(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := SetHistory(store); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { raceHistory = nil }()
+
+	requestCache = make(map[requestInfo][]string)
+	mockRacer := new(mocks.Racer)
+	mockRacer.On("Run").Return([]string{"start", "middle", "end"}, nil)
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		return mockRacer
+	}
+	for _, query := range []string{"starttitle=start&endtitle=end", "starttitle=start&endtitle=end&nocache=1"} {
+		req, err := http.NewRequest("GET", "/race?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		http.HandlerFunc(raceHandler(newRacer)).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, err := http.NewRequest("GET", "/history?starttitle=start&outcome=found&limit=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(historyHandler).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var page struct {
+		Races []history.Record `json:"races"`
+		Next  string           `json:"next"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Races) != 1 || page.Next == "" {
+		t.Errorf("the cached race shouldn't be recorded and the first page should hold one of the two others, got %+v", page)
+	}
+
+	req, err = http.NewRequest("GET", "/leaderboard", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(leaderboardHandler).ServeHTTP(rr, req)
+	var leaderboard struct {
+		Longest []history.PairStats `json:"longest"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &leaderboard); err != nil {
+		t.Fatal(err)
+	}
+	if len(leaderboard.Longest) != 1 || leaderboard.Longest[0].Races != 2 {
+		t.Errorf("start to end should have been raced twice, got %+v", leaderboard.Longest)
+	}
+
+	// a restarted server should serve the recorded path from the cache
+	requestCache = make(map[requestInfo][]string)
+	if err := SetHistory(store); err != nil {
+		t.Fatal(err)
+	}
+	if path := requestCache[newRequestInfo("start", "end", []int{0})]; len(path) != 3 {
+		t.Errorf("the cache should be warmed from history but holds %v", path)
+	}
+}
+
+func TestHistoryHandlerDisabled(t *testing.T) {
+	req, err := http.NewRequest("GET", "/history", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(historyHandler).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+func TestRaceHandlerEnrich(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	req, err := http.NewRequest("GET", "/race?starttitle=start&endtitle=end&enrich=1&extracts=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotExtracts bool
+	enrichPath = func(path []string, extracts bool, c race.Config) (*race.EnrichedPath, error) {
+		gotExtracts = extracts
+		return &race.EnrichedPath{
+			Pages: []race.Page{{Title: "start", PageID: 1}, {Title: "end", PageID: 2}},
+			Hops:  []race.Hop{{From: "start", To: "end", AnchorText: "the end", Context: "Go to the end."}},
+		}, nil
+	}
+	defer func() { enrichPath = race.EnrichPath }()
+
+	rr := httptest.NewRecorder()
+	mockRacer := new(mocks.Racer)
+	mockRacer.On("Run").Return([]string{"start", "end"}, nil)
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		return mockRacer
+	}
+	http.HandlerFunc(raceHandler(newRacer)).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var output struct {
+		Pages []race.Page `json:"pages"`
+		Hops  []race.Hop  `json:"hops"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if !gotExtracts {
+		t.Error("extracts should be requested")
+	}
+	if len(output.Pages) != 2 || len(output.Hops) != 1 || output.Hops[0].AnchorText != "the end" {
+		t.Errorf("the enriched path should be returned, got %+v", output)
+	}
+}
+
+func TestRaceHandlerEdges(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	requestCache[newRequestInfo("start", "end", []int{0})] = []string{"start", "middle", "end"}
+
+	mockRacer := new(mocks.Racer)
+	mockRacer.On("Run").Return([]string{"start", "Category:Middle", "end"}, nil)
+	var gotConfig race.Config
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		gotConfig = c
+		return mockRacer
+	}
+	handler := http.HandlerFunc(raceHandler(newRacer))
+
+	req, err := http.NewRequest("GET", "/race?starttitle=start&endtitle=end&edges=link|category", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	mockRacer.AssertNumberOfCalls(t, "Run", 1)
+	if !reflect.DeepEqual(gotConfig.Edges, []race.EdgeKind{race.LinkEdge, race.CategoryEdge}) {
+		t.Errorf("edges should be link and category but are %v", gotConfig.Edges)
+	}
+	var output struct {
+		Edges []race.EdgeKind `json:"edges"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.Edges) != 2 {
+		t.Errorf("every hop should be tagged with its edge, got %v", output.Edges)
+	}
+	if path := requestCache[newRequestInfo("start", "end", []int{0})]; path[1] != "middle" {
+		t.Errorf("paths through categories shouldn't be cached, but the cache holds %v", path)
+	}
+
+	req, err = http.NewRequest("GET", "/race?starttitle=start&endtitle=end&edges=hyperlink", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestRaceHandlerErrorKinds(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{&race.Error{Kind: race.PageNotFound, Message: "the page start does not exist"}, http.StatusNotFound},
+		{&race.Error{Kind: race.UpstreamUnavailable, Message: "down", Err: errors.New("dial tcp: secret")}, http.StatusBadGateway},
+		{&race.Error{Kind: race.RateLimited, Message: "slow down"}, http.StatusTooManyRequests},
+		{&race.Error{Kind: race.Cancelled, Message: "cancelled"}, statusClientClosedRequest},
+		{errors.New("secret"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		requestCache = make(map[requestInfo][]string)
+		req, err := http.NewRequest("GET", "/race?starttitle=start&endtitle=end", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mockRacer := new(mocks.Racer)
+		mockRacer.On("Run").Return(nil, test.err)
+		newRacer := func(a, b string, c race.Config) race.Racer {
+			return mockRacer
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(raceHandler(newRacer)).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.status {
+			t.Errorf("handler returned wrong status code for %v: got %v want %v",
+				test.err, status, test.status)
+		}
+		var output errorOutput
+		if err := json.Unmarshal(rr.Body.Bytes(), &output); err != nil {
+			t.Fatal(err)
+		}
+		if output.Error.Kind == "" || strings.Contains(output.Error.Message, "secret") {
+			t.Errorf("the error should have a kind and no internal details, got %+v", output)
+		}
+	}
+}
+
+// blockingRacer runs until it is cancelled.
+type blockingRacer struct {
+	cancelled chan struct{}
+}
+
+func (b *blockingRacer) Run() ([]string, error) {
+	<-b.cancelled
+	return nil, &race.Error{Kind: race.Cancelled, Message: "the race was cancelled"}
+}
+
+func (b *blockingRacer) Cancel() {
+	close(b.cancelled)
+}
+
+func TestRaceHandlerCancelledOnDisconnect(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest("GET", "/race?starttitle=start&endtitle=end", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	racer := &blockingRacer{cancelled: make(chan struct{})}
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		return racer
+	}
+	rr := httptest.NewRecorder()
+	cancel()
+	http.HandlerFunc(raceHandler(newRacer)).ServeHTTP(rr, req.WithContext(ctx))
+
+	if status := rr.Code; status != statusClientClosedRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, statusClientClosedRequest)
+	}
+}
+
+func TestGRPCRaceCancelled(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	racer := &blockingRacer{cancelled: make(chan struct{})}
+	client, stop := dialGRPC(t, &grpcServer{newRacer: func(a, b string, c race.Config) race.Racer {
+		return racer
+	}})
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	client.Race(ctx, &rpc.RaceRequest{StartTitle: "start", EndTitle: "end"})
+	select {
+	case <-racer.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("the race should be cancelled once its client goes away")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	defer func(s *raceSet) { inFlight = s }(inFlight)
+	inFlight = newRaceSet()
+
+	// end can't be reached, so the race runs until it is stopped
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> middle\nother -> end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+	server := httptest.NewServer(fakeWikiHandler(wiki))
+	defer server.Close()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(server.URL + "/race?starttitle=start&endtitle=end&timelimit=1m")
+		if err != nil {
+			t.Error(err)
+		}
+		responses <- resp
+	}()
+	for running := 0; running == 0; time.Sleep(10 * time.Millisecond) {
+		inFlight.Lock()
+		running = len(inFlight.racers)
+		inFlight.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := Shutdown(ctx, server.Config, nil); err != nil {
+		t.Errorf("the server should shut down cleanly but got %+v", err)
+	}
+
+	resp := <-responses
+	if resp == nil {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	var output struct {
+		Path    []string     `json:"path"`
+		Message string       `json:"message"`
+		Partial race.Partial `json:"partial"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.Path) != 0 || output.Message != stoppedMessage {
+		t.Errorf("unexpected response to a stopped race %+v", output)
+	}
+	if output.Partial.ForwardPages < 1 || output.Partial.BackwardPages < 1 {
+		t.Errorf("a stopped race should report how far it got, got %v", output.Partial)
+	}
+
+	if _, err := runRace(race.NewRacer, "start", "end", race.DefaultConfig(), true, false, false, nil); race.AsError(err).Kind != race.ShuttingDown {
+		t.Errorf("no race should start after shutting down but got %v", err)
+	}
+}
+
+func TestDistributedRace(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> a | b\na -> c\nb -> c\nc -> end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the nodes read the graph instead of querying a wiki
+	links := func(task race.Task, config race.Config) (race.Result, error) {
+		result := race.Result{Task: task, Missing: !g.Exists(task.Title), Neighbors: g.Links(task.Title)}
+		if task.Backward {
+			result.Neighbors = g.LinksHere(task.Title)
+		}
+		return result, nil
+	}
+	coordinator := httptest.NewServer(NewRouter())
+	defer coordinator.Close()
+
+	resp, err := http.Get(coordinator.URL + "/race?starttitle=start&endtitle=end&distributed=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("distributed races without node keys should be refused, got %d", resp.StatusCode)
+	}
+	defer func(k []string) { nodeKeys = k }(nodeKeys)
+	nodeKeys = []string{"node secret"}
+	cluster := remoteCluster{url: coordinator.URL, key: "node secret", client: http.DefaultClient}
+
+	for key, status := range map[string]int{"": http.StatusUnauthorized, "node secret": http.StatusNoContent} {
+		resp, err = (remoteCluster{url: coordinator.URL, key: key, client: http.DefaultClient}).post("/cluster/lease", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("a lease without races with key %q should be a %d, got %d", key, status, resp.StatusCode)
+		}
+	}
+
+	ctx, stopNodes := context.WithCancel(context.Background())
+	var nodes sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		nodes.Add(1)
+		go func() {
+			defer nodes.Done()
+			race.RunNode(ctx, cluster, links, 2)
+		}()
+	}
+	defer func() {
+		stopNodes()
+		nodes.Wait()
+	}()
+
+	resp, err = http.Get(coordinator.URL + "/race?starttitle=start&endtitle=end&distributed=1&timelimit=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output struct {
+		Path []string `json:"path"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output.Path) != 4 || output.Path[0] != "start" || output.Path[2] != "c" || output.Path[3] != "end" {
+		t.Errorf("expected a path through a or b and c, got %v", output.Path)
+	}
+
+	resp, err = http.Get(coordinator.URL + "/race?starttitle=start&endtitle=end&distributed=1&edges=category")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("distributed races which follow categories should be refused, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	if err := LoadKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	adminKey = "admin secret"
+	defer func() {
+		keys = nil
+		adminKey = ""
+	}()
+
+	release := make(chan struct{})
+	router := mux.NewRouter()
+	router.HandleFunc("/race", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}).Name("race")
+	router.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) {})
+	releaseBackground := make(chan struct{})
+	backgroundDone := make(chan struct{})
+	router.HandleFunc("/background", func(w http.ResponseWriter, r *http.Request) {
+		finish := keepRaceSlot(r)
+		go func() {
+			<-releaseBackground
+			finish()
+			close(backgroundDone)
+		}()
+	}).Name("createGame")
+	router.HandleFunc("/admin/keys", requireAdmin(listKeysHandler)).Methods("GET")
+	router.HandleFunc("/admin/keys", requireAdmin(createKeyHandler)).Methods("POST")
+	router.HandleFunc("/admin/keys/{id}", requireAdmin(updateKeyHandler)).Methods("PUT")
+	router.HandleFunc("/admin/keys/{id}", requireAdmin(deleteKeyHandler)).Methods("DELETE")
+	server := httptest.NewServer(ApplyMiddleware(router))
+	defer server.Close()
+
+	request := func(method string, path string, key string, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	expectStatus := func(resp *http.Response, status int, what string) {
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected status %d, got %d", what, status, resp.StatusCode)
+		}
+	}
+
+	expectStatus(request("GET", "/other", "", ""), http.StatusUnauthorized, "a request without a key")
+	expectStatus(request("POST", "/admin/keys", "wrong", `{"name": "alice"}`), http.StatusUnauthorized, "creating a key without the admin key")
+	resp := request("POST", "/admin/keys", "admin secret", `{"name": "alice", "max_concurrent_races": 1, "daily_races": 2}`)
+	var created keyOutput
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(resp, http.StatusCreated, "creating a key")
+	alice := created.Key
+	expectStatus(request("GET", "/other", alice, ""), http.StatusOK, "a request with a key")
+
+	// alice may only run one race at once
+	finished := make(chan struct{})
+	go func() {
+		expectStatus(request("GET", "/race", alice, ""), http.StatusOK, "the first race")
+		close(finished)
+	}()
+	for running := 0; running == 0; {
+		resp := request("GET", "/admin/keys", "admin secret", "")
+		var listed []keyOutput
+		if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		running = listed[0].RunningRaces
+	}
+	expectStatus(request("GET", "/race", alice, ""), http.StatusTooManyRequests, "a second race at once")
+	close(release)
+	<-finished
+
+	// and two races a day
+	expectStatus(request("GET", "/race", alice, ""), http.StatusOK, "the second race of the day")
+	resp = request("GET", "/race", alice, "")
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("a request over the daily quota should say when to retry")
+	}
+	expectStatus(resp, http.StatusTooManyRequests, "the third race of the day")
+
+	expectStatus(request("PUT", "/admin/keys/"+created.ID, "admin secret", `{"rate_per_minute": 1}`), http.StatusOK, "changing a key")
+	expectStatus(request("GET", "/race", alice, ""), http.StatusOK, "a race without a daily quota")
+	expectStatus(request("GET", "/other", alice, ""), http.StatusTooManyRequests, "a request over the rate limit")
+
+	// keys survive restarts, but their secrets aren't stored
+	value, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(value), alice) {
+		t.Error("the keys file should not contain the secret")
+	}
+	if err := LoadKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(request("GET", "/other", alice, ""), http.StatusOK, "a request after reloading the keys")
+
+	// races which continue after the response still count
+	expectStatus(request("PUT", "/admin/keys/"+created.ID, "admin secret", `{"max_concurrent_races": 1}`), http.StatusOK, "changing a key")
+	expectStatus(request("GET", "/background", alice, ""), http.StatusOK, "a race in the background")
+	expectStatus(request("GET", "/race", alice, ""), http.StatusTooManyRequests, "a race while one runs in the background")
+	close(releaseBackground)
+	<-backgroundDone
+	expectStatus(request("GET", "/race", alice, ""), http.StatusOK, "a race once the background race finished")
+
+	expectStatus(request("DELETE", "/admin/keys/"+created.ID, "admin secret", ""), http.StatusNoContent, "deleting a key")
+	expectStatus(request("GET", "/other", alice, ""), http.StatusUnauthorized, "a request with a deleted key")
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := LoadKeys(filepath.Join(dir, "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { keys = nil }()
+	k := &apiKey{ID: "1", Hash: hashKey("secret"), keyLimits: keyLimits{DailyRaces: 1}}
+	keys.keys[k.Hash] = k
+
+	mockRacer := new(mocks.Racer)
+	mockRacer.On("Run").Return([]string{"start", "end"}, nil)
+	client, stop := dialGRPC(t, &grpcServer{newRacer: func(a, b string, c race.Config) race.Racer {
+		return mockRacer
+	}})
+	defer stop()
+
+	req := &rpc.RaceRequest{StartTitle: "start", EndTitle: "end", NoCache: true}
+	for _, test := range []struct {
+		key  string
+		code codes.Code
+	}{
+		{"", codes.Unauthenticated},
+		{"wrong", codes.Unauthenticated},
+		{"secret", codes.OK},
+		// the key may only run one race a day
+		{"secret", codes.ResourceExhausted},
+	} {
+		ctx := context.Background()
+		if test.key != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+test.key)
+		}
+		if _, err := client.Race(ctx, req); status.Code(err) != test.code {
+			t.Errorf("a race with key %q should return %v, got %v", test.key, test.code, err)
+		}
+	}
+	if running := keys.usage[k.ID].running; running != 0 {
+		t.Errorf("finished races should not be running, got %d", running)
+	}
+}
+
+// openAPIPath converts a mux route pattern to an OpenAPI path by removing the
+// regular expressions of its variables.
+func openAPIPath(pattern string) string {
+	var path strings.Builder
+	depth := 0
+	inRegexp := false
+	for _, c := range pattern {
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				inRegexp = false
+			}
+		case c == ':' && depth == 1:
+			inRegexp = true
+		}
+		if !inRegexp || (c == '}' && depth == 0) {
+			path.WriteRune(c)
+		}
+	}
+	return path.String()
+}
+
+// specProperties returns the properties of the schema called name.
+func specProperties(t *testing.T, spec map[string]interface{}, name string) map[string]interface{} {
+	schema, ok := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name].(map[string]interface{})
+	if !ok {
+		t.Fatalf("the spec has no schema %s", name)
+	}
+	return schema["properties"].(map[string]interface{})
+}
+
+// checkSchema checks that every key of output is a property of the schema
+// called name.
+func checkSchema(t *testing.T, spec map[string]interface{}, name string, output map[string]interface{}) {
+	properties := specProperties(t, spec, name)
+	for key := range output {
+		if _, ok := properties[key]; !ok {
+			t.Errorf("%s is missing from the %s schema", key, name)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	server := httptest.NewServer(ApplyMiddleware(NewRouter()))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&spec)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := spec["paths"].(map[string]interface{})
+	operations := make(map[string]bool)
+	for path, item := range paths {
+		for method, operation := range item.(map[string]interface{}) {
+			operations[strings.ToUpper(method)+" "+path] = true
+			if id := operation.(map[string]interface{})["operationId"]; id == nil {
+				t.Errorf("%s %s has no operationId", method, path)
+			}
+		}
+	}
+	for _, route := range routes {
+		key := route.Method + " " + openAPIPath(route.Pattern)
+		item, _ := paths[openAPIPath(route.Pattern)].(map[string]interface{})
+		operation, ok := item[strings.ToLower(route.Method)].(map[string]interface{})
+		if !ok {
+			t.Errorf("the route %s (%s) is missing from the spec", route.Name, key)
+			continue
+		}
+		if operation["operationId"] != route.Name {
+			t.Errorf("the operationId of %s should be %s, got %v", key, route.Name, operation["operationId"])
+		}
+		delete(operations, key)
+	}
+	for operation := range operations {
+		t.Errorf("%s is in the spec but isn't a route", operation)
+	}
+
+	// the responses of the handlers match the spec
+	requestCache = make(map[requestInfo][]string)
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> a\na -> end\nx -> y\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+	raceServer := httptest.NewServer(fakeWikiHandler(wiki))
+	defer raceServer.Close()
+	get := func(url string) map[string]interface{} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var output map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+			t.Fatal(err)
+		}
+		return output
+	}
+
+	found := get(raceServer.URL + "/race?starttitle=start&endtitle=end")
+	checkSchema(t, spec, "RaceResult", found)
+	checkSchema(t, spec, "Config", found["config"].(map[string]interface{}))
+	notFound := get(raceServer.URL + "/race?starttitle=start&endtitle=y&timelimit=100ms")
+	checkSchema(t, spec, "RaceResult", notFound)
+	checkSchema(t, spec, "Partial", notFound["partial"].(map[string]interface{}))
+	failed := get(raceServer.URL + "/race?starttitle=start")
+	checkSchema(t, spec, "Error", failed)
+	for key := range failed["error"].(map[string]interface{}) {
+		if key != "kind" && key != "message" {
+			t.Errorf("unexpected error key %s", key)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/verify?path=start|x", nil)
+	verifyHandler(func(path []string, c race.Config) (int, error) { return 0, nil })(rr, req)
+	var verification map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&verification); err != nil {
+		t.Fatal(err)
+	}
+	checkSchema(t, spec, "Verification", verification)
+	if verification["valid"] != false || verification["broken_hop"] != 0.0 {
+		t.Errorf("the path should be broken at its first hop, got %v", verification)
+	}
+}
+
+func TestClient(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> a\na -> end\nb -> end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewServer(g)
+	defer wiki.Close()
+	newRacer := func(a, b string, c race.Config) race.Racer {
+		c.APIURL = wiki.APIURL()
+		return race.NewRacer(a, b, c)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/race", raceHandler(newRacer))
+	router.HandleFunc("/races/batch", batchHandler(newRacer))
+	router.HandleFunc("/suggest", suggestHandler(func(q string, n int, c race.Config) ([]string, error) {
+		c.APIURL = wiki.APIURL()
+		return race.Suggest(q, n, c)
+	}))
+	router.HandleFunc("/verify", verifyHandler(func(path []string, c race.Config) (int, error) {
+		c.APIURL = wiki.APIURL()
+		return race.VerifyPath(path, c)
+	}))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	result, err := c.RunRace(ctx, "start", "end", client.RaceOptions{TimeLimit: 10 * time.Second, Seed: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Path, []string{"start", "a", "end"}) || result.Config.Seed != 7 || result.Config.TimeLimit != "10s" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	_, err = c.RunRace(ctx, "start", "start", client.RaceOptions{})
+	if e, ok := err.(*client.Error); !ok || e.StatusCode != http.StatusBadRequest || e.Kind != "invalid_input" {
+		t.Errorf("expected an invalid_input error, got %v", err)
+	}
+
+	verification, err := c.Verify(ctx, []string{"start", "a", "b", "end"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.Valid || verification.BrokenHop != 1 {
+		t.Errorf("the path should be broken at a, got %+v", verification)
+	}
+
+	pairs := []client.Pair{{StartTitle: "start", EndTitle: "end"}, {StartTitle: "b", EndTitle: "b"}}
+	results := make(map[int]client.BatchResult)
+	err = c.Batch(ctx, pairs, client.RaceOptions{TimeLimit: 10 * time.Second}, func(result client.BatchResult) error {
+		results[result.Index] = result
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || len(results[0].Path) != 3 || results[1].Error == "" {
+		t.Errorf("unexpected batch results %+v", results)
+	}
+
+	_, err = c.RunRace(ctx, "strt", "end", client.RaceOptions{})
+	if e, ok := err.(*client.Error); !ok || e.Kind != "page_not_found" || !reflect.DeepEqual(e.Suggestions["strt"], []string{"start"}) {
+		t.Errorf("expected start to be suggested, got %+v", err)
+	}
+	suggestions, err := c.Suggest(ctx, "en", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(suggestions, []string{"end"}) {
+		t.Errorf("unexpected suggestions %q", suggestions)
+	}
+}
+
+func TestRaceStream(t *testing.T) {
+	requestCache = make(map[requestInfo][]string)
+	g, err := fakewiki.ParseGraph(strings.NewReader("start -> middle\nmiddle -> end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := fakewiki.NewUnstartedServer(g)
+	wiki.Latency = 20 * time.Millisecond
+	wiki.Start()
+	defer wiki.Close()
+	defer func(interval time.Duration) { progressInterval = interval }(progressInterval)
+	progressInterval = 5 * time.Millisecond
+	server := httptest.NewServer(fakeWikiHandler(wiki))
+	defer server.Close()
+	c := client.New(server.URL)
+
+	var updates []client.RaceProgress
+	result, err := c.StreamRace(context.Background(), "start", "end", client.RaceOptions{}, func(progress client.RaceProgress) error {
+		updates = append(updates, progress)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) == 0 || updates[len(updates)-1].ForwardPages < 1 {
+		t.Errorf("expected progress before the result but got %v", updates)
+	}
+	if !reflect.DeepEqual(result.Path, []string{"start", "middle", "end"}) || result.ID == "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	_, err = c.StreamRace(context.Background(), "start", "missing", client.RaceOptions{NoCache: true}, func(client.RaceProgress) error {
+		return nil
+	})
+	if e, ok := err.(*client.Error); !ok || e.Kind != "page_not_found" {
+		t.Errorf("expected a page_not_found error, got %v", err)
+	}
+}
+
+func TestUI(t *testing.T) {
+	server := httptest.NewServer(ApplyMiddleware(NewRouter()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/ui/" || !strings.Contains(string(body), `data-api-url="`+race.DefaultConfig().APIURL+`"`) {
+		t.Errorf("/ should lead to the UI, which should know the API URL, got %s", body)
+	}
+
+	resp, err = http.Get(server.URL + "/ui/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "javascript") {
+		t.Errorf("expected the script, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	resp, err = http.Get(server.URL + "/ui/races/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("paths which aren't files should serve the page, got %d", resp.StatusCode)
+	}
+}